@@ -0,0 +1,182 @@
+package i2p
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"golang.org/x/time/rate"
+)
+
+// BandwidthLimit caps throughput in bytes per second, zero leaves a
+// direction unlimited
+type BandwidthLimit struct {
+	ReadBytesPerSecond  int
+	WriteBytesPerSecond int
+
+	// Burst is the most that is read or written at once at full speed. It
+	// defaults to one second's worth of the rate.
+	Burst int
+}
+
+func (l BandwidthLimit) validate() error {
+	if l.ReadBytesPerSecond < 0 || l.WriteBytesPerSecond < 0 || l.Burst < 0 {
+		return errors.New("bandwidth limits must not be negative")
+	}
+	return nil
+}
+
+// bandwidthLimiter is a token bucket for each direction
+type bandwidthLimiter struct {
+	read  *rate.Limiter
+	write *rate.Limiter
+}
+
+func newBandwidthLimiter(limit BandwidthLimit) *bandwidthLimiter {
+	b := &bandwidthLimiter{
+		read:  rate.NewLimiter(rate.Inf, 0),
+		write: rate.NewLimiter(rate.Inf, 0),
+	}
+	b.set(limit)
+	return b
+}
+
+// set changes the limits, taking effect for waits that start afterwards
+func (b *bandwidthLimiter) set(limit BandwidthLimit) {
+	setLimiter(b.read, limit.ReadBytesPerSecond, limit.Burst)
+	setLimiter(b.write, limit.WriteBytesPerSecond, limit.Burst)
+}
+
+func setLimiter(limiter *rate.Limiter, bytesPerSecond, burst int) {
+	if bytesPerSecond == 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	if burst == 0 {
+		burst = bytesPerSecond
+	}
+	limiter.SetBurst(burst)
+	limiter.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+func (b *bandwidthLimiter) limiter(write bool) *rate.Limiter {
+	if write {
+		return b.write
+	}
+	return b.read
+}
+
+// shaper applies the connection's own limits and those it shares with the
+// other connections of the transport
+type shaper struct {
+	conn      *bandwidthLimiter
+	transport *bandwidthLimiter
+
+	// cancelled when the connection closes, ending waits
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newShaper(connLimit BandwidthLimit, transport *bandwidthLimiter) *shaper {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &shaper{
+		conn:      newBandwidthLimiter(connLimit),
+		transport: transport,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// chunk returns how much of n bytes may be passed on at once, a wait for
+// more than the burst of a limiter could never be satisfied
+func (s *shaper) chunk(n int, write bool) int {
+	for _, limiter := range []*rate.Limiter{s.conn.limiter(write), s.transport.limiter(write)} {
+		if limiter.Limit() != rate.Inf {
+			n = min(n, max(limiter.Burst(), 1))
+		}
+	}
+	return n
+}
+
+// wait blocks until n bytes fit both limits
+func (s *shaper) wait(n int, write bool) error {
+	for _, limiter := range []*rate.Limiter{s.conn.limiter(write), s.transport.limiter(write)} {
+		if limiter.Limit() == rate.Inf {
+			continue
+		}
+		// the burst may have shrunk since chunk was called
+		for n > 0 {
+			step := min(n, max(limiter.Burst(), 1))
+			if err := limiter.WaitN(s.ctx, step); err != nil {
+				if s.ctx.Err() != nil {
+					return net.ErrClosed
+				}
+				return err
+			}
+			n -= step
+		}
+	}
+	return nil
+}
+
+// WithBandwidthLimit caps the combined throughput of all I2P connections of
+// the transport, see SetBandwidthLimit to change it later
+func WithBandwidthLimit(limit BandwidthLimit) Option {
+	return func(i2p *I2PTransport) error {
+		if err := limit.validate(); err != nil {
+			return err
+		}
+		i2p.bandwidth.set(limit)
+		return nil
+	}
+}
+
+// WithConnectionBandwidthLimit caps the throughput of each I2P connection,
+// see SetConnectionBandwidthLimit to change it later
+func WithConnectionBandwidthLimit(limit BandwidthLimit) Option {
+	return func(i2p *I2PTransport) error {
+		if err := limit.validate(); err != nil {
+			return err
+		}
+		i2p.connBandwidth = limit
+		return nil
+	}
+}
+
+// SetBandwidthLimit changes the cap on the combined throughput of all
+// connections, open ones included
+func (i2p *I2PTransport) SetBandwidthLimit(limit BandwidthLimit) error {
+	if err := limit.validate(); err != nil {
+		return err
+	}
+	i2p.bandwidth.set(limit)
+	return nil
+}
+
+// SetConnectionBandwidthLimit changes the cap on the throughput of each
+// connection, for open ones as well as those opened later
+func (i2p *I2PTransport) SetConnectionBandwidthLimit(limit BandwidthLimit) error {
+	if err := limit.validate(); err != nil {
+		return err
+	}
+	i2p.mu.Lock()
+	defer i2p.mu.Unlock()
+	i2p.connBandwidth = limit
+	for conn := range i2p.conns {
+		conn.shaper.conn.set(limit)
+	}
+	return nil
+}
+
+// SetBandwidthLimit changes the cap on this connection's throughput. It
+// holds until the transport's connection limit is changed.
+func (c *Connection) SetBandwidthLimit(limit BandwidthLimit) error {
+	if err := limit.validate(); err != nil {
+		return err
+	}
+	if c.shaper == nil {
+		return errors.New("connection was not created by an I2PTransport")
+	}
+	c.shaper.conn.set(limit)
+	return nil
+}
@@ -0,0 +1,166 @@
+package i2p
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newShapedConnection is newTestConnection with a shaper applying limit
+func newShapedConnection(t *testing.T, limit BandwidthLimit) (*Connection, net.Conn) {
+	t.Helper()
+	conn, remote := newTestConnection(t)
+	conn.shaper = newShaper(limit, newBandwidthLimiter(BandwidthLimit{}))
+	return conn, remote
+}
+
+// timeWrite writes size bytes to conn while remote drains them
+func timeWrite(t *testing.T, conn io.Writer, remote io.Reader, size int) time.Duration {
+	t.Helper()
+	drained := make(chan int64)
+	go func() {
+		n, _ := io.CopyN(io.Discard, remote, int64(size))
+		drained <- n
+	}()
+	start := time.Now()
+	n, err := conn.Write(make([]byte, size))
+	require.NoError(t, err)
+	require.Equal(t, size, n)
+	require.EqualValues(t, size, <-drained)
+	return time.Since(start)
+}
+
+func TestConnectionWriteLimit(t *testing.T) {
+	conn, remote := newShapedConnection(t, BandwidthLimit{WriteBytesPerSecond: 64 << 10, Burst: 8 << 10})
+
+	// the first burst goes out at once, the rest at 64 KiB/s
+	elapsed := timeWrite(t, conn, remote, 40<<10)
+	assert.GreaterOrEqual(t, elapsed, 450*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+}
+
+func TestConnectionReadLimit(t *testing.T) {
+	conn, remote := newShapedConnection(t, BandwidthLimit{ReadBytesPerSecond: 64 << 10, Burst: 8 << 10})
+
+	go remote.Write(make([]byte, 40<<10))
+	start := time.Now()
+	n, err := io.CopyN(io.Discard, conn, 40<<10)
+	require.NoError(t, err)
+	require.EqualValues(t, 40<<10, n)
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 450*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+}
+
+func TestConnectionBandwidthLimitAtRuntime(t *testing.T) {
+	conn, remote := newShapedConnection(t, BandwidthLimit{})
+	assert.Less(t, timeWrite(t, conn, remote, 40<<10), 200*time.Millisecond)
+
+	require.NoError(t, conn.SetBandwidthLimit(BandwidthLimit{WriteBytesPerSecond: 64 << 10, Burst: 8 << 10}))
+	assert.GreaterOrEqual(t, timeWrite(t, conn, remote, 40<<10), 450*time.Millisecond)
+
+	require.NoError(t, conn.SetBandwidthLimit(BandwidthLimit{}))
+	assert.Less(t, timeWrite(t, conn, remote, 40<<10), 200*time.Millisecond)
+
+	assert.Error(t, conn.SetBandwidthLimit(BandwidthLimit{WriteBytesPerSecond: -1}))
+	plain, _ := newTestConnection(t)
+	assert.Error(t, plain.SetBandwidthLimit(BandwidthLimit{}))
+}
+
+func TestConnectionCloseEndsBandwidthWait(t *testing.T) {
+	conn, remote := newShapedConnection(t, BandwidthLimit{WriteBytesPerSecond: 1, Burst: 1})
+	go io.Copy(io.Discard, remote)
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 16))
+		written <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, conn.Close())
+	select {
+	case err := <-written:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(time.Second):
+		t.Fatal("write still waiting for bandwidth after Close")
+	}
+}
+
+func TestBandwidthLimitOptions(t *testing.T) {
+	i2p := &I2PTransport{bandwidth: newBandwidthLimiter(BandwidthLimit{})}
+	assert.Error(t, WithBandwidthLimit(BandwidthLimit{Burst: -1})(i2p))
+	assert.Error(t, WithConnectionBandwidthLimit(BandwidthLimit{ReadBytesPerSecond: -1})(i2p))
+
+	limit := BandwidthLimit{ReadBytesPerSecond: 1000, WriteBytesPerSecond: 2000}
+	require.NoError(t, WithBandwidthLimit(limit)(i2p))
+	assert.EqualValues(t, 1000, i2p.bandwidth.read.Limit())
+	assert.Equal(t, 1000, i2p.bandwidth.read.Burst(), "burst defaults to a second's worth")
+	assert.EqualValues(t, 2000, i2p.bandwidth.write.Limit())
+
+	require.NoError(t, WithConnectionBandwidthLimit(limit)(i2p))
+	assert.Equal(t, limit, i2p.connBandwidth)
+}
+
+// timeEcho sends size bytes over a new stream of conn and reads them back
+// from an echo server
+func timeEcho(t *testing.T, conn network.MuxedConn, size int) time.Duration {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := conn.OpenStream(ctx)
+	require.NoError(t, err)
+	defer stream.Close()
+
+	payload := bytes.Repeat([]byte("i2p"), size/3)
+	start := time.Now()
+	go func() {
+		stream.Write(payload)
+		stream.CloseWrite()
+	}()
+	echoed, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	require.Equal(t, payload, echoed)
+	return time.Since(start)
+}
+
+func TestTransportBandwidthLimit(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	server, serverID := newStandInTransport(t, standIn)
+	client, _ := newStandInTransport(t, standIn, WithConnectionBandwidthLimit(BandwidthLimit{
+		WriteBytesPerSecond: 128 << 10,
+		Burst:               16 << 10,
+	}))
+
+	listener, err := server.Listen(standInListenAddr(t, server))
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveEcho(listener)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, listener.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the first burst goes out at once, the rest at 128 KiB/s
+	elapsed := timeEcho(t, conn, 96<<10)
+	assert.GreaterOrEqual(t, elapsed, 550*time.Millisecond)
+	assert.Less(t, elapsed, 5*time.Second)
+
+	// limits apply to the open connection once changed
+	require.NoError(t, client.SetConnectionBandwidthLimit(BandwidthLimit{}))
+	require.NoError(t, client.SetBandwidthLimit(BandwidthLimit{WriteBytesPerSecond: 64 << 10, Burst: 16 << 10}))
+	elapsed = timeEcho(t, conn, 48<<10)
+	assert.GreaterOrEqual(t, elapsed, 450*time.Millisecond)
+	assert.Less(t, elapsed, 5*time.Second)
+
+	require.NoError(t, client.SetBandwidthLimit(BandwidthLimit{}))
+	assert.Less(t, timeEcho(t, conn, 96<<10), 500*time.Millisecond)
+}
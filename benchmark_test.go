@@ -0,0 +1,182 @@
+package i2p
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// benchmarkLinks are the tunnels the benchmarks run over. Client tunnels on
+// a healthy router see round trips of a few hundred milliseconds and tens to
+// hundreds of KiB/s, the loopback link shows the transport's own overhead.
+var benchmarkLinks = []struct {
+	name string
+	link standInLink
+}{
+	{"loopback", standInLink{}},
+	{"fast-tunnel", standInLink{latency: 50 * time.Millisecond, bandwidth: 1 << 20}},
+	{"slow-tunnel", standInLink{latency: 250 * time.Millisecond, bandwidth: 64 << 10}},
+}
+
+// benchmarkPair is a client transport and a server listening on the same
+// stand-in
+type benchmarkPair struct {
+	client   *I2PTransport
+	listener transport.Listener
+	serverID peer.ID
+}
+
+func newBenchmarkPair(b *testing.B, link standInLink, serve func(network.MuxedStream)) *benchmarkPair {
+	b.Helper()
+	standIn := newSAMStandIn(b, withStandInLink(link))
+	server, serverID := newStandInTransport(b, standIn)
+	client, _ := newStandInTransport(b, standIn)
+
+	listener, err := server.Listen(standInListenAddr(b, server))
+	require.NoError(b, err)
+	b.Cleanup(func() { listener.Close() })
+	go serveStreams(listener, serve)
+	return &benchmarkPair{client: client, listener: listener, serverID: serverID}
+}
+
+func (p *benchmarkPair) dial(b *testing.B) transport.CapableConn {
+	b.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	conn, err := p.client.Dial(ctx, p.listener.Multiaddr(), p.serverID)
+	require.NoError(b, err)
+	return conn
+}
+
+// serveStreams hands each stream of each accepted connection to serve
+func serveStreams(listener transport.Listener, serve func(network.MuxedStream)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				stream, err := conn.AcceptStream()
+				if err != nil {
+					return
+				}
+				go serve(stream)
+			}
+		}()
+	}
+}
+
+func echoStream(stream network.MuxedStream) {
+	io.Copy(stream, stream)
+	stream.Close()
+}
+
+// sinkStream reads the stream to the end and closes it, which tells the
+// writer everything arrived
+func sinkStream(stream network.MuxedStream) {
+	io.Copy(io.Discard, stream)
+	stream.Close()
+}
+
+// BenchmarkDial measures establishing an upgraded connection: STREAM
+// CONNECT, the security handshake and the muxer negotiation.
+func BenchmarkDial(b *testing.B) {
+	for _, bench := range benchmarkLinks {
+		b.Run(bench.name, func(b *testing.B) {
+			pair := newBenchmarkPair(b, bench.link, echoStream)
+			for b.Loop() {
+				pair.dial(b).Close()
+			}
+		})
+	}
+}
+
+// BenchmarkOpenStream measures opening a stream on an established
+// connection until the first byte comes back. yamux sends the SYN with the
+// first write, so this is one round trip over the tunnels.
+func BenchmarkOpenStream(b *testing.B) {
+	for _, bench := range benchmarkLinks {
+		b.Run(bench.name, func(b *testing.B) {
+			pair := newBenchmarkPair(b, bench.link, echoStream)
+			conn := pair.dial(b)
+			defer conn.Close()
+			ctx := context.Background()
+			buf := make([]byte, 1)
+			for b.Loop() {
+				stream, err := conn.OpenStream(ctx)
+				require.NoError(b, err)
+				_, err = stream.Write(buf)
+				require.NoError(b, err)
+				_, err = io.ReadFull(stream, buf)
+				require.NoError(b, err)
+				stream.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkThroughput measures bulk transfer over one connection, split
+// across a number of concurrent yamux streams. A transfer counts once the
+// server has read it to the end.
+func BenchmarkThroughput(b *testing.B) {
+	const chunkSize = 16 << 10
+	for _, bench := range benchmarkLinks {
+		for _, streams := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("%s/streams=%d", bench.name, streams), func(b *testing.B) {
+				pair := newBenchmarkPair(b, bench.link, sinkStream)
+				conn := pair.dial(b)
+				defer conn.Close()
+				ctx := context.Background()
+				chunk := make([]byte, chunkSize)
+				b.SetBytes(chunkSize)
+				b.ResetTimer()
+
+				// the streams take chunks until b.N have been written
+				var written atomic.Int64
+				var wg sync.WaitGroup
+				errs := make(chan error, streams)
+				for range streams {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						stream, err := conn.OpenStream(ctx)
+						if err != nil {
+							errs <- err
+							return
+						}
+						defer stream.Close()
+						for written.Add(1) <= int64(b.N) {
+							if _, err := stream.Write(chunk); err != nil {
+								errs <- err
+								return
+							}
+						}
+						if err := stream.CloseWrite(); err != nil {
+							errs <- err
+							return
+						}
+						if _, err := io.Copy(io.Discard, stream); err != nil {
+							errs <- err
+						}
+					}()
+				}
+				wg.Wait()
+				close(errs)
+				for err := range errs {
+					require.NoError(b, err)
+				}
+			})
+		}
+	}
+}
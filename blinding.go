@@ -0,0 +1,268 @@
+package i2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/eyedeekay/sam3"
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"github.com/joomcode/errorx"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	b32Suffix = ".b32.i2p"
+
+	// flag bits of the first byte of a b33 address
+	b33FlagTwoByteSigTypes = 0x01
+	b33FlagSecretRequired  = 0x02
+	b33FlagClientAuth      = 0x04
+
+	// a b33 address is at least the flag, two one-byte sig types and a 32 byte key
+	b33MinDecodedLength = 35
+)
+
+// BlindedAddress is the decoded form of a b33 address, the .b32.i2p name of a
+// destination that publishes an encrypted LeaseSet2. Unlike a regular b32
+// address it carries the destination's signing public key, from which the
+// router derives the blinded key it looks up in the network database.
+// See https://geti2p.net/spec/b32encrypted
+type BlindedAddress struct {
+	SigType        uint16
+	BlindedSigType uint16
+	PublicKey      []byte
+
+	// SecretRequired is set when the LeaseSet can only be looked up with the
+	// password it was blinded with
+	SecretRequired bool
+
+	// ClientAuth is set when the LeaseSet is encrypted for a list of
+	// authorized clients
+	ClientAuth bool
+}
+
+// NewBlindedAddress builds the b33 address for a destination. Only Ed25519
+// destinations can be blinded.
+func NewBlindedAddress(dest i2pkeys.I2PAddr, secretRequired, clientAuth bool) (*BlindedAddress, error) {
+	parsed, err := parseDestination(dest)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.sigType != sigTypeEd25519 && parsed.sigType != sigTypeRedDSAEd25519 {
+		return nil, fmt.Errorf("signature type %d cannot be blinded, an Ed25519 destination is required", parsed.sigType)
+	}
+
+	return &BlindedAddress{
+		SigType:        parsed.sigType,
+		BlindedSigType: sigTypeRedDSAEd25519,
+		PublicKey:      parsed.signingPublicKey,
+		SecretRequired: secretRequired,
+		ClientAuth:     clientAuth,
+	}, nil
+}
+
+// ParseBlindedAddress decodes a b33 address, with or without the .b32.i2p
+// suffix. The checksum is folded into the header bytes, so a corrupted address
+// shows up as unknown flags or signature types.
+func ParseBlindedAddress(addr string) (*BlindedAddress, error) {
+	raw, err := i2pB32Encoding.DecodeString(strings.TrimSuffix(addr, b32Suffix))
+	if err != nil {
+		return nil, fmt.Errorf("blinded address is not valid base32: %w", err)
+	}
+	if len(raw) < b33MinDecodedLength {
+		return nil, fmt.Errorf("blinded address is %d bytes, expected at least %d", len(raw), b33MinDecodedLength)
+	}
+
+	checksum := crc32.ChecksumIEEE(raw[3:])
+	raw[0] ^= byte(checksum)
+	raw[1] ^= byte(checksum >> 8)
+	raw[2] ^= byte(checksum >> 16)
+
+	flags := raw[0]
+	if flags&^(b33FlagTwoByteSigTypes|b33FlagSecretRequired|b33FlagClientAuth) != 0 {
+		return nil, errors.New("blinded address has unknown flags, the checksum is likely wrong")
+	}
+	blinded := &BlindedAddress{
+		SecretRequired: flags&b33FlagSecretRequired != 0,
+		ClientAuth:     flags&b33FlagClientAuth != 0,
+	}
+
+	keyOffset := 3
+	if flags&b33FlagTwoByteSigTypes != 0 {
+		if len(raw) < b33MinDecodedLength+2 {
+			return nil, errors.New("blinded address is too short for two byte signature types")
+		}
+		blinded.SigType = uint16(raw[1])<<8 | uint16(raw[2])
+		blinded.BlindedSigType = uint16(raw[3])<<8 | uint16(raw[4])
+		keyOffset = 5
+	} else {
+		blinded.SigType = uint16(raw[1])
+		blinded.BlindedSigType = uint16(raw[2])
+	}
+
+	keyLength, ok := sigTypePublicKeyLength[blinded.SigType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature type %d in blinded address", blinded.SigType)
+	}
+	if len(raw)-keyOffset != keyLength {
+		return nil, fmt.Errorf("blinded address key is %d bytes, signature type %d needs %d", len(raw)-keyOffset, blinded.SigType, keyLength)
+	}
+	blinded.PublicKey = raw[keyOffset:]
+
+	return blinded, nil
+}
+
+// String returns the b33 address including the .b32.i2p suffix
+func (b *BlindedAddress) String() string {
+	var raw []byte
+	flags := byte(0)
+	if b.SecretRequired {
+		flags |= b33FlagSecretRequired
+	}
+	if b.ClientAuth {
+		flags |= b33FlagClientAuth
+	}
+	if b.SigType > 0xff || b.BlindedSigType > 0xff {
+		flags |= b33FlagTwoByteSigTypes
+		raw = []byte{flags, byte(b.SigType >> 8), byte(b.SigType), byte(b.BlindedSigType >> 8), byte(b.BlindedSigType)}
+	} else {
+		raw = []byte{flags, byte(b.SigType), byte(b.BlindedSigType)}
+	}
+	raw = append(raw, b.PublicKey...)
+
+	// the checksum covers everything past the three bytes it is folded into
+	checksum := crc32.ChecksumIEEE(raw[3:])
+	raw[0] ^= byte(checksum)
+	raw[1] ^= byte(checksum >> 8)
+	raw[2] ^= byte(checksum >> 16)
+
+	return i2pB32Encoding.EncodeToString(raw) + b32Suffix
+}
+
+// blindedCredential holds what we need to look up one blinded destination
+type blindedCredential struct {
+	password string
+	client   *ClientCredential
+}
+
+// sessionOptions returns the I2CP options of the session dialing the
+// destination, which is how the router learns the credentials
+func (c blindedCredential) sessionOptions() []string {
+	options := append([]string{}, sam3.Options_Default...)
+	if c.password != "" {
+		options = append(options, "i2cp.leaseSetSecret="+i2pB64Encoding.EncodeToString([]byte(c.password)))
+	}
+	if c.client != nil {
+		options = append(options,
+			"i2cp.leaseSetAuthType="+strconv.Itoa(int(c.client.Type)),
+			"i2cp.leaseSetPrivKey="+i2pB64Encoding.EncodeToString(c.client.Key))
+	}
+	return options
+}
+
+// SetLookupPassword registers the password needed to look up the blinded
+// destination at addr, a /garlic32 b33 address. SAM has no field for lookup
+// credentials on STREAM CONNECT, so dials to it go through a dedicated session
+// whose I2CP options carry the password, and the remote side sees a transient
+// destination rather than ours. An empty password removes it.
+func (i2p *I2PTransport) SetLookupPassword(addr ma.Multiaddr, password string) error {
+	return i2p.updateBlindedCredential(addr, func(credential *blindedCredential) {
+		credential.password = password
+	})
+}
+
+// SetClientCredential registers the credential issued to us by the owner of
+// the blinded destination at addr, for destinations that restrict their
+// LeaseSet to authorized clients. Like SetLookupPassword, dials then go
+// through a dedicated session. A credential without a key removes it.
+func (i2p *I2PTransport) SetClientCredential(addr ma.Multiaddr, credential ClientCredential) error {
+	if len(credential.Key) != 0 && len(credential.Key) != clientKeyLength {
+		return fmt.Errorf("client credential key is %d bytes, expected %d", len(credential.Key), clientKeyLength)
+	}
+	return i2p.updateBlindedCredential(addr, func(blinded *blindedCredential) {
+		if len(credential.Key) == 0 {
+			blinded.client = nil
+		} else {
+			blinded.client = &credential
+		}
+	})
+}
+
+func (i2p *I2PTransport) updateBlindedCredential(addr ma.Multiaddr, update func(*blindedCredential)) error {
+	name, err := MultiAddrToI2PAddr(addr)
+	if err != nil {
+		return err
+	}
+	if _, err := ParseBlindedAddress(name); err != nil {
+		return errorx.Decorate(err, "lookup credentials only apply to blinded addresses")
+	}
+
+	i2p.mu.Lock()
+	defer i2p.mu.Unlock()
+
+	credential := i2p.blindedCredentials[name]
+	update(&credential)
+	if credential.password == "" && credential.client == nil {
+		delete(i2p.blindedCredentials, name)
+	} else {
+		i2p.blindedCredentials[name] = credential
+	}
+
+	// a cached session still carries the previous credentials
+	if existing, ok := i2p.blindedSessions[name]; ok {
+		existing.Close()
+		delete(i2p.blindedSessions, name)
+	}
+	return nil
+}
+
+func (i2p *I2PTransport) blindedCredential(addr string) (blindedCredential, bool) {
+	i2p.mu.RLock()
+	defer i2p.mu.RUnlock()
+
+	credential, ok := i2p.blindedCredentials[addr]
+	return credential, ok
+}
+
+// blindedDialSession returns the session used to dial the blinded destination
+// addr, creating it on first use. Creating a session builds new tunnels, so
+// it is done without holding the lock.
+func (i2p *I2PTransport) blindedDialSession(ctx context.Context, addr string, credential blindedCredential) (*samStreamSession, error) {
+	i2p.mu.RLock()
+	existing, ok := i2p.blindedSessions[addr]
+	i2p.mu.RUnlock()
+	if ok {
+		return existing, nil
+	}
+
+	options := append(credential.sessionOptions(), i2p.keyTypes.sessionOption())
+	session, err := newSAMTransientStreamSession(ctx, i2p.activeSAMConfig(), "blindedSession-"+strconv.Itoa(rand.Int()), i2p.keyTypes.Signature, options)
+	if err != nil {
+		return nil, errorx.Decorate(err, "Failed to create stream session for blinded destination")
+	}
+
+	i2p.mu.Lock()
+	defer i2p.mu.Unlock()
+	if existing, ok := i2p.blindedSessions[addr]; ok {
+		// another dial got there first
+		session.Close()
+		return existing, nil
+	}
+	i2p.blindedSessions[addr] = session
+	return session, nil
+}
+
+func (i2p *I2PTransport) closeBlindedSessions() {
+	i2p.mu.Lock()
+	defer i2p.mu.Unlock()
+
+	for addr, session := range i2p.blindedSessions {
+		session.Close()
+		delete(i2p.blindedSessions, addr)
+	}
+}
@@ -0,0 +1,116 @@
+package i2p
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testEd25519Destination lays out a destination with a key certificate around
+// an Ed25519 signing key seeded from the test name, the encryption key is left
+// as zeroes
+func testEd25519Destination(t *testing.T) (i2pkeys.I2PAddr, ed25519.PublicKey) {
+	t.Helper()
+	seed := sha256.Sum256([]byte(t.Name()))
+	pub := ed25519.NewKeyFromSeed(seed[:]).Public().(ed25519.PublicKey)
+
+	raw := make([]byte, destKeysLength, destMinLength+keyCertPayloadMinSize)
+	copy(raw[destKeysLength-ed25519.PublicKeySize:], pub)
+	raw = append(raw, certTypeKey, 0, keyCertPayloadMinSize)
+	raw = binary.BigEndian.AppendUint16(raw, sigTypeEd25519)
+	raw = binary.BigEndian.AppendUint16(raw, encTypeElGamal)
+
+	return i2pkeys.I2PAddr(i2pB64Encoding.EncodeToString(raw)), pub
+}
+
+func TestBlindedAddressRoundTrip(t *testing.T) {
+	dest, pub := testEd25519Destination(t)
+
+	blinded, err := NewBlindedAddress(dest, true, false)
+	require.NoError(t, err)
+
+	addr := blinded.String()
+	assert.True(t, strings.HasSuffix(addr, ".b32.i2p"))
+	assert.Len(t, strings.TrimSuffix(addr, ".b32.i2p"), 56)
+
+	parsed, err := ParseBlindedAddress(addr)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(sigTypeEd25519), parsed.SigType)
+	assert.Equal(t, uint16(sigTypeRedDSAEd25519), parsed.BlindedSigType)
+	assert.Equal(t, []byte(pub), parsed.PublicKey)
+	assert.True(t, parsed.SecretRequired)
+	assert.False(t, parsed.ClientAuth)
+	assert.Equal(t, addr, parsed.String())
+}
+
+func TestBlindedAddressRejectsDSADestination(t *testing.T) {
+	_, err := NewBlindedAddress(base64Addr, false, false)
+	assert.Error(t, err)
+}
+
+func TestParseBlindedAddressRejectsCorruption(t *testing.T) {
+	dest, _ := testEd25519Destination(t)
+	blinded, err := NewBlindedAddress(dest, false, false)
+	require.NoError(t, err)
+	addr := blinded.String()
+
+	// flipping a bit in the key changes the checksum folded into the header
+	corrupted := []byte(addr)
+	if corrupted[20] == 'a' {
+		corrupted[20] = 'b'
+	} else {
+		corrupted[20] = 'a'
+	}
+	_, err = ParseBlindedAddress(string(corrupted))
+	assert.Error(t, err)
+
+	// a regular b32 address is too short to be a b33
+	_, err = ParseBlindedAddress(base32AddrSuffix)
+	assert.Error(t, err)
+}
+
+func TestDialWithLookupPassword(t *testing.T) {
+	dest, _ := testEd25519Destination(t)
+	blinded, err := NewBlindedAddress(dest, true, false)
+	require.NoError(t, err)
+	addr, err := I2PAddrToMultiAddr(blinded.String())
+	require.NoError(t, err)
+
+	standIn := newSAMStandIn(t)
+	client, _ := newStandInTransport(t, standIn, WithLookupPassword(addr, "hunter2"))
+	secretSessions := func(password string) int {
+		count := 0
+		for _, line := range standIn.createdSessions() {
+			if strings.Contains(line, "DESTINATION=TRANSIENT") && strings.Contains(line, "i2cp.leaseSetSecret="+i2pB64Encoding.EncodeToString([]byte(password))) {
+				count++
+			}
+		}
+		return count
+	}
+
+	// the stand-in cannot look up blinded addresses, the dial fails after
+	// the session carrying the password was created
+	_, err = client.Dial(context.Background(), addr, "")
+	assert.Error(t, err)
+	assert.Equal(t, 1, secretSessions("hunter2"))
+
+	// a new password takes a new session
+	require.NoError(t, client.SetLookupPassword(addr, "correct horse"))
+	_, err = client.Dial(context.Background(), addr, "")
+	assert.Error(t, err)
+	assert.Equal(t, 1, secretSessions("correct horse"))
+	assert.Len(t, client.Diagnostics().Sessions.Blinded, 1)
+
+	// without a password the outbound session dials
+	require.NoError(t, client.SetLookupPassword(addr, ""))
+	_, err = client.Dial(context.Background(), addr, "")
+	assert.Error(t, err)
+	assert.Empty(t, client.Diagnostics().Sessions.Blinded)
+}
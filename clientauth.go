@@ -0,0 +1,281 @@
+package i2p
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joomcode/errorx"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ClientAuthType selects how an encrypted LeaseSet is made readable to the
+// clients authorized to fetch it. The values match i2cp.leaseSetAuthType.
+type ClientAuthType int
+
+const (
+	ClientAuthNone ClientAuthType = 0
+	// ClientAuthDH gives every client an X25519 key pair, the LeaseSet is
+	// encrypted to the public keys
+	ClientAuthDH ClientAuthType = 1
+	// ClientAuthPSK gives every client a random pre-shared key
+	ClientAuthPSK ClientAuthType = 2
+)
+
+const clientKeyLength = 32
+
+func (t ClientAuthType) String() string {
+	switch t {
+	case ClientAuthNone:
+		return "none"
+	case ClientAuthDH:
+		return "dh"
+	case ClientAuthPSK:
+		return "psk"
+	}
+	return "unknown(" + strconv.Itoa(int(t)) + ")"
+}
+
+// ClientCredential is what an authorized client needs to decrypt our LeaseSet.
+// Key is the client's X25519 private key for ClientAuthDH, or the pre-shared
+// key for ClientAuthPSK.
+type ClientCredential struct {
+	Name string
+	Type ClientAuthType
+	Key  []byte
+}
+
+// String encodes the credential as "<type>:<base64 key>" so it can be handed
+// to the client, see ParseClientCredential. The name is not included.
+func (c ClientCredential) String() string {
+	return c.Type.String() + ":" + i2pB64Encoding.EncodeToString(c.Key)
+}
+
+// ParseClientCredential decodes a credential produced by
+// ClientCredential.String
+func ParseClientCredential(s string) (ClientCredential, error) {
+	authType, key, found := strings.Cut(s, ":")
+	if !found {
+		return ClientCredential{}, errors.New("client credential must be of the form <type>:<key>")
+	}
+
+	var credential ClientCredential
+	switch authType {
+	case ClientAuthDH.String():
+		credential.Type = ClientAuthDH
+	case ClientAuthPSK.String():
+		credential.Type = ClientAuthPSK
+	default:
+		return ClientCredential{}, fmt.Errorf("unknown client authorization type %q", authType)
+	}
+
+	decoded, err := i2pB64Encoding.DecodeString(key)
+	if err != nil {
+		return ClientCredential{}, fmt.Errorf("client credential key is not valid I2P base64: %w", err)
+	}
+	if len(decoded) != clientKeyLength {
+		return ClientCredential{}, fmt.Errorf("client credential key is %d bytes, expected %d", len(decoded), clientKeyLength)
+	}
+	credential.Key = decoded
+
+	return credential, nil
+}
+
+// leaseSetKey returns the key the server publishes for this client: the
+// public key for DH, the shared key itself for PSK
+func (c ClientCredential) leaseSetKey() ([]byte, error) {
+	if c.Type != ClientAuthDH {
+		return c.Key, nil
+	}
+	private, err := ecdh.X25519().NewPrivateKey(c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return private.PublicKey().Bytes(), nil
+}
+
+func newClientCredential(name string, authType ClientAuthType) (ClientCredential, error) {
+	if err := validateClientName(name); err != nil {
+		return ClientCredential{}, err
+	}
+
+	var key []byte
+	switch authType {
+	case ClientAuthDH:
+		private, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return ClientCredential{}, err
+		}
+		key = private.Bytes()
+	case ClientAuthPSK:
+		key = make([]byte, clientKeyLength)
+		if _, err := rand.Read(key); err != nil {
+			return ClientCredential{}, err
+		}
+	default:
+		return ClientCredential{}, fmt.Errorf("client authorization type %s cannot issue credentials", authType)
+	}
+
+	return ClientCredential{Name: name, Type: authType, Key: key}, nil
+}
+
+// client names end up in I2CP options, which are space separated key=value pairs
+func validateClientName(name string) error {
+	if name == "" {
+		return errors.New("client name must not be empty")
+	}
+	if strings.ContainsAny(name, " \t\r\n=:") {
+		return fmt.Errorf("client name %q must not contain whitespace, '=' or ':'", name)
+	}
+	return nil
+}
+
+// WithClientAuthorization restricts the encrypted LeaseSet to authorized
+// clients, identified by the given credentials. It implies an encrypted
+// LeaseSet. Clients can be managed later with AddAuthorizedClient and
+// RevokeAuthorizedClient.
+func WithClientAuthorization(authType ClientAuthType, clients ...ClientCredential) Option {
+	return func(i2p *I2PTransport) error {
+		if authType != ClientAuthDH && authType != ClientAuthPSK {
+			return fmt.Errorf("unsupported client authorization type %s", authType)
+		}
+		i2p.encryptedLeaseSet = true
+		i2p.clientAuthType = authType
+		for _, client := range clients {
+			if client.Type != authType {
+				return fmt.Errorf("client %q has a %s credential, expected %s", client.Name, client.Type, authType)
+			}
+			if err := validateClientName(client.Name); err != nil {
+				return err
+			}
+			if len(client.Key) != clientKeyLength {
+				return fmt.Errorf("client %q key is %d bytes, expected %d", client.Name, len(client.Key), clientKeyLength)
+			}
+			i2p.authorizedClients[client.Name] = client
+		}
+		return nil
+	}
+}
+
+// WithClientCredential registers the credential used to dial a blinded
+// destination that requires client authorization, see
+// I2PTransport.SetClientCredential.
+func WithClientCredential(addr ma.Multiaddr, credential ClientCredential) Option {
+	return func(i2p *I2PTransport) error {
+		return i2p.SetClientCredential(addr, credential)
+	}
+}
+
+// AddAuthorizedClient issues a credential for a new client and republishes
+// the LeaseSet so the client can fetch it. The returned credential must be
+// handed to the client out of band. Republishing rebuilds the SAM sessions,
+// which drops open I2P connections. When it fails the client is not added.
+func (i2p *I2PTransport) AddAuthorizedClient(name string) (ClientCredential, error) {
+	i2p.mu.RLock()
+	authType := i2p.clientAuthType
+	i2p.mu.RUnlock()
+	if authType == ClientAuthNone {
+		return ClientCredential{}, errors.New("client authorization is not enabled on this transport")
+	}
+
+	credential, err := newClientCredential(name, authType)
+	if err != nil {
+		return ClientCredential{}, err
+	}
+
+	i2p.mu.Lock()
+	if _, exists := i2p.authorizedClients[name]; exists {
+		i2p.mu.Unlock()
+		return ClientCredential{}, fmt.Errorf("client %q is already authorized", name)
+	}
+	i2p.authorizedClients[name] = credential
+	i2p.mu.Unlock()
+
+	undo := func() {
+		i2p.mu.Lock()
+		delete(i2p.authorizedClients, name)
+		i2p.mu.Unlock()
+	}
+	if err := i2p.republish(undo); err != nil {
+		return ClientCredential{}, errorx.Decorate(err, "Failed to republish LeaseSet for new client")
+	}
+	return credential, nil
+}
+
+// RevokeAuthorizedClient removes a client and republishes the LeaseSet
+// without it. When republishing fails the client stays authorized.
+func (i2p *I2PTransport) RevokeAuthorizedClient(name string) error {
+	i2p.mu.Lock()
+	credential, exists := i2p.authorizedClients[name]
+	delete(i2p.authorizedClients, name)
+	i2p.mu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("client %q is not authorized", name)
+	}
+
+	undo := func() {
+		i2p.mu.Lock()
+		i2p.authorizedClients[name] = credential
+		i2p.mu.Unlock()
+	}
+	if err := i2p.republish(undo); err != nil {
+		return errorx.Decorate(err, "Failed to republish LeaseSet after revoking client")
+	}
+	return nil
+}
+
+// AuthorizedClients returns the names of the authorized clients in order
+func (i2p *I2PTransport) AuthorizedClients() []string {
+	i2p.mu.RLock()
+	defer i2p.mu.RUnlock()
+	return i2p.sortedClientNames()
+}
+
+// ExportClientCredential returns the credential of an authorized client, so
+// it can be handed out again or persisted and passed back through
+// WithClientAuthorization on the next start
+func (i2p *I2PTransport) ExportClientCredential(name string) (ClientCredential, error) {
+	i2p.mu.RLock()
+	defer i2p.mu.RUnlock()
+
+	credential, ok := i2p.authorizedClients[name]
+	if !ok {
+		return ClientCredential{}, fmt.Errorf("client %q is not authorized", name)
+	}
+	return credential, nil
+}
+
+// clientAuthOptions returns the I2CP options listing the authorized clients.
+// Must be called with i2p.mu held.
+func (i2p *I2PTransport) clientAuthOptions() []string {
+	if i2p.clientAuthType == ClientAuthNone {
+		return nil
+	}
+
+	options := []string{"i2cp.leaseSetAuthType=" + strconv.Itoa(int(i2p.clientAuthType))}
+	for i, name := range i2p.sortedClientNames() {
+		key, err := i2p.authorizedClients[name].leaseSetKey()
+		if err != nil {
+			// keys are length checked when added and X25519 accepts any 32 bytes
+			continue
+		}
+		options = append(options, fmt.Sprintf("i2cp.leaseSetClient.%s.%d=%s:%s",
+			i2p.clientAuthType, i, name, i2pB64Encoding.EncodeToString(key)))
+	}
+	return options
+}
+
+// sortedClientNames must be called with i2p.mu held
+func (i2p *I2PTransport) sortedClientNames() []string {
+	names := make([]string, 0, len(i2p.authorizedClients))
+	for name := range i2p.authorizedClients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
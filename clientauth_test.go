@@ -0,0 +1,128 @@
+package i2p
+
+import (
+	"context"
+	"crypto/ecdh"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCredentialRoundTrip(t *testing.T) {
+	for _, authType := range []ClientAuthType{ClientAuthDH, ClientAuthPSK} {
+		credential, err := newClientCredential("alice", authType)
+		require.NoError(t, err)
+
+		parsed, err := ParseClientCredential(credential.String())
+		require.NoError(t, err)
+		assert.Equal(t, authType, parsed.Type)
+		assert.Equal(t, credential.Key, parsed.Key)
+	}
+
+	_, err := ParseClientCredential("dh:" + i2pB64Encoding.EncodeToString(make([]byte, 16)))
+	assert.Error(t, err)
+	_, err = ParseClientCredential("rsa:" + i2pB64Encoding.EncodeToString(make([]byte, clientKeyLength)))
+	assert.Error(t, err)
+}
+
+func TestClientAuthSessionOptions(t *testing.T) {
+	alice, err := newClientCredential("alice", ClientAuthDH)
+	require.NoError(t, err)
+	bob, err := newClientCredential("bob", ClientAuthDH)
+	require.NoError(t, err)
+
+	i2p := &I2PTransport{authorizedClients: make(map[string]ClientCredential)}
+	require.NoError(t, WithClientAuthorization(ClientAuthDH, bob, alice)(i2p))
+	assert.Equal(t, []string{"alice", "bob"}, i2p.AuthorizedClients())
+
+	// the router gets the public half of DH credentials
+	private, err := ecdh.X25519().NewPrivateKey(alice.Key)
+	require.NoError(t, err)
+	options := i2p.sessionOptions()
+	assert.Contains(t, options, "i2cp.leaseSetType=5")
+	assert.Contains(t, options, "i2cp.leaseSetAuthType=1")
+	assert.Contains(t, options, "i2cp.leaseSetClient.dh.0=alice:"+i2pB64Encoding.EncodeToString(private.PublicKey().Bytes()))
+	assert.NotContains(t, options, "i2cp.leaseSetClient.dh.0=alice:"+i2pB64Encoding.EncodeToString(alice.Key))
+}
+
+func TestWithClientAuthorizationValidation(t *testing.T) {
+	psk, err := newClientCredential("alice", ClientAuthPSK)
+	require.NoError(t, err)
+
+	i2p := &I2PTransport{authorizedClients: make(map[string]ClientCredential)}
+	assert.Error(t, WithClientAuthorization(ClientAuthNone)(i2p))
+	assert.Error(t, WithClientAuthorization(ClientAuthDH, psk)(i2p))
+	assert.Error(t, WithClientAuthorization(ClientAuthPSK, ClientCredential{Name: "a b", Type: ClientAuthPSK, Key: psk.Key})(i2p))
+	assert.Error(t, WithClientAuthorization(ClientAuthPSK, ClientCredential{Name: "short", Type: ClientAuthPSK, Key: psk.Key[:8]})(i2p))
+}
+
+func TestAuthorizedClientsRollBackWhenRepublishFails(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	server, serverID := newStandInTransport(t, standIn, WithClientAuthorization(ClientAuthDH))
+	client, _ := newStandInTransport(t, standIn)
+
+	listener, err := server.Listen(standInListenAddr(t, server))
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveEcho(listener)
+	// the stand-in cannot look up blinded addresses, dial the destination
+	addr, err := I2PAddrToMultiAddr(string(server.i2PKeys.Addr()))
+	require.NoError(t, err)
+
+	standIn.refuseSessions(func(line string) bool { return strings.Contains(line, "=bob:") })
+	_, err = server.AddAuthorizedClient("bob")
+	assert.Error(t, err)
+	assert.Empty(t, server.AuthorizedClients())
+	requireEcho(t, client, addr, serverID)
+
+	standIn.refuseSessions(nil)
+	_, err = server.AddAuthorizedClient("bob")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, server.AuthorizedClients())
+
+	standIn.refuseSessions(func(line string) bool { return !strings.Contains(line, "=bob:") })
+	assert.Error(t, server.RevokeAuthorizedClient("bob"))
+	assert.Equal(t, []string{"bob"}, server.AuthorizedClients())
+	requireEcho(t, client, addr, serverID)
+}
+
+func TestDialWithClientCredential(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	client, _ := newStandInTransport(t, standIn)
+
+	for _, authType := range []ClientAuthType{ClientAuthDH, ClientAuthPSK} {
+		t.Run(authType.String(), func(t *testing.T) {
+			credential, err := newClientCredential("alice", authType)
+			require.NoError(t, err)
+			dest, _ := testEd25519Destination(t)
+			blinded, err := NewBlindedAddress(dest, false, true)
+			require.NoError(t, err)
+			addr, err := I2PAddrToMultiAddr(blinded.String())
+			require.NoError(t, err)
+			require.NoError(t, client.SetClientCredential(addr, credential))
+
+			// the stand-in cannot look up blinded addresses, the dial fails
+			// after the session carrying the credential was created
+			for range 2 {
+				_, err = client.Dial(context.Background(), addr, "")
+				assert.Error(t, err)
+			}
+			var created []string
+			for _, line := range standIn.createdSessions() {
+				if strings.Contains(line, "i2cp.leaseSetPrivKey="+i2pB64Encoding.EncodeToString(credential.Key)) {
+					created = append(created, line)
+				}
+			}
+			require.Len(t, created, 1, "the session is reused")
+			assert.Contains(t, created[0], "STYLE=STREAM")
+			assert.Contains(t, created[0], "DESTINATION=TRANSIENT")
+			assert.Contains(t, created[0], "i2cp.leaseSetAuthType="+strconv.Itoa(int(authType)))
+		})
+	}
+	assert.Len(t, client.Diagnostics().Sessions.Blinded, 2)
+
+	assert.Error(t, client.SetClientCredential(standInListenAddr(t, client), ClientCredential{Type: ClientAuthDH, Key: make([]byte, clientKeyLength)}), "only blinded addresses take credentials")
+}
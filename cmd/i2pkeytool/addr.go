@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	i2p "banyan/transports/i2p"
+	"github.com/eyedeekay/sam3/i2pkeys"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	b32Suffix = ".b32.i2p"
+
+	// a b32 name encodes the 32 byte SHA-256 of the destination, longer
+	// names are blinded b33 addresses
+	b32NameLength = 52
+	// the length of the shortest destination in base64
+	minBase64Length = 516
+)
+
+// address is an I2P address in whichever form it was given. The full
+// destination is only known when a base64 form was given, a b32 name is a
+// hash of it.
+type address struct {
+	dest i2pkeys.I2PAddr
+	// name is the .b32.i2p name, including the suffix
+	name    string
+	blinded bool
+}
+
+func (a address) kind() string {
+	switch {
+	case a.dest != "":
+		return "destination"
+	case a.blinded:
+		return "b33"
+	default:
+		return "b32"
+	}
+}
+
+// parseAddress accepts a base64 destination, a b32 or b33 name with or
+// without the .b32.i2p suffix, or a /garlic64 or /garlic32 multiaddr
+func parseAddress(s string) (address, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return address{}, fmt.Errorf("empty address")
+	}
+	if strings.HasPrefix(s, "/") {
+		return parseMultiaddr(s)
+	}
+	if strings.HasSuffix(s, b32Suffix) {
+		return parseName(strings.TrimSuffix(s, b32Suffix))
+	}
+	if strings.HasSuffix(s, ".i2p") {
+		return address{}, fmt.Errorf("%s is a host name, only .b32.i2p names can be used without an address book", s)
+	}
+	if len(s) >= minBase64Length {
+		return parseDestination(s)
+	}
+	if strings.ContainsAny(s, "ABCDEFGHIJKLMNOPQRSTUVWXYZ-~=+/") {
+		// not a b32 name, most likely a truncated destination
+		return address{}, fmt.Errorf("address is %d characters, too short for a base64 destination (at least %d)", len(s), minBase64Length)
+	}
+	return parseName(s)
+}
+
+// parseMultiaddr reads the I2P address at the start of a multiaddr, a
+// trailing /p2p component is ignored
+func parseMultiaddr(s string) (address, error) {
+	addr, err := ma.NewMultiaddr(s)
+	if err != nil {
+		return address{}, fmt.Errorf("invalid multiaddr: %w", err)
+	}
+	first, rest := ma.SplitFirst(addr)
+	if first == nil {
+		return address{}, fmt.Errorf("empty multiaddr")
+	}
+	code := first.Protocol().Code
+	if code != ma.P_GARLIC64 && code != ma.P_GARLIC32 {
+		return address{}, fmt.Errorf("multiaddr starts with /%s, expected /garlic64 or /garlic32", first.Protocol().Name)
+	}
+	if len(rest) > 0 {
+		if next, _ := ma.SplitFirst(rest); next.Protocol().Code != ma.P_P2P {
+			return address{}, fmt.Errorf("unexpected /%s after the I2P address, only /p2p may follow", next.Protocol().Name)
+		}
+	}
+	dest, err := i2p.MultiAddrToI2PAddr(first.Multiaddr())
+	if err != nil {
+		return address{}, err
+	}
+	if code == ma.P_GARLIC32 {
+		return parseName(strings.TrimSuffix(dest, b32Suffix))
+	}
+	return parseDestination(dest)
+}
+
+func parseName(name string) (address, error) {
+	if err := i2p.ValidateB32Name(name); err != nil {
+		return address{}, err
+	}
+	return address{name: name + b32Suffix, blinded: len(name) > b32NameLength}, nil
+}
+
+func parseDestination(s string) (address, error) {
+	if err := i2p.ValidateDestination(s); err != nil {
+		return address{}, err
+	}
+	dest := i2pkeys.I2PAddr(s)
+	return address{dest: dest, name: dest.Base32()}, nil
+}
+
+// forms returns the forms an address can be given in, by name, in the order
+// they are printed. Only the b32 forms are known without the destination.
+func (a address) forms() ([][2]string, error) {
+	var forms [][2]string
+	if a.dest != "" {
+		garlic64, err := i2p.I2PAddrToMultiAddr(string(a.dest))
+		if err != nil {
+			return nil, err
+		}
+		forms = append(forms, [2]string{"b64", string(a.dest)}, [2]string{"garlic64", garlic64.String()})
+	}
+	garlic32, err := i2p.I2PAddrToMultiAddr(a.name)
+	if err != nil {
+		return nil, err
+	}
+	return append(forms, [2]string{"b32", a.name}, [2]string{"garlic32", garlic32.String()}), nil
+}
+
+func printForms(w io.Writer, a address) error {
+	forms, err := a.forms()
+	if err != nil {
+		return err
+	}
+	for _, form := range forms {
+		fmt.Fprintf(w, "%-9s %s\n", form[0]+":", form[1])
+	}
+	return nil
+}
+
+func runConvert(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("convert", "[-to form] [--] ADDRESS", stderr)
+	to := flags.String("to", "", "print only this form: b32, b64, garlic32 or garlic64")
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+	switch *to {
+	case "", "b32", "b64", "garlic32", "garlic64":
+	default:
+		return fmt.Errorf("unknown form %q, expected b32, b64, garlic32 or garlic64", *to)
+	}
+	addr, err := parseAddress(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *to == "" {
+		return printForms(stdout, addr)
+	}
+
+	forms, err := addr.forms()
+	if err != nil {
+		return err
+	}
+	for _, form := range forms {
+		if form[0] == *to {
+			fmt.Fprintln(stdout, form[1])
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot convert a %s name to %s, the destination cannot be recovered from it", addr.kind(), *to)
+}
+
+func runValidate(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("validate", "[--] ADDRESS...", stderr)
+	if err := parseFlags(flags, args, 1, -1); err != nil {
+		return err
+	}
+	invalid := 0
+	for _, arg := range flags.Args() {
+		addr, err := parseAddress(arg)
+		if err != nil {
+			invalid++
+			fmt.Fprintf(stdout, "invalid  %s: %v\n", abbreviate(arg), err)
+			continue
+		}
+		fmt.Fprintf(stdout, "valid    %s: %s %s\n", abbreviate(arg), addr.kind(), addr.name)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d addresses are invalid", invalid, flags.NArg())
+	}
+	return nil
+}
+
+// abbreviate shortens base64 destinations for display
+func abbreviate(s string) string {
+	if len(s) > 80 {
+		return s[:40] + "…" + s[len(s)-12:]
+	}
+	return s
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	i2p "banyan/transports/i2p"
+	"banyan/transports/i2p/cmd/internal/cmdutil"
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+// signatureTypes are the -sig values of generate. DSA_SHA1 is left out, it
+// is rejected by the transport's default key types.
+var signatureTypes = map[string]i2p.SignatureType{
+	"ed25519":    i2p.SignatureEd25519,
+	"ecdsa-p256": i2p.SignatureECDSASHA256P256,
+	"ecdsa-p384": i2p.SignatureECDSASHA384P384,
+	"ecdsa-p521": i2p.SignatureECDSASHA512P521,
+}
+
+func parseSignatureType(name string) (i2p.SignatureType, error) {
+	if sigType, ok := signatureTypes[strings.ToLower(name)]; ok {
+		return sigType, nil
+	}
+	// SAM's own names are accepted too
+	for _, sigType := range signatureTypes {
+		if strings.EqualFold(sigType.String(), name) {
+			return sigType, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown signature type %q, expected ed25519, ecdsa-p256, ecdsa-p384 or ecdsa-p521", name)
+}
+
+func runGenerate(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("generate", "[-sam host:port] [-sig type] [-force] FILE", stderr)
+	samAddress := flags.String("sam", cmdutil.DefaultSAMAddress, "address of the router's SAM bridge")
+	sigName := flags.String("sig", "ed25519", "signature type: ed25519, ecdsa-p256, ecdsa-p384 or ecdsa-p521")
+	timeout := flags.Duration("timeout", time.Minute, "how long to wait for the router")
+	force := flags.Bool("force", false, "overwrite FILE if it exists")
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+	sigType, err := parseSignatureType(*sigName)
+	if err != nil {
+		return err
+	}
+	config, err := i2p.ParseSAMAddress(*samAddress)
+	if err != nil {
+		return err
+	}
+
+	// refuse early rather than after asking the router for keys
+	path := flags.Arg(0)
+	if _, err := os.Stat(path); err == nil && !*force {
+		return fmt.Errorf("%s exists, use -force to overwrite it", path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	types := i2p.DefaultKeyTypes
+	types.Signature = sigType
+	keys, err := i2p.GenerateKeysWithConfig(ctx, config, types)
+	if err != nil {
+		return err
+	}
+	if err := storeKeys(path, keys, *force); err != nil {
+		return err
+	}
+	return showKeys(stdout, keys)
+}
+
+func runShow(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("show", "FILE", stderr)
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+	keys, err := loadKeys(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return showKeys(stdout, keys)
+}
+
+func runOffline(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("offline", "[-expires duration|time] [-force] COLD OUT", stderr)
+	expiresFlag := flags.String("expires", "720h", "how long the transient keys are valid, or when they expire as an RFC 3339 time")
+	force := flags.Bool("force", false, "overwrite OUT if it exists")
+	if err := parseFlags(flags, args, 2, 2); err != nil {
+		return err
+	}
+	expires, err := parseExpiry(*expiresFlag, time.Now())
+	if err != nil {
+		return err
+	}
+
+	cold, err := loadKeys(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	hot, err := i2p.NewOfflineKeys(cold, expires)
+	if err != nil {
+		return err
+	}
+	if err := storeKeys(flags.Arg(1), hot, *force); err != nil {
+		return err
+	}
+	return showKeys(stdout, hot)
+}
+
+// parseExpiry reads -expires, a duration from now or an RFC 3339 time
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	if validity, err := time.ParseDuration(value); err == nil {
+		if validity <= 0 {
+			return time.Time{}, fmt.Errorf("-expires %s must be positive", value)
+		}
+		return now.Add(validity), nil
+	}
+	expires, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("-expires %q is neither a duration nor an RFC 3339 time", value)
+	}
+	return expires, nil
+}
+
+func showKeys(w io.Writer, keys i2pkeys.I2PKeys) error {
+	addr, err := parseDestination(string(keys.Addr()))
+	if err != nil {
+		return err
+	}
+	if err := printForms(w, addr); err != nil {
+		return err
+	}
+	offline, err := i2p.ParseOfflineSignature(keys)
+	if err != nil {
+		return fmt.Errorf("private keys: %w", err)
+	}
+	if offline != nil {
+		fmt.Fprintf(w, "%-9s %s\n", "offline:", "transient key expires "+offline.Expires.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// loadKeys reads a key file written by storeKeys or
+// i2pkeys.StoreKeysIncompat
+func loadKeys(path string) (i2pkeys.I2PKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	// i2pkeys.LoadKeysIncompat panics on files without a second line
+	public, private, ok := strings.Cut(strings.TrimSpace(string(data)), "\n")
+	if !ok {
+		return i2pkeys.I2PKeys{}, fmt.Errorf("%s is not a key file, expected the destination and the private keys on two lines", path)
+	}
+	return i2pkeys.NewKeys(i2pkeys.I2PAddr(strings.TrimSpace(public)), strings.TrimSpace(private)), nil
+}
+
+// storeKeys writes keys readable by the owner only
+func storeKeys(path string, keys i2pkeys.I2PKeys, overwrite bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if overwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s exists, use -force to overwrite it", path)
+	}
+	if err != nil {
+		return err
+	}
+	if err := i2pkeys.StoreKeysIncompat(keys, file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
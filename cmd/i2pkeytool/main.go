@@ -0,0 +1,118 @@
+// Command i2pkeytool generates I2P destination keys and converts between the
+// forms an I2P address takes: full base64 destinations, .b32.i2p names and
+// the /garlic64 and /garlic32 multiaddrs the transport listens and dials on.
+//
+//	i2pkeytool generate [-sam host:port] [-sig ed25519] [-force] FILE
+//	i2pkeytool show FILE
+//	i2pkeytool offline [-expires 720h|2006-01-02T15:04:05Z] [-force] COLD OUT
+//	i2pkeytool convert [-to b32|b64|garlic32|garlic64] [--] ADDRESS
+//	i2pkeytool validate [--] ADDRESS...
+//
+// Base64 destinations may start with a dash, pass them after -- so they are
+// not taken for flags.
+//
+// offline signs transient keys with the cold keys in COLD and stores them in
+// OUT, which is what the node runs with. Run it where COLD is kept, only OUT
+// needs to be copied to the node.
+//
+// Key files hold the base64 destination on the first line and the private
+// key blob SAM hands out on the second, the format of i2pkeys.StoreKeysIncompat.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errUsage reports bad arguments, usage has already been printed
+var errUsage = errors.New("usage")
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) error
+}
+
+var commands = []command{
+	{"generate", "[-sam host:port] [-sig type] [-force] FILE", "ask the router for new keys and store them in FILE", runGenerate},
+	{"show", "FILE", "print the addresses of the keys in FILE", runShow},
+	{"offline", "[-expires duration|time] [-force] COLD OUT", "sign transient keys with the cold keys in COLD and store them in OUT", runOffline},
+	{"convert", "[-to form] [--] ADDRESS", "print the other forms of an address", runConvert},
+	{"validate", "[--] ADDRESS...", "check addresses, exits with 1 if any is invalid", runValidate},
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "i2pkeytool:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		usage(stderr)
+		return errUsage
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			err := cmd.run(args[1:], stdout, stderr)
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		usage(stdout)
+		return nil
+	}
+	fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+	usage(stderr)
+	return errUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: i2pkeytool COMMAND [ARGUMENTS]")
+	fmt.Fprintln(w)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\n    \t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "ADDRESS is a base64 destination, a .b32.i2p name with or without the")
+	fmt.Fprintln(w, "suffix, or a /garlic64 or /garlic32 multiaddr, optionally followed by /p2p/ID.")
+	fmt.Fprintln(w, "Pass destinations starting with a dash after --.")
+}
+
+// newFlagSet returns a flag set for a command that reports errors to stderr
+// without exiting
+func newFlagSet(name, args string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: i2pkeytool %s %s\n", name, args)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses args and checks the number of positional arguments,
+// max < 0 allows any number. It returns flag.ErrHelp when help was asked for.
+func parseFlags(flags *flag.FlagSet, args []string, min, max int) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if flags.NArg() < min || (max >= 0 && flags.NArg() > max) {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	i2p "banyan/transports/i2p"
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKeys(t *testing.T) i2pkeys.I2PKeys {
+	identity, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	keys, err := i2p.DeriveKeys(identity)
+	require.NoError(t, err)
+	return keys
+}
+
+func runTool(args ...string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	err := run(args, &stdout, &stderr)
+	return stdout.String(), stderr.String(), err
+}
+
+func TestConvert(t *testing.T) {
+	keys := testKeys(t)
+	dest := string(keys.Addr())
+	b32 := keys.Addr().Base32()
+	name := strings.TrimSuffix(b32, b32Suffix)
+
+	for _, input := range []string{dest, "/garlic64/" + dest, "/garlic64/" + dest + "/p2p/12D3KooWAwB1jZsBXL7mqJXYrKJbwVbZg8rVwZ4jaZpZwNMZUGpm"} {
+		stdout, _, err := runTool("convert", "--", input)
+		require.NoError(t, err, input)
+		assert.Equal(t, "b64:      "+dest+"\ngarlic64: /garlic64/"+dest+"\nb32:      "+b32+"\ngarlic32: /garlic32/"+name+"\n", stdout)
+	}
+	for _, input := range []string{b32, name, "/garlic32/" + name} {
+		stdout, _, err := runTool("convert", "-to", "garlic32", input)
+		require.NoError(t, err, input)
+		assert.Equal(t, "/garlic32/"+name+"\n", stdout)
+	}
+
+	stdout, _, err := runTool("convert", "-to", "b32", "/garlic64/"+dest)
+	require.NoError(t, err)
+	assert.Equal(t, b32+"\n", stdout)
+
+	_, _, err = runTool("convert", "-to", "b64", b32)
+	assert.ErrorContains(t, err, "cannot be recovered")
+	_, _, err = runTool("convert", "-to", "hex", b32)
+	assert.ErrorContains(t, err, "unknown form")
+}
+
+func TestConvertBlinded(t *testing.T) {
+	keys := testKeys(t)
+	blinded, err := i2p.NewBlindedAddress(keys.Addr(), false, false)
+	require.NoError(t, err)
+
+	stdout, _, err := runTool("convert", blinded.String())
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "garlic32: /garlic32/"+strings.TrimSuffix(blinded.String(), b32Suffix))
+	addr, err := parseAddress(blinded.String())
+	require.NoError(t, err)
+	assert.Equal(t, "b33", addr.kind())
+}
+
+func TestValidate(t *testing.T) {
+	keys := testKeys(t)
+	dest := string(keys.Addr())
+
+	// destinations may start with a dash
+	stdout, _, err := runTool("validate", "--", dest, keys.Addr().Base32())
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(stdout, "valid    "))
+
+	for input, message := range map[string]string{
+		"":                                  "empty address",
+		"example.i2p":                       "host name",
+		"/ip4/127.0.0.1/tcp/1":              "expected /garlic64 or /garlic32",
+		strings.Repeat("a", 53):             "invalid b32 name: 53 characters",
+		strings.Repeat("a", 51) + "1":       "invalid character '1' at offset 51",
+		dest[:100]:                          "too short for a base64 destination",
+		dest[:10] + "+" + dest[11:]:         "standard base64",
+		strings.Repeat("a", 60) + b32Suffix: "blinded address",
+		"/garlic64/" + dest + "/tcp/1":      "only /p2p may follow",
+		"/garlic32/" + strings.Repeat("a", 52) + "/garlic32/" + strings.Repeat("a", 52): "only /p2p may follow",
+	} {
+		_, err := parseAddress(input)
+		assert.ErrorContains(t, err, message, input)
+	}
+
+	stdout, _, err = runTool("validate", "--", dest, "example.i2p")
+	assert.ErrorContains(t, err, "1 of 2 addresses are invalid")
+	assert.Contains(t, stdout, "invalid  example.i2p: ")
+	assert.Contains(t, stdout, "valid    "+dest[:40]+"…", "destinations are abbreviated")
+}
+
+func TestShow(t *testing.T) {
+	keys := testKeys(t)
+	path := filepath.Join(t.TempDir(), "keys.dat")
+	require.NoError(t, storeKeys(path, keys, false))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	assert.ErrorContains(t, storeKeys(path, keys, false), "use -force")
+	require.NoError(t, storeKeys(path, keys, true))
+
+	stdout, _, err := runTool("show", path)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "b32:      "+keys.Addr().Base32()+"\n")
+	assert.Contains(t, stdout, "garlic64: /garlic64/"+string(keys.Addr())+"\n")
+	assert.NotContains(t, stdout, "offline:")
+
+	loaded, err := loadKeys(path)
+	require.NoError(t, err)
+	assert.Equal(t, keys, loaded)
+
+	require.NoError(t, os.WriteFile(path, []byte(keys.Addr()), 0o600))
+	_, _, err = runTool("show", path)
+	assert.ErrorContains(t, err, "not a key file")
+}
+
+func TestShowOffline(t *testing.T) {
+	cold := testKeys(t)
+	hot, err := i2p.NewOfflineKeys(cold, time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC))
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.dat")
+	require.NoError(t, storeKeys(path, hot, false))
+
+	stdout, _, err := runTool("show", path)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "offline:  transient key expires 2030-01-02T03:04:05Z\n")
+}
+
+func TestOffline(t *testing.T) {
+	cold := testKeys(t)
+	dir := t.TempDir()
+	coldPath, hotPath := filepath.Join(dir, "cold.dat"), filepath.Join(dir, "hot.dat")
+	require.NoError(t, storeKeys(coldPath, cold, false))
+
+	stdout, _, err := runTool("offline", "-expires", "2030-01-02T03:04:05Z", coldPath, hotPath)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "b32:      "+cold.Addr().Base32()+"\n")
+	assert.Contains(t, stdout, "offline:  transient key expires 2030-01-02T03:04:05Z\n")
+
+	hot, err := loadKeys(hotPath)
+	require.NoError(t, err)
+	assert.Equal(t, cold.Addr(), hot.Addr())
+	offline, err := i2p.ParseOfflineSignature(hot)
+	require.NoError(t, err)
+	require.NotNil(t, offline)
+
+	_, _, err = runTool("offline", coldPath, hotPath)
+	assert.ErrorContains(t, err, "use -force")
+	_, _, err = runTool("offline", "-force", hotPath, hotPath)
+	assert.ErrorContains(t, err, "cold key is required")
+	_, _, err = runTool("offline", "-expires", "-1h", "-force", coldPath, hotPath)
+	assert.ErrorContains(t, err, "must be positive")
+	_, _, err = runTool("offline", "-expires", "next week", "-force", coldPath, hotPath)
+	assert.ErrorContains(t, err, "neither a duration")
+}
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires, err := parseExpiry("720h", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(30*24*time.Hour), expires)
+}
+
+func TestGenerateRefusesToOverwrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.dat")
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	// no router is needed, the file is checked first
+	_, _, err := runTool("generate", "-sam", "127.0.0.1:1", path)
+	assert.ErrorContains(t, err, "use -force")
+
+	_, _, err = runTool("generate", "-sig", "dsa", path)
+	assert.ErrorContains(t, err, "unknown signature type")
+}
+
+func TestSignatureTypes(t *testing.T) {
+	sigType, err := parseSignatureType("Ed25519")
+	require.NoError(t, err)
+	assert.Equal(t, i2p.SignatureEd25519, sigType)
+	sigType, err = parseSignatureType("ECDSA_SHA384_P384")
+	require.NoError(t, err)
+	assert.Equal(t, i2p.SignatureECDSASHA384P384, sigType)
+}
+
+func TestUsage(t *testing.T) {
+	_, stderr, err := runTool()
+	assert.ErrorIs(t, err, errUsage)
+	assert.Contains(t, stderr, "usage: i2pkeytool")
+
+	_, stderr, err = runTool("frobnicate")
+	assert.ErrorIs(t, err, errUsage)
+	assert.Contains(t, stderr, `unknown command "frobnicate"`)
+
+	_, stderr, err = runTool("convert")
+	assert.ErrorIs(t, err, errUsage)
+	assert.Contains(t, stderr, "usage: i2pkeytool convert")
+
+	_, stderr, err = runTool("show", "-h")
+	assert.NoError(t, err)
+	assert.Contains(t, stderr, "usage: i2pkeytool show FILE")
+}
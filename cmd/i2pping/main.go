@@ -0,0 +1,204 @@
+// Command i2pping checks whether a peer is reachable over I2P. It starts a
+// libp2p host on the I2P transport and either serves the ping and echo
+// protocols, or dials a peer and reports the dial time, the distribution of
+// ping round trips and the throughput of an echoed transfer.
+//
+//	i2pping -serve [-identity FILE]
+//	i2pping [-count 10] [-interval 1s] [-bytes 262144] /garlic32/.../p2p/ID
+//
+// The I2P destination is derived from the libp2p identity, see
+// i2p.DeriveKeys, so a server started with the same -identity file keeps its
+// address. Without -identity a new identity is used on each run.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	i2p "banyan/transports/i2p"
+	"banyan/transports/i2p/cmd/internal/cmdutil"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// errUsage reports bad arguments, usage has already been printed
+var errUsage = errors.New("usage")
+
+type options struct {
+	sam      string
+	identity string
+	serve    bool
+	timeout  time.Duration
+	count    int
+	interval time.Duration
+	bytes    int64
+	target   ma.Multiaddr
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "i2pping:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func parseOptions(args []string, stderr io.Writer) (options, error) {
+	var opts options
+	flags := flag.NewFlagSet("i2pping", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: i2pping -serve [flags]")
+		fmt.Fprintln(flags.Output(), "       i2pping [flags] /garlic32/.../p2p/ID")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.sam, "sam", cmdutil.DefaultSAMAddress, "address of the router's SAM bridge")
+	flags.StringVar(&opts.identity, "identity", "", "libp2p private key file, created when missing")
+	flags.BoolVar(&opts.serve, "serve", false, "serve ping and echo until interrupted")
+	flags.DurationVar(&opts.timeout, "timeout", 3*time.Minute, "timeout of the dial and of each ping")
+	flags.IntVar(&opts.count, "count", 10, "number of pings")
+	flags.DurationVar(&opts.interval, "interval", time.Second, "time between pings")
+	flags.Int64Var(&opts.bytes, "bytes", 256<<10, "bytes to echo when measuring throughput, 0 to skip")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return opts, err
+		}
+		return opts, errUsage
+	}
+
+	switch {
+	case opts.serve && flags.NArg() != 0:
+		fmt.Fprintln(stderr, "-serve takes no address")
+	case !opts.serve && flags.NArg() != 1:
+		fmt.Fprintln(stderr, "expected the address to ping")
+	case opts.count < 0 || opts.bytes < 0 || opts.interval < 0 || opts.timeout <= 0:
+		fmt.Fprintln(stderr, "-count, -bytes and -interval must not be negative, -timeout must be positive")
+	default:
+		if opts.serve {
+			return opts, nil
+		}
+		target, err := ma.NewMultiaddr(flags.Arg(0))
+		if err != nil {
+			return opts, fmt.Errorf("invalid address: %w", err)
+		}
+		opts.target = target
+		return opts, nil
+	}
+	flags.Usage()
+	return opts, errUsage
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	opts, err := parseOptions(args, stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var target *peer.AddrInfo
+	if !opts.serve {
+		if target, err = peer.AddrInfoFromP2pAddr(opts.target); err != nil {
+			return fmt.Errorf("%s: %w, the address must end in /p2p/ID", opts.target, err)
+		}
+	}
+	identity, err := loadIdentity(opts.identity)
+	if err != nil {
+		return err
+	}
+
+	config, err := i2p.ParseSAMAddress(opts.sam)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "waiting for tunnels on %s\n", opts.sam)
+	h, transport, err := cmdutil.NewHost(ctx, cmdutil.HostConfig{
+		Identity:    identity,
+		SAM:         config,
+		Listen:      opts.serve,
+		DialTimeout: opts.timeout,
+	})
+	if err != nil {
+		return err
+	}
+	defer transport.Close()
+	defer h.Close()
+	if opts.serve {
+		return serve(ctx, h, stdout)
+	}
+	return probe(ctx, h, *target, opts, stdout)
+}
+
+func serve(ctx context.Context, h host.Host, stdout io.Writer) error {
+	h.SetStreamHandler(echoProtocol, handleEcho)
+	for _, addr := range h.Addrs() {
+		fmt.Fprintf(stdout, "listening on %s/p2p/%s\n", addr, h.ID())
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func probe(ctx context.Context, h host.Host, target peer.AddrInfo, opts options, stdout io.Writer) error {
+	dialCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+	start := time.Now()
+	err := h.Connect(dialCtx, target)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("dial failed after %s: %w", time.Since(start).Round(time.Millisecond), err)
+	}
+	fmt.Fprintf(stdout, "connected to %s in %s\n", target.ID, time.Since(start).Round(time.Millisecond))
+
+	rtts, err := pings(ctx, h, target.ID, opts, stdout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, summarize(rtts, opts.count))
+
+	if opts.bytes > 0 {
+		result, err := measureEcho(ctx, h, target.ID, opts.bytes)
+		if err != nil {
+			return fmt.Errorf("echo failed: %w", err)
+		}
+		fmt.Fprintln(stdout, result)
+	}
+	if len(rtts) == 0 && opts.count > 0 {
+		return errors.New("no ping was answered")
+	}
+	return nil
+}
+
+// loadIdentity reads a marshaled libp2p private key, generating and storing
+// a new Ed25519 key when the file does not exist. An empty path returns a
+// new key without storing it.
+func loadIdentity(path string) (crypto.PrivKey, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return crypto.UnmarshalPrivateKey(data)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	identity, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil || path == "" {
+		return identity, err
+	}
+	data, err := crypto.MarshalPrivateKey(identity)
+	if err != nil {
+		return nil, err
+	}
+	return identity, os.WriteFile(path, data, 0o600)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHostPair stands in for two I2P hosts linked to each other, the
+// protocols do not depend on the transport
+func newHostPair(t *testing.T) (server, client host.Host) {
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	server, err := mn.GenPeer()
+	require.NoError(t, err)
+	client, err = mn.GenPeer()
+	require.NoError(t, err)
+	require.NoError(t, mn.LinkAll())
+	// hosts from cmdutil.NewHost answer pings
+	ping.NewPingService(server)
+	return server, client
+}
+
+func TestProbe(t *testing.T) {
+	server, client := newHostPair(t)
+	server.SetStreamHandler(echoProtocol, handleEcho)
+
+	var stdout bytes.Buffer
+	opts := options{count: 3, interval: time.Millisecond, timeout: 10 * time.Second, bytes: 100<<10 + 7}
+	target := peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}
+	require.NoError(t, probe(context.Background(), client, target, opts, &stdout))
+
+	output := stdout.String()
+	assert.Contains(t, output, "connected to "+server.ID().String())
+	assert.Contains(t, output, "ping 3: ")
+	assert.Contains(t, output, "3 pings, 3 answered, 0 lost\nrtt min/mean/median/p90/max = ")
+	assert.Contains(t, output, "echoed 100.0 KiB in ")
+}
+
+func TestMeasureEcho(t *testing.T) {
+	server, client := newHostPair(t)
+	server.SetStreamHandler(echoProtocol, handleEcho)
+	require.NoError(t, client.Connect(context.Background(), peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}))
+
+	for _, n := range []int64{1, 16 << 10, 1 << 20} {
+		result, err := measureEcho(context.Background(), client, server.ID(), n)
+		require.NoError(t, err, n)
+		assert.Equal(t, n, result.bytes)
+		assert.LessOrEqual(t, result.firstByte, result.elapsed)
+	}
+}
+
+func TestProbeWithoutServer(t *testing.T) {
+	server, client := newHostPair(t)
+	// connected, but the peer does not serve echo
+	opts := options{count: 1, timeout: 10 * time.Second, bytes: 10}
+	err := probe(context.Background(), client, peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}, opts, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "echo failed")
+}
+
+func TestSummarize(t *testing.T) {
+	var rtts []time.Duration
+	for i := 10; i >= 1; i-- {
+		rtts = append(rtts, time.Duration(i)*100*time.Millisecond)
+	}
+	summary := summarize(rtts, 12)
+	assert.Equal(t, rttSummary{
+		sent: 12, answered: 10,
+		min: 100 * time.Millisecond, mean: 550 * time.Millisecond, median: 500 * time.Millisecond,
+		p90: 900 * time.Millisecond, maximum: time.Second,
+	}, summary)
+	assert.Equal(t, "12 pings, 10 answered, 2 lost\nrtt min/mean/median/p90/max = 100ms/550ms/500ms/900ms/1s", summary.String())
+	assert.Equal(t, time.Second, rtts[0], "the input is left unsorted")
+
+	assert.Equal(t, "3 pings, 0 answered, 3 lost", summarize(nil, 3).String())
+	assert.Equal(t, 7*time.Millisecond, summarize([]time.Duration{7 * time.Millisecond}, 1).p90)
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "256.0 KiB", formatBytes(256<<10))
+	assert.Equal(t, "2.0 GiB", formatBytes(2<<30))
+}
+
+func TestParseOptions(t *testing.T) {
+	opts, err := parseOptions([]string{"-count", "3", "/garlic32/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa/p2p/12D3KooWAwB1jZsBXL7mqJXYrKJbwVbZg8rVwZ4jaZpZwNMZUGpm"}, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, opts.count)
+	assert.NotNil(t, opts.target)
+
+	opts, err = parseOptions([]string{"-serve"}, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.True(t, opts.serve)
+
+	for _, args := range [][]string{
+		{},
+		{"-serve", "/garlic32/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+		{"-count", "-1", "/garlic32/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+		{"-bogus"},
+	} {
+		var stderr bytes.Buffer
+		_, err := parseOptions(args, &stderr)
+		assert.ErrorIs(t, err, errUsage, args)
+		assert.Contains(t, stderr.String(), "usage: i2pping", args)
+	}
+
+	// the address is checked before anything is started
+	err = run(context.Background(), []string{"/garlic32/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}, &bytes.Buffer{}, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "must end in /p2p/ID")
+}
+
+func TestLoadIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.key")
+	created, err := loadIdentity(path)
+	require.NoError(t, err)
+	loaded, err := loadIdentity(path)
+	require.NoError(t, err)
+	assert.True(t, created.Equals(loaded), "the stored identity is reused")
+
+	ephemeral, err := loadIdentity("")
+	require.NoError(t, err)
+	assert.False(t, ephemeral.Equals(created))
+}
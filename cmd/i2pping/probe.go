@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+// echoProtocol writes back everything it reads until the peer closes its
+// side of the stream
+const echoProtocol = protocol.ID("/banyan/i2p/echo/1.0.0")
+
+func handleEcho(s network.Stream) {
+	defer s.Close()
+	if _, err := io.Copy(s, s); err != nil {
+		s.Reset()
+	}
+}
+
+// pings sends opts.count pings, each on its own stream so a lost ping does
+// not end the series, and returns the round trips of those answered
+func pings(ctx context.Context, h host.Host, id peer.ID, opts options, stdout io.Writer) ([]time.Duration, error) {
+	var rtts []time.Duration
+	for i := 1; i <= opts.count; i++ {
+		if i > 1 {
+			select {
+			case <-time.After(opts.interval):
+			case <-ctx.Done():
+				return rtts, ctx.Err()
+			}
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+		result := <-ping.Ping(pingCtx, h, id)
+		cancel()
+		if ctx.Err() != nil {
+			return rtts, ctx.Err()
+		}
+		if result.Error != nil {
+			fmt.Fprintf(stdout, "ping %d: %v\n", i, result.Error)
+			continue
+		}
+		fmt.Fprintf(stdout, "ping %d: %s\n", i, result.RTT.Round(time.Millisecond))
+		rtts = append(rtts, result.RTT)
+	}
+	return rtts, nil
+}
+
+// rttSummary describes the round trips of a series of pings
+type rttSummary struct {
+	sent, answered                  int
+	min, mean, median, p90, maximum time.Duration
+}
+
+func summarize(rtts []time.Duration, sent int) rttSummary {
+	summary := rttSummary{sent: sent, answered: len(rtts)}
+	if len(rtts) == 0 {
+		return summary
+	}
+	sorted := slices.Clone(rtts)
+	slices.Sort(sorted)
+	var total time.Duration
+	for _, rtt := range sorted {
+		total += rtt
+	}
+	summary.min = sorted[0]
+	summary.maximum = sorted[len(sorted)-1]
+	summary.mean = total / time.Duration(len(sorted))
+	summary.median = percentile(sorted, 50)
+	summary.p90 = percentile(sorted, 90)
+	return summary
+}
+
+// percentile returns the nearest-rank percentile of sorted
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+func (s rttSummary) String() string {
+	lost := s.sent - s.answered
+	header := fmt.Sprintf("%d pings, %d answered, %d lost", s.sent, s.answered, lost)
+	if s.answered == 0 {
+		return header
+	}
+	round := func(d time.Duration) time.Duration { return d.Round(time.Millisecond) }
+	return fmt.Sprintf("%s\nrtt min/mean/median/p90/max = %s/%s/%s/%s/%s", header,
+		round(s.min), round(s.mean), round(s.median), round(s.p90), round(s.maximum))
+}
+
+// echoResult is the outcome of an echoed transfer
+type echoResult struct {
+	bytes int64
+	// firstByte is when the first echoed byte arrived, elapsed when the last did
+	firstByte, elapsed time.Duration
+}
+
+func (r echoResult) String() string {
+	rate := float64(r.bytes) / r.elapsed.Seconds()
+	return fmt.Sprintf("echoed %s in %s, first byte after %s: %s/s each way",
+		formatBytes(float64(r.bytes)), r.elapsed.Round(time.Millisecond), r.firstByte.Round(time.Millisecond), formatBytes(rate))
+}
+
+// measureEcho sends n bytes over the echo protocol while reading them back
+func measureEcho(ctx context.Context, h host.Host, id peer.ID, n int64) (echoResult, error) {
+	s, err := h.NewStream(ctx, id, echoProtocol)
+	if err != nil {
+		return echoResult{}, err
+	}
+	defer s.Close()
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	start := time.Now()
+	written := make(chan error, 1)
+	go func() {
+		chunk := make([]byte, 16<<10)
+		for i := range chunk {
+			chunk[i] = byte(i)
+		}
+		var err error
+		for sent := int64(0); sent < n && err == nil; {
+			var k int
+			k, err = s.Write(chunk[:min(int64(len(chunk)), n-sent)])
+			sent += int64(k)
+		}
+		if err == nil {
+			err = s.CloseWrite()
+		}
+		written <- err
+	}()
+
+	result := echoResult{bytes: n}
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(s, first); err != nil {
+		return result, err
+	}
+	result.firstByte = time.Since(start)
+	received, err := io.CopyN(io.Discard, s, n-1)
+	if err != nil {
+		return result, fmt.Errorf("received %d of %d bytes: %w", received+1, n, err)
+	}
+	result.elapsed = time.Since(start)
+	if err := <-written; err != nil {
+		return result, err
+	}
+	if extra, _ := s.Read(first); extra > 0 {
+		return result, errors.New("peer echoed more than was sent")
+	}
+	return result, nil
+}
+
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	unit := 0
+	for n >= 1024 && unit < len(units)-1 {
+		n /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[unit])
+}
@@ -0,0 +1,332 @@
+// Command i2pready checks whether an I2P router is ready for the transport,
+// for CI jobs and container health checks. It speaks SAM directly rather
+// than reading router logs: it waits for the SAM bridge to accept
+// connections, checks the HELLO handshake, creates a throwaway session,
+// waits for its tunnels and dials it from a second session through the
+// transport.
+//
+//	i2pready [-sam host:port] [-timeout 10m] [-skip-dial]
+//
+// The exit code tells which stage failed:
+//
+//	0  the router is ready
+//	1  an unexpected error
+//	2  bad arguments
+//	3  the SAM bridge does not accept connections
+//	4  the HELLO handshake failed, e.g. an unsupported version or credentials
+//	5  the router refused to create the session, or did not answer in time
+//	6  the session's tunnels were not built in time, its LeaseSet cannot
+//	   be looked up
+//	7  the self-dial failed
+//
+// Routers that answer SESSION CREATE only once the tunnels are built report
+// slow tunnels with 5.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	i2p "banyan/transports/i2p"
+	"banyan/transports/i2p/cmd/internal/cmdutil"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+const (
+	exitError = 1
+	exitUsage = 2
+)
+
+// stage is a step of the check and the exit code reporting its failure
+type stage struct {
+	name string
+	code int
+}
+
+var (
+	stageConnect = stage{"connect", 3}
+	stageHello   = stage{"hello", 4}
+	stageSession = stage{"session", 5}
+	stageTunnels = stage{"tunnels", 6}
+	stageDial    = stage{"self-dial", 7}
+)
+
+type stageError struct {
+	stage stage
+	err   error
+}
+
+func (e *stageError) Error() string {
+	return e.stage.name + ": " + e.err.Error()
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+type options struct {
+	sam        i2p.SAMConfig
+	timeout    time.Duration
+	retryDelay time.Duration
+	skipDial   bool
+	quiet      bool
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run returns the exit code
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	opts, err := parseOptions(args, stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "i2pready:", err)
+		return exitUsage
+	}
+	if opts.quiet {
+		stdout = io.Discard
+	}
+
+	err = check(ctx, opts, stdout)
+	if err == nil {
+		fmt.Fprintln(stdout, "ready")
+		return 0
+	}
+	fmt.Fprintln(stderr, "i2pready:", err)
+	var failed *stageError
+	if errors.As(err, &failed) {
+		return failed.stage.code
+	}
+	return exitError
+}
+
+func parseOptions(args []string, stderr io.Writer) (options, error) {
+	var opts options
+	flags := flag.NewFlagSet("i2pready", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: i2pready [flags]")
+		flags.PrintDefaults()
+	}
+	sam := flags.String("sam", cmdutil.DefaultSAMAddress, "address of the router's SAM bridge")
+	flags.StringVar(&opts.sam.User, "sam-user", "", "SAM user, for bridges that require authentication")
+	flags.StringVar(&opts.sam.Password, "sam-password", os.Getenv("I2P_SAM_PASSWORD"), "SAM password, defaults to $I2P_SAM_PASSWORD")
+	// the transport creates PRIMARY sessions, which need SAM 3.3
+	flags.StringVar(&opts.sam.MinVersion, "min-version", "3.3", "oldest acceptable SAM version")
+	flags.DurationVar(&opts.timeout, "timeout", 10*time.Minute, "how long to wait for the router to become ready")
+	flags.DurationVar(&opts.retryDelay, "retry", 5*time.Second, "time between attempts to connect and to dial")
+	flags.BoolVar(&opts.skipDial, "skip-dial", false, "stop once the session's tunnels are built")
+	flags.BoolVar(&opts.quiet, "q", false, "only report failures")
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return opts, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	if opts.timeout <= 0 || opts.retryDelay <= 0 {
+		return opts, errors.New("-timeout and -retry must be positive")
+	}
+
+	config, err := i2p.ParseSAMAddress(*sam)
+	if err != nil {
+		return opts, err
+	}
+	opts.sam.Host, opts.sam.Port = config.Host, config.Port
+	return opts, nil
+}
+
+func check(ctx context.Context, opts options, stdout io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	start := time.Now()
+	passed := func(format string, args ...any) {
+		fmt.Fprintf(stdout, "%-8s %s (%s)\n", "ok", fmt.Sprintf(format, args...), time.Since(start).Round(time.Millisecond))
+	}
+
+	if err := waitForBridge(ctx, opts); err != nil {
+		return &stageError{stageConnect, err}
+	}
+	passed("SAM bridge at %s accepts connections", opts.sam.Address())
+
+	version, err := i2p.ProbeSAM(ctx, opts.sam)
+	if err != nil {
+		return &stageError{stageHello, err}
+	}
+	passed("SAM %s negotiated", version)
+
+	hosts := 2
+	if opts.skipDial {
+		hosts = 1
+	}
+	started, err := startHosts(ctx, opts.sam, hosts)
+	if err != nil {
+		return &stageError{stageSession, err}
+	}
+	defer func() {
+		for _, h := range started {
+			h.close()
+		}
+	}()
+	passed("session created")
+
+	if err := waitForTunnels(ctx, started[0], opts.retryDelay); err != nil {
+		return &stageError{stageTunnels, err}
+	}
+	passed("tunnels built")
+	if opts.skipDial {
+		return nil
+	}
+
+	server, client := started[0].host, started[1].host
+	attempts, rtt, err := selfDial(ctx, client, peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}, opts.retryDelay)
+	if err != nil {
+		return &stageError{stageDial, fmt.Errorf("%w, after %d attempts", err, attempts)}
+	}
+	passed("self-dial succeeded after %d attempts, ping %s", attempts, rtt.Round(time.Millisecond))
+	return nil
+}
+
+// waitForBridge retries connecting until the bridge accepts the connection,
+// the router may still be starting
+func waitForBridge(ctx context.Context, opts options) error {
+	var dialer net.Dialer
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", opts.sam.Address())
+		if err == nil {
+			return conn.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(opts.retryDelay):
+		}
+	}
+}
+
+type startedHost struct {
+	host      host.Host
+	transport *i2p.I2PTransport
+}
+
+// close closes the host and then its transport, which the host leaves open
+func (h startedHost) close() {
+	h.host.Close()
+	h.transport.Close()
+}
+
+type hostResult struct {
+	index int
+	startedHost
+	err error
+}
+
+// startHosts starts n hosts on throwaway destinations, the first one
+// listening. It returns once the router created all their sessions, or
+// refused one, or ctx is done, and then no host is left starting.
+func startHosts(ctx context.Context, config i2p.SAMConfig, n int) ([]startedHost, error) {
+	// the others stop starting once one fails
+	startCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan hostResult, n)
+	for i := range n {
+		go func() {
+			identity, _, err := crypto.GenerateEd25519Key(nil)
+			if err != nil {
+				results <- hostResult{index: i, err: err}
+				return
+			}
+			h, transport, err := cmdutil.NewHost(startCtx, cmdutil.HostConfig{
+				Identity: identity,
+				SAM:      config,
+				Listen:   i == 0,
+				// the self-dial sets its own deadline
+				DialTimeout: time.Hour,
+				// the hosts are short-lived, there is no router to fail over to
+				Options: []i2p.Option{i2p.WithSAMHealthCheck(0)},
+			})
+			results <- hostResult{index: i, startedHost: startedHost{h, transport}, err: err}
+		}()
+	}
+
+	// the hosts give up with ctx, so waiting for all of them is bounded
+	hosts := make([]startedHost, n)
+	var errs []error
+	for range n {
+		result := <-results
+		if result.err != nil {
+			if len(errs) == 0 {
+				cancel()
+			}
+			errs = append(errs, result.err)
+			continue
+		}
+		hosts[result.index] = result.startedHost
+	}
+	if len(errs) == 0 {
+		return hosts, nil
+	}
+	for _, h := range hosts {
+		if h.host != nil {
+			h.close()
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("the router did not create the session in time: %w", err)
+	}
+	// the first error caused the others
+	return nil, errs[0]
+}
+
+// waitForTunnels looks up the LeaseSet of the listening host until the
+// router finds it, which it publishes once the session's tunnels are built
+func waitForTunnels(ctx context.Context, listening startedHost, retryDelay time.Duration) error {
+	for {
+		err := listening.transport.Prefetch(ctx, listening.host.Addrs()...)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the router built no tunnels in time: %w", err)
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// selfDial connects client to server until it succeeds or ctx expires, the
+// server's LeaseSet may take a while to be published, and pings once
+func selfDial(ctx context.Context, client host.Host, server peer.AddrInfo, retryDelay time.Duration) (int, time.Duration, error) {
+	for attempts := 1; ; attempts++ {
+		// the swarm backs off from peers it failed to dial
+		if s, ok := client.Network().(*swarm.Swarm); ok {
+			s.Backoff().Clear(server.ID)
+		}
+		err := client.Connect(ctx, server)
+		if err == nil {
+			result := <-ping.Ping(ctx, client, server.ID)
+			return attempts, result.RTT, result.Error
+		}
+		select {
+		case <-ctx.Done():
+			return attempts, 0, err
+		case <-time.After(retryDelay):
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBridge answers HELLO, SESSION CREATE and NAMING LOOKUP with fixed
+// replies, an empty reply never answers. SESSION ADD always succeeds.
+type fakeBridge struct {
+	listener net.Listener
+	hello    string
+	session  string
+	lookup   string
+
+	mu    sync.Mutex
+	conns []net.Conn
+	// the connections the client has not closed yet
+	open int
+}
+
+func newFakeBridge(t *testing.T, hello, session string) *fakeBridge {
+	return newFakeLookupBridge(t, hello, session, "")
+}
+
+func newFakeLookupBridge(t *testing.T, hello, session, lookup string) *fakeBridge {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	b := &fakeBridge{listener: listener, hello: hello, session: session, lookup: lookup}
+	t.Cleanup(b.close)
+	go b.serve()
+	return b
+}
+
+func (b *fakeBridge) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns = append(b.conns, conn)
+		b.open++
+		b.mu.Unlock()
+		go func() {
+			defer func() {
+				b.mu.Lock()
+				b.open--
+				b.mu.Unlock()
+			}()
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				reply := ""
+				switch {
+				case strings.HasPrefix(scanner.Text(), "HELLO"):
+					reply = b.hello
+				case strings.HasPrefix(scanner.Text(), "SESSION CREATE"):
+					reply = b.session
+				case strings.HasPrefix(scanner.Text(), "SESSION ADD"):
+					reply = "SESSION STATUS RESULT=OK"
+				case strings.HasPrefix(scanner.Text(), "NAMING LOOKUP"):
+					reply = b.lookup
+				}
+				if reply != "" {
+					conn.Write([]byte(reply + "\n"))
+				}
+			}
+		}()
+	}
+}
+
+func (b *fakeBridge) close() {
+	b.listener.Close()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, conn := range b.conns {
+		conn.Close()
+	}
+}
+
+// openConns returns the number of connections the client has not closed
+func (b *fakeBridge) openConns() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+func (b *fakeBridge) address() string {
+	return b.listener.Addr().String()
+}
+
+func runCheck(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), append([]string{"-timeout", "500ms", "-retry", "20ms"}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestUnreachableBridge(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	start := time.Now()
+	code, _, stderr := runCheck(t, "-sam", address)
+	assert.Equal(t, stageConnect.code, code)
+	assert.Contains(t, stderr, "connect: ")
+	assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond, "connecting is retried until the timeout")
+}
+
+func TestHelloFailure(t *testing.T) {
+	bridge := newFakeBridge(t, "HELLO REPLY RESULT=NOVERSION", "")
+	code, stdout, stderr := runCheck(t, "-sam", bridge.address())
+	assert.Equal(t, stageHello.code, code)
+	assert.Contains(t, stdout, "accepts connections")
+	assert.Contains(t, stderr, "NOVERSION")
+
+	// PRIMARY sessions need 3.3
+	bridge = newFakeBridge(t, "HELLO REPLY RESULT=OK VERSION=3.1", "")
+	code, _, _ = runCheck(t, "-sam", bridge.address())
+	assert.Equal(t, stageHello.code, code)
+	code, _, stderr = runCheck(t, "-sam", bridge.address(), "-min-version", "3.1", "-skip-dial")
+	assert.NotEqual(t, stageHello.code, code, stderr)
+}
+
+func TestSessionRefused(t *testing.T) {
+	bridge := newFakeBridge(t, "HELLO REPLY RESULT=OK VERSION=3.3", `SESSION STATUS RESULT=I2P_ERROR MESSAGE="router is shutting down"`)
+	code, stdout, stderr := runCheck(t, "-sam", bridge.address())
+	assert.Equal(t, stageSession.code, code)
+	assert.Contains(t, stdout, "SAM 3.3 negotiated")
+	assert.Contains(t, stderr, "router is shutting down")
+}
+
+func TestSessionNotAnswered(t *testing.T) {
+	bridge := newFakeBridge(t, "HELLO REPLY RESULT=OK VERSION=3.3", "")
+	code, _, stderr := runCheck(t, "-sam", bridge.address())
+	assert.Equal(t, stageSession.code, code)
+	assert.Contains(t, stderr, "session: the router did not create the session in time")
+	// the sessions gave up with the timeout
+	assert.Eventually(t, func() bool { return bridge.openConns() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestTunnelsNotReady(t *testing.T) {
+	// the session is created but its LeaseSet is not published yet
+	bridge := newFakeLookupBridge(t, "HELLO REPLY RESULT=OK VERSION=3.3", "SESSION STATUS RESULT=OK", "NAMING REPLY RESULT=KEY_NOT_FOUND")
+	code, stdout, stderr := runCheck(t, "-sam", bridge.address(), "-skip-dial")
+	assert.Equal(t, stageTunnels.code, code)
+	assert.Contains(t, stdout, "session created")
+	assert.Contains(t, stderr, "tunnels: the router built no tunnels in time")
+	assert.Contains(t, stderr, "KEY_NOT_FOUND")
+	assert.Eventually(t, func() bool { return bridge.openConns() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{"extra"},
+		{"-sam", "localhost"},
+		{"-timeout", "0"},
+		{"-bogus"},
+	} {
+		var stderr bytes.Buffer
+		assert.Equal(t, exitUsage, run(context.Background(), args, &bytes.Buffer{}, &stderr), args)
+		assert.NotEmpty(t, stderr.String(), args)
+	}
+
+	var stderr bytes.Buffer
+	assert.Zero(t, run(context.Background(), []string{"-h"}, &bytes.Buffer{}, &stderr))
+	assert.Contains(t, stderr.String(), "usage: i2pready")
+}
+
+func TestParseOptions(t *testing.T) {
+	t.Setenv("I2P_SAM_PASSWORD", "secret")
+	opts, err := parseOptions([]string{"-sam", "10.0.0.1:7000", "-sam-user", "ci"}, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", opts.sam.Host)
+	assert.Equal(t, 7000, opts.sam.Port)
+	assert.Equal(t, "ci", opts.sam.User)
+	assert.Equal(t, "secret", opts.sam.Password)
+	assert.Equal(t, "3.3", opts.sam.MinVersion)
+	assert.Equal(t, "10.0.0.1:7000", opts.sam.Address())
+}
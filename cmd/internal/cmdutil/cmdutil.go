@@ -0,0 +1,135 @@
+// Package cmdutil holds what the commands of this module share: the default
+// SAM bridge address and starting libp2p hosts on the I2P transport.
+package cmdutil
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	i2p "banyan/transports/i2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+)
+
+// DefaultSAMAddress is the default of the commands' -sam flag, which
+// i2p.ParseSAMAddress reads
+var DefaultSAMAddress = i2p.SAMConfig{}.Address()
+
+// HostConfig describes a host started by NewHost
+type HostConfig struct {
+	// Identity is the host's libp2p key, its I2P destination is derived from
+	// it, see i2p.DeriveKeys
+	Identity crypto.PrivKey
+	SAM      i2p.SAMConfig
+	// Listen publishes the destination and accepts connections on it
+	Listen      bool
+	DialTimeout time.Duration
+	// Options are passed on to the transport
+	Options []i2p.Option
+}
+
+// NewHost starts a libp2p host whose only transport is I2P, and returns it
+// with the transport. It blocks while the router creates the session, which
+// can take minutes on a router that just joined the network, or until ctx
+// is done. Closing the host leaves the transport's sessions open, callers
+// close both.
+//
+// The host is put together from the swarm rather than with libp2p.New, which
+// would pull every default transport into the module's dependencies.
+func NewHost(ctx context.Context, config HostConfig) (host.Host, *i2p.I2PTransport, error) {
+	keys, err := i2p.DeriveKeys(config.Identity)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := append([]i2p.Option{i2p.WithSAMConfig(config.SAM)}, config.Options...)
+	builder, listenAddr, err := i2p.I2PTransportBuilderContext(ctx, nil, keys, "0", rand.Int(), opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, transport, err := newHost(config, builder)
+	if err != nil {
+		// the sessions were created with the builder
+		if transport == nil {
+			transport, _ = builder(nil, nil)
+		}
+		transport.Close()
+		return nil, nil, err
+	}
+	if config.Listen {
+		if err := h.Network().Listen(listenAddr); err != nil {
+			h.Close()
+			transport.Close()
+			return nil, nil, err
+		}
+	}
+	return h, transport, nil
+}
+
+// newHost builds a host on the transport, secured with Noise and multiplexed
+// with yamux, as libp2p.New would
+func newHost(config HostConfig, builder i2p.TransportBuilderFunc) (host.Host, *i2p.I2PTransport, error) {
+	id, err := peer.IDFromPrivateKey(config.Identity)
+	if err != nil {
+		return nil, nil, err
+	}
+	peers, err := pstoremem.NewPeerstore()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := peers.AddPrivKey(id, config.Identity); err != nil {
+		return nil, nil, err
+	}
+	if err := peers.AddPubKey(id, config.Identity.GetPublic()); err != nil {
+		return nil, nil, err
+	}
+
+	rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(rcmgr.DefaultLimits.AutoScale()))
+	if err != nil {
+		return nil, nil, err
+	}
+	muxers := []upgrader.StreamMuxer{{ID: yamux.ID, Muxer: yamux.DefaultTransport}}
+	security, err := noise.New(noise.ID, config.Identity, muxers)
+	if err != nil {
+		return nil, nil, err
+	}
+	upg, err := upgrader.New([]sec.SecureTransport{security}, muxers, nil, rm, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	transport, err := builder(upg, rm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bus := eventbus.NewBus()
+	swarmOpts := []swarm.Option{swarm.WithResourceManager(rm)}
+	if config.DialTimeout > 0 {
+		swarmOpts = append(swarmOpts, swarm.WithDialTimeout(config.DialTimeout))
+	}
+	network, err := swarm.NewSwarm(id, peers, bus, swarmOpts...)
+	if err != nil {
+		return nil, transport, err
+	}
+	if err := network.AddTransport(transport); err != nil {
+		network.Close()
+		return nil, transport, err
+	}
+	h, err := basichost.NewHost(network, &basichost.HostOpts{EventBus: bus, EnablePing: true})
+	if err != nil {
+		network.Close()
+		return nil, transport, err
+	}
+	h.Start()
+	return h, transport, nil
+}
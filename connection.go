@@ -2,9 +2,11 @@ package i2p
 
 import (
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/joomcode/errorx"
+	"github.com/libp2p/go-libp2p/core/network"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
@@ -39,6 +41,28 @@ type Connection struct {
 
 	localNetAddr  net.Addr
 	remoteNetAddr net.Addr
+
+	// set when the transport uses WithKeepalive
+	keepalive *keepalive
+
+	// set on connections of an I2PTransport, see WithBandwidthLimit
+	shaper *shaper
+	// called once the connection is closed, lets the transport stop
+	// tracking it
+	onClose func()
+
+	// see Stats, the transport sets where the connection came from
+	opened        time.Time
+	direction     network.Direction
+	subsession    string
+	dialDuration  time.Duration
+	bytesSent     atomic.Uint64
+	bytesReceived atomic.Uint64
+	// unix nanoseconds
+	lastActivity atomic.Int64
+
+	// set while an accepted connection is being upgraded, see WithTracing
+	accepting atomic.Pointer[acceptSpans]
 }
 
 func NewConnection(conn ConnWithoutAddr, localAddr, remoteAddr ma.Multiaddr) (*Connection, error) {
@@ -52,13 +76,95 @@ func NewConnection(conn ConnWithoutAddr, localAddr, remoteAddr ma.Multiaddr) (*C
 		return nil, errorx.Decorate(err, "Failed to convert MultiAddr to NetAddr")
 	}
 
-	return &Connection{
+	c := &Connection{
 		ConnWithoutAddr: conn,
 		localAddr:       localAddr,
 		remoteAddr:      remoteAddr,
 		localNetAddr:    &netAddr{localNetAddrStr},
 		remoteNetAddr:   &netAddr{remoteNetAddrStr},
-	}, nil
+		opened:          time.Now(),
+	}
+	c.lastActivity.Store(c.opened.UnixNano())
+	return c, nil
+}
+
+// startKeepalive watches the connection for stalls, see WithKeepalive
+func (c *Connection) startKeepalive(config KeepaliveConfig) {
+	c.keepalive = newKeepalive(c.ConnWithoutAddr, config)
+}
+
+func (c *Connection) Read(b []byte) (int, error) {
+	if c.shaper != nil {
+		b = b[:c.shaper.chunk(len(b), false)]
+	}
+	n, err := c.ConnWithoutAddr.Read(b)
+	c.recordReceived(n)
+	if c.keepalive != nil {
+		if n > 0 {
+			c.keepalive.received()
+		}
+		if err != nil {
+			err = c.keepalive.err(err)
+		}
+	}
+	// the data is already received, waiting holds off the next read and
+	// with it the window the peer may send into
+	if c.shaper != nil && n > 0 {
+		if waitErr := c.shaper.wait(n, false); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (c *Connection) Write(b []byte) (int, error) {
+	if c.shaper == nil {
+		return c.write(b)
+	}
+	written := 0
+	for len(b) > 0 {
+		chunk := b[:c.shaper.chunk(len(b), true)]
+		if err := c.shaper.wait(len(chunk), true); err != nil {
+			return written, err
+		}
+		n, err := c.write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+func (c *Connection) write(b []byte) (int, error) {
+	if c.keepalive == nil {
+		n, err := c.ConnWithoutAddr.Write(b)
+		c.recordSent(n)
+		return n, err
+	}
+	done := c.keepalive.writing()
+	n, err := c.ConnWithoutAddr.Write(b)
+	done()
+	c.recordSent(n)
+	if err != nil {
+		err = c.keepalive.err(err)
+	}
+	return n, err
+}
+
+func (c *Connection) Close() error {
+	if c.keepalive != nil {
+		c.keepalive.stop()
+	}
+	if c.shaper != nil {
+		c.shaper.cancel()
+	}
+	if c.onClose != nil {
+		c.onClose()
+	}
+	c.endAccept(errClosedBeforeUpgrade)
+	return c.ConnWithoutAddr.Close()
 }
 
 //I don't think these are used anywhere.. but must match the interface
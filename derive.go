@@ -0,0 +1,89 @@
+package i2p
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// HKDF info strings for the keys derived from a libp2p identity. Changing
+// them changes every derived destination.
+const (
+	deriveSigningInfo    = "banyan/transports/i2p v1 destination signing key"
+	deriveEncryptionInfo = "banyan/transports/i2p v1 destination encryption key"
+	derivePaddingInfo    = "banyan/transports/i2p v1 destination padding"
+
+	derivedPaddingPatternLength = 32
+)
+
+// DeriveKeys derives an I2P destination from a libp2p Ed25519 identity, so
+// the node's I2P address can be recreated from its peer identity alone. The
+// destination uses Ed25519 signatures and an ECIES-X25519 encryption key,
+// both derived from the identity with HKDF-SHA256 under separate labels, so
+// neither reuses the libp2p key itself. The padding between the keys is
+// derived too, the same identity always yields the same destination.
+//
+// Anyone holding the libp2p private key also holds the I2P keys. Nodes that
+// must be able to change one without the other should keep separate keys.
+func DeriveKeys(identity crypto.PrivKey) (i2pkeys.I2PKeys, error) {
+	if identity.Type() != crypto.Ed25519 {
+		return i2pkeys.I2PKeys{}, fmt.Errorf("I2P keys can only be derived from an Ed25519 identity, got %s", identity.Type())
+	}
+	raw, err := identity.Raw()
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	// libp2p stores the private key followed by the public key, the seed is
+	// the first half
+	secret := raw[:ed25519.SeedSize]
+
+	signingSeed, err := hkdf.Key(sha256.New, secret, nil, deriveSigningInfo, ed25519.SeedSize)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	encryptionSeed, err := hkdf.Key(sha256.New, secret, nil, deriveEncryptionInfo, encTypePrivateKeyLength[encTypeECIESX25519])
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	padding, err := hkdf.Key(sha256.New, secret, nil, derivePaddingInfo, derivedPaddingPatternLength)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+
+	signingKey := ed25519.NewKeyFromSeed(signingSeed)
+	encryptionKey, err := ecdh.X25519().NewPrivateKey(encryptionSeed)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	encryptionPublic := encryptionKey.PublicKey().Bytes()
+	signingPublic := signingKey.Public().(ed25519.PublicKey)
+
+	// the encryption key is left-aligned and the signing key right-aligned in
+	// the key area, the padding in between repeats a short pattern as
+	// recommended for compressibility
+	dest := make([]byte, destKeysLength, destMinLength+keyCertPayloadMinSize)
+	copy(dest, encryptionPublic)
+	paddingArea := dest[len(encryptionPublic) : destKeysLength-len(signingPublic)]
+	for i := range paddingArea {
+		paddingArea[i] = padding[i%len(padding)]
+	}
+	copy(dest[destKeysLength-len(signingPublic):], signingPublic)
+	dest = append(dest, certTypeKey, 0, keyCertPayloadMinSize)
+	dest = binary.BigEndian.AppendUint16(dest, sigTypeEd25519)
+	dest = binary.BigEndian.AppendUint16(dest, encTypeECIESX25519)
+
+	private := append([]byte{}, dest...)
+	private = append(private, encryptionKey.Bytes()...)
+	private = append(private, signingSeed...)
+
+	return i2pkeys.NewKeys(
+		i2pkeys.I2PAddr(i2pB64Encoding.EncodeToString(dest)),
+		i2pB64Encoding.EncodeToString(private),
+	), nil
+}
@@ -0,0 +1,59 @@
+package i2p
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeriveKeysIsDeterministic(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+	identity, err := crypto.UnmarshalEd25519PrivateKey(ed25519.NewKeyFromSeed(seed))
+	require.NoError(t, err)
+
+	keys, err := DeriveKeys(identity)
+	require.NoError(t, err)
+	again, err := DeriveKeys(identity)
+	require.NoError(t, err)
+	assert.Equal(t, keys, again)
+	// pinned so a change to the derivation, which would move every node's
+	// address, cannot go unnoticed
+	assert.Equal(t, "pkk6ayjskiaapfarsp4ph7o2jyafwo33c4rykkgst53bdmlbm62q.b32.i2p", keys.Addr().Base32())
+
+	other, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	otherKeys, err := DeriveKeys(other)
+	require.NoError(t, err)
+	assert.NotEqual(t, keys.Addr(), otherKeys.Addr())
+
+	parsed, err := parsePrivateKeys(keys)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(sigTypeEd25519), parsed.dest.sigType)
+	assert.Equal(t, uint16(encTypeECIESX25519), parsed.dest.encType)
+	assert.Nil(t, parsed.offline)
+
+	// the destination must be signed by the derived key, not the identity
+	derived := ed25519.NewKeyFromSeed(parsed.signingPrivateKey)
+	assert.Equal(t, []byte(derived.Public().(ed25519.PublicKey)), parsed.dest.signingPublicKey)
+	assert.NotEqual(t, seed, parsed.signingPrivateKey)
+
+	addr, err := I2PAddrToMultiAddr(string(keys.Addr()))
+	require.NoError(t, err)
+	assert.Equal(t, ma.P_GARLIC64, addr.Protocols()[0].Code)
+
+	_, err = NewOfflineKeys(keys, time.Now().Add(time.Hour))
+	assert.NoError(t, err, "derived keys can serve as the cold key for offline signing")
+}
+
+func TestDeriveKeysRejectsOtherKeyTypes(t *testing.T) {
+	identity, _, err := crypto.GenerateSecp256k1Key(nil)
+	require.NoError(t, err)
+	_, err = DeriveKeys(identity)
+	assert.Error(t, err)
+}
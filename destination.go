@@ -0,0 +1,151 @@
+package i2p
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+// I2P uses its own base64 and base32 alphabets for destinations and addresses
+const (
+	i2pB64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~"
+	i2pB32Alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+)
+
+var (
+	i2pB64Encoding = base64.NewEncoding(i2pB64Alphabet)
+	i2pB32Encoding = base32.NewEncoding(i2pB32Alphabet).WithPadding(base32.NoPadding)
+)
+
+// Signature and encryption types from the I2P common structures spec
+const (
+	sigTypeDSASHA1       = 0
+	sigTypeECDSASHA256   = 1
+	sigTypeECDSASHA384   = 2
+	sigTypeECDSASHA512   = 3
+	sigTypeEd25519       = 7
+	sigTypeRedDSAEd25519 = 11
+	encTypeElGamal       = 0
+	encTypeECIESX25519   = 4
+)
+
+// Layout of a serialized destination: public key, signing key, certificate
+const (
+	certTypeNull          = 0
+	certTypeKey           = 5
+	destPublicKeyLength   = 256
+	destSigningKeyLength  = 128
+	destKeysLength        = destPublicKeyLength + destSigningKeyLength
+	destCertHeaderLength  = 3
+	destMinLength         = destKeysLength + destCertHeaderLength
+	keyCertPayloadMinSize = 4
+)
+
+// signing public key lengths for the signature types we know how to handle
+var sigTypePublicKeyLength = map[uint16]int{
+	sigTypeDSASHA1:       128,
+	sigTypeECDSASHA256:   64,
+	sigTypeECDSASHA384:   96,
+	sigTypeECDSASHA512:   132,
+	sigTypeEd25519:       32,
+	sigTypeRedDSAEd25519: 32,
+}
+
+// signing private key lengths, as stored after the destination in a private key blob
+var sigTypePrivateKeyLength = map[uint16]int{
+	sigTypeDSASHA1:       20,
+	sigTypeECDSASHA256:   32,
+	sigTypeECDSASHA384:   48,
+	sigTypeECDSASHA512:   66,
+	sigTypeEd25519:       32,
+	sigTypeRedDSAEd25519: 32,
+}
+
+// encryption private key lengths, as stored after the destination in a
+// private key blob. Unlike the public key they are not padded.
+var encTypePrivateKeyLength = map[uint16]int{
+	encTypeElGamal:     256,
+	encTypeECIESX25519: 32,
+}
+
+// signature lengths, needed to walk an offline signature section
+var sigTypeSignatureLength = map[uint16]int{
+	sigTypeDSASHA1:       40,
+	sigTypeECDSASHA256:   64,
+	sigTypeECDSASHA384:   96,
+	sigTypeECDSASHA512:   132,
+	sigTypeEd25519:       64,
+	sigTypeRedDSAEd25519: 64,
+}
+
+// destination is the decoded form of an I2P destination. Only the parts of the
+// structure this package needs are kept.
+type destination struct {
+	raw              []byte
+	sigType          uint16
+	encType          uint16
+	signingPublicKey []byte
+}
+
+// parseDestination decodes a base64 I2P destination and reads its key
+// certificate
+func parseDestination(addr i2pkeys.I2PAddr) (*destination, error) {
+	raw, err := i2pB64Encoding.DecodeString(string(addr))
+	if err != nil {
+		return nil, fmt.Errorf("destination is not valid I2P base64: %w", err)
+	}
+	return parseDestinationBytes(raw)
+}
+
+// parseDestinationBytes decodes the destination at the start of raw, which
+// may be followed by other data such as private keys. The returned
+// destination's raw field only covers the destination itself.
+func parseDestinationBytes(raw []byte) (*destination, error) {
+	if len(raw) < destMinLength {
+		return nil, fmt.Errorf("destination is %d bytes, expected at least %d", len(raw), destMinLength)
+	}
+
+	certType := raw[destKeysLength]
+	certLength := int(binary.BigEndian.Uint16(raw[destKeysLength+1:]))
+	payload := raw[destMinLength:]
+	if len(payload) < certLength {
+		return nil, fmt.Errorf("destination certificate is truncated: %d of %d bytes", len(payload), certLength)
+	}
+	payload = payload[:certLength]
+	dest := &destination{raw: raw[:destMinLength+certLength]}
+
+	switch certType {
+	case certTypeNull:
+		dest.sigType = sigTypeDSASHA1
+		dest.encType = encTypeElGamal
+	case certTypeKey:
+		if certLength < keyCertPayloadMinSize {
+			return nil, fmt.Errorf("key certificate payload is %d bytes, expected at least %d", certLength, keyCertPayloadMinSize)
+		}
+		dest.sigType = binary.BigEndian.Uint16(payload[0:2])
+		dest.encType = binary.BigEndian.Uint16(payload[2:4])
+	default:
+		return nil, fmt.Errorf("unsupported destination certificate type %d", certType)
+	}
+
+	keyLength, ok := sigTypePublicKeyLength[dest.sigType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported destination signature type %d", dest.sigType)
+	}
+	if keyLength <= destSigningKeyLength {
+		// signing keys shorter than the field are right-aligned, padding comes first
+		dest.signingPublicKey = raw[destKeysLength-keyLength : destKeysLength]
+	} else {
+		// longer keys spill over into the key certificate payload
+		excess := keyLength - destSigningKeyLength
+		if certLength < keyCertPayloadMinSize+excess {
+			return nil, fmt.Errorf("key certificate is missing %d bytes of signing key", excess)
+		}
+		dest.signingPublicKey = append(append([]byte{}, raw[destPublicKeyLength:destKeysLength]...), payload[keyCertPayloadMinSize:keyCertPayloadMinSize+excess]...)
+	}
+
+	return dest, nil
+}
@@ -0,0 +1,269 @@
+package i2p
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// dialErrorHistory is how many failed dials Diagnostics reports
+const dialErrorHistory = 16
+
+// Diagnostics is a snapshot of the transport's state, see
+// I2PTransport.Diagnostics. It marshals to JSON.
+type Diagnostics struct {
+	// SAMEndpoint is the bridge the sessions are on, or were last tried on
+	// while no router is available, out of SAMEndpoints
+	SAMEndpoint  string   `json:"sam_endpoint"`
+	SAMEndpoints []string `json:"sam_endpoints"`
+	// SAMVersion is the SAM version negotiated with SAMEndpoint
+	SAMVersion string `json:"sam_version"`
+
+	// SessionsUp is false from losing the router, at LostSince, until the
+	// sessions are recovered
+	SessionsUp bool               `json:"sessions_up"`
+	LostSince  *time.Time         `json:"lost_since,omitempty"`
+	Sessions   SessionDiagnostics `json:"sessions"`
+
+	// our own destination, and the address peers dial us on, which is the
+	// blinded address with an encrypted LeaseSet
+	Base32     string `json:"b32"`
+	Base64     string `json:"b64"`
+	ListenAddr string `json:"listen_addr"`
+
+	Listeners   []ListenerDiagnostics `json:"listeners"`
+	Connections ConnectionDiagnostics `json:"connections"`
+
+	// RecentDialErrors are the last failed dials, oldest first. Destinations
+	// are redacted as in logs, see WithLogRedaction.
+	RecentDialErrors []DialErrorDiagnostics `json:"recent_dial_errors"`
+
+	Resolver ResolverDiagnostics `json:"resolver"`
+}
+
+// SessionDiagnostics names the SAM sessions of the transport
+type SessionDiagnostics struct {
+	Primary  string `json:"primary"`
+	Inbound  string `json:"inbound"`
+	Outbound string `json:"outbound"`
+	// Blinded are the sessions dialing blinded destinations
+	Blinded []string `json:"blinded,omitempty"`
+}
+
+type ListenerDiagnostics struct {
+	Addr string `json:"addr"`
+	// Suspended is set while the listener waits for the sessions to be
+	// rebuilt, e.g. during a failover
+	Suspended bool `json:"suspended"`
+}
+
+type ConnectionDiagnostics struct {
+	Open     int `json:"open"`
+	Inbound  int `json:"inbound"`
+	Outbound int `json:"outbound"`
+}
+
+type DialErrorDiagnostics struct {
+	Time        time.Time `json:"time"`
+	Destination string    `json:"destination"`
+	// Attempts is zero when the dial failed before reaching the router
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+}
+
+// ResolverDiagnostics reports the LeaseSet lookups the transport caused.
+// The router keeps the LeaseSets it looked up, its cache cannot be inspected
+// over SAM.
+type ResolverDiagnostics struct {
+	// LeaseSet lookups of Prefetch, and those that failed
+	PrefetchLookups  uint64     `json:"prefetch_lookups"`
+	PrefetchFailures uint64     `json:"prefetch_failures"`
+	LastPrefetch     *time.Time `json:"last_prefetch,omitempty"`
+
+	// DialLookups counts the stream connects that had the router look up the
+	// peer's LeaseSet, one per attempt. DialLookupFailures are those the
+	// router failed naming the LeaseSet, other connect failures are not
+	// counted.
+	DialLookups        uint64     `json:"dial_lookups"`
+	DialLookupFailures uint64     `json:"dial_lookup_failures"`
+	LastDialLookup     *time.Time `json:"last_dial_lookup,omitempty"`
+}
+
+// resolverStats counts the lookups of Prefetch and of dials
+type resolverStats struct {
+	prefetch lookupStats
+	dial     lookupStats
+}
+
+type lookupStats struct {
+	lookups  atomic.Uint64
+	failures atomic.Uint64
+	// unix nanoseconds, zero before the first lookup
+	last atomic.Int64
+}
+
+func (s *lookupStats) record(failed bool) {
+	s.lookups.Add(1)
+	if failed {
+		s.failures.Add(1)
+	}
+	s.last.Store(time.Now().UnixNano())
+}
+
+func (s *lookupStats) lastLookup() *time.Time {
+	last := s.last.Load()
+	if last == 0 {
+		return nil
+	}
+	lastLookup := time.Unix(0, last)
+	return &lastLookup
+}
+
+// recordDial counts the lookup behind a stream connect attempt. Connects the
+// router refused before resolving the peer, or that never reached it, are
+// skipped.
+func (s *resolverStats) recordDial(err error) {
+	if err == nil {
+		s.dial.record(false)
+		return
+	}
+	var samErr *SAMError
+	if !errors.As(err, &samErr) || samErr.Command != "STREAM CONNECT" {
+		return
+	}
+	switch samErr.Result {
+	case "INVALID_KEY", "INVALID_ID":
+		return
+	case "PEER_NOT_FOUND":
+		s.dial.record(true)
+		return
+	}
+	s.dial.record(strings.Contains(strings.ToLower(samErr.Message), "leaseset"))
+}
+
+// dialErrorLog keeps the last failed dials
+type dialErrorLog struct {
+	mu     sync.Mutex
+	recent []DialErrorDiagnostics
+}
+
+func (l *dialErrorLog) record(entry DialErrorDiagnostics) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.recent) == dialErrorHistory {
+		copy(l.recent, l.recent[1:])
+		l.recent = l.recent[:dialErrorHistory-1]
+	}
+	l.recent = append(l.recent, entry)
+}
+
+func (l *dialErrorLog) snapshot() []DialErrorDiagnostics {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]DialErrorDiagnostics{}, l.recent...)
+}
+
+// recordDialError keeps err for Diagnostics, redacted like logs
+func (i2p *I2PTransport) recordDialError(dest string, err error) {
+	entry := DialErrorDiagnostics{
+		Time:        time.Now(),
+		Destination: i2p.logRedaction.redactDestination(dest),
+		Error:       i2p.logRedaction.redactText(err.Error()),
+	}
+	var dialErr *DialError
+	if errors.As(err, &dialErr) {
+		entry.Attempts = dialErr.Attempts
+	}
+	i2p.dialErrors.record(entry)
+}
+
+// Diagnostics returns a snapshot of the transport's state for debugging
+func (i2p *I2PTransport) Diagnostics() Diagnostics {
+	i2p.mu.RLock()
+	d := Diagnostics{
+		SAMEndpoint: i2p.samEndpoints[i2p.activeEndpoint].Address(),
+		SAMVersion:  i2p.primarySession.version,
+		SessionsUp:  i2p.lostAt.IsZero(),
+		Sessions: SessionDiagnostics{
+			Primary:  i2p.primarySession.id,
+			Inbound:  i2p.inboundSession.id,
+			Outbound: i2p.outboundSession.id,
+		},
+		Base32:    i2p.i2PKeys.Addr().Base32(),
+		Base64:    i2p.i2PKeys.Addr().Base64(),
+		Listeners: []ListenerDiagnostics{},
+	}
+	if !i2p.lostAt.IsZero() {
+		lostAt := i2p.lostAt
+		d.LostSince = &lostAt
+	}
+	if i2p.listenAddr != nil {
+		d.ListenAddr = i2p.listenAddr.String()
+	}
+	for _, endpoint := range i2p.samEndpoints {
+		d.SAMEndpoints = append(d.SAMEndpoints, endpoint.Address())
+	}
+	for _, session := range i2p.blindedSessions {
+		d.Sessions.Blinded = append(d.Sessions.Blinded, session.id)
+	}
+	listeners := make([]*TransportListener, 0, len(i2p.listeners))
+	for listener := range i2p.listeners {
+		listeners = append(listeners, listener)
+	}
+	for conn := range i2p.conns {
+		d.Connections.Open++
+		switch conn.direction {
+		case network.DirInbound:
+			d.Connections.Inbound++
+		case network.DirOutbound:
+			d.Connections.Outbound++
+		}
+	}
+	i2p.mu.RUnlock()
+
+	sort.Strings(d.Sessions.Blinded)
+	for _, listener := range listeners {
+		listener.mu.RLock()
+		d.Listeners = append(d.Listeners, ListenerDiagnostics{
+			Addr:      listener.multiAddr.String(),
+			Suspended: listener.rebinding != nil,
+		})
+		listener.mu.RUnlock()
+	}
+	sort.Slice(d.Listeners, func(i, j int) bool { return d.Listeners[i].Addr < d.Listeners[j].Addr })
+
+	d.RecentDialErrors = i2p.dialErrors.snapshot()
+	d.Resolver = ResolverDiagnostics{
+		PrefetchLookups:    i2p.resolver.prefetch.lookups.Load(),
+		PrefetchFailures:   i2p.resolver.prefetch.failures.Load(),
+		LastPrefetch:       i2p.resolver.prefetch.lastLookup(),
+		DialLookups:        i2p.resolver.dial.lookups.Load(),
+		DialLookupFailures: i2p.resolver.dial.failures.Load(),
+		LastDialLookup:     i2p.resolver.dial.lastLookup(),
+	}
+	return d
+}
+
+// DiagnosticsHandler returns an http.Handler serving Diagnostics as JSON.
+// The snapshot includes our destination, mount it where only operators can
+// reach it.
+func (i2p *I2PTransport) DiagnosticsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(i2p.Diagnostics())
+	})
+}
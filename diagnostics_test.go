@@ -0,0 +1,155 @@
+package i2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnostics(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInAcceptTimeout(20*time.Millisecond))
+	server, serverID := newStandInTransport(t, standIn)
+	silent, silentID := newStandInTransport(t, standIn)
+	client, _ := newStandInTransport(t, standIn)
+
+	listener, err := server.Listen(standInListenAddr(t, server))
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveEcho(listener)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, listener.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// nobody listens on the silent transport
+	silentAddr := standInListenAddr(t, silent)
+	_, err = client.Dial(ctx, silentAddr, silentID)
+	require.Error(t, err)
+	require.NoError(t, client.Prefetch(ctx, listener.Multiaddr()))
+
+	d := client.Diagnostics()
+	assert.Equal(t, standIn.config().Address(), d.SAMEndpoint)
+	assert.Equal(t, []string{standIn.config().Address()}, d.SAMEndpoints)
+	assert.NotEmpty(t, d.SAMVersion)
+	assert.True(t, d.SessionsUp)
+	assert.Nil(t, d.LostSince)
+	assert.True(t, strings.HasPrefix(d.Sessions.Primary, "primarySession-"))
+	assert.True(t, strings.HasPrefix(d.Sessions.Inbound, "inboundSession-"))
+	assert.True(t, strings.HasPrefix(d.Sessions.Outbound, "outboundSession-"))
+	assert.Equal(t, client.i2PKeys.Addr().Base32(), d.Base32)
+	assert.Equal(t, standInListenAddr(t, client).String(), d.ListenAddr)
+	assert.Empty(t, d.Listeners)
+	assert.Equal(t, ConnectionDiagnostics{Open: 1, Outbound: 1}, d.Connections)
+
+	require.Len(t, d.RecentDialErrors, 1)
+	dialErr := d.RecentDialErrors[0]
+	assert.Equal(t, 1, dialErr.Attempts)
+	silentDest, err := MultiAddrToI2PAddr(silentAddr)
+	require.NoError(t, err)
+	assert.Equal(t, RedactHash.redactDestination(silentDest), dialErr.Destination)
+	assert.NotContains(t, dialErr.Error, silentDest, "destinations are redacted as in logs")
+
+	assert.EqualValues(t, 1, d.Resolver.PrefetchLookups)
+	assert.Zero(t, d.Resolver.PrefetchFailures)
+	assert.NotNil(t, d.Resolver.LastPrefetch)
+	// both dials had the router look their peer up, the silent one failed
+	// only for want of a listener
+	assert.EqualValues(t, 2, d.Resolver.DialLookups)
+	assert.Zero(t, d.Resolver.DialLookupFailures)
+	assert.NotNil(t, d.Resolver.LastDialLookup)
+
+	serverDiagnostics := server.Diagnostics()
+	require.Len(t, serverDiagnostics.Listeners, 1)
+	assert.Equal(t, listener.Multiaddr().String(), serverDiagnostics.Listeners[0].Addr)
+	assert.False(t, serverDiagnostics.Listeners[0].Suspended)
+	assert.Eventually(t, func() bool {
+		return server.Diagnostics().Connections == ConnectionDiagnostics{Open: 1, Inbound: 1}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDiagnosticsHandler(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	transport, _ := newStandInTransport(t, standIn)
+	handler := transport.DiagnosticsHandler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var served map[string]any
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &served))
+	assert.Equal(t, transport.i2PKeys.Addr().Base32(), served["b32"])
+	assert.Equal(t, true, served["sessions_up"])
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestDialErrorLogKeepsRecent(t *testing.T) {
+	var log dialErrorLog
+	for i := range dialErrorHistory + 3 {
+		log.record(DialErrorDiagnostics{Error: strconv.Itoa(i)})
+	}
+	recent := log.snapshot()
+	require.Len(t, recent, dialErrorHistory)
+	assert.Equal(t, "3", recent[0].Error)
+	assert.Equal(t, strconv.Itoa(dialErrorHistory+2), recent[len(recent)-1].Error)
+}
+
+func TestRecordDialErrorAttempts(t *testing.T) {
+	i2p := &I2PTransport{}
+	i2p.recordDialError("peer.i2p", &DialError{Addr: "peer.i2p", Attempts: 3, Err: errors.New("CANT_REACH_PEER")})
+	i2p.recordDialError("peer.i2p", errors.New("not a valid I2P address"))
+	recent := i2p.dialErrors.snapshot()
+	require.Len(t, recent, 2)
+	assert.Equal(t, 3, recent[0].Attempts)
+	assert.Zero(t, recent[1].Attempts)
+}
+
+func TestRecordDialErrorRedactsAddresses(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	client, _ := newStandInTransport(t, standIn)
+
+	keys, err := newStandInKeys()
+	require.NoError(t, err)
+	unknown := keys.Addr()
+	names := []string{
+		string(unknown),
+		strings.TrimSuffix(unknown.Base32(), b32Suffix),
+		// not a valid b33 name, the dial fails before reaching the router
+		strings.Repeat("a", 60),
+	}
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/garlic64/" + names[0]),
+		ma.StringCast("/garlic32/" + names[1]),
+		ma.StringCast("/garlic32/" + names[2]),
+	}
+	for i, addr := range addrs {
+		_, err := client.Dial(context.Background(), addr, "")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), names[i][:20], "the error names the address")
+	}
+
+	recent := client.Diagnostics().RecentDialErrors
+	require.Len(t, recent, 3)
+	for _, entry := range recent {
+		for _, name := range names {
+			assert.NotContains(t, entry.Error, name[:20])
+			assert.NotContains(t, entry.Destination, name[:20])
+		}
+	}
+	assert.Contains(t, recent[2].Error, "/garlic32/#", "the component is kept, its value hashed")
+}
@@ -0,0 +1,71 @@
+package i2p
+
+import (
+	"context"
+	"sync"
+)
+
+// dialGroup coalesces concurrent stream connects to the same destination.
+// An I2P stream cannot be handed to more than one caller, so only the first
+// connect runs right away. The others wait for it: when it fails they fail
+// the same way, when it succeeds they connect to a peer whose LeaseSet the
+// router just looked up, over tunnels that are already built, instead of
+// each doing that work themselves.
+type dialGroup struct {
+	mu       sync.Mutex
+	inflight map[string]*dialCall
+}
+
+type dialCall struct {
+	done chan struct{}
+	err  error
+	// set when the connect ended because its caller's context did, which
+	// says nothing about the destination
+	cancelled bool
+}
+
+// do runs connect for dest, after waiting for a connect to dest already in
+// flight. coalesced reports whether the caller had to wait.
+func (g *dialGroup) do(ctx context.Context, dest string, connect func() (*samStream, error)) (conn *samStream, coalesced bool, err error) {
+	for {
+		g.mu.Lock()
+		if g.inflight == nil {
+			g.inflight = make(map[string]*dialCall)
+		}
+		call, ok := g.inflight[dest]
+		if !ok {
+			call = &dialCall{done: make(chan struct{})}
+			g.inflight[dest] = call
+			g.mu.Unlock()
+			break
+		}
+		g.mu.Unlock()
+
+		coalesced = true
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, coalesced, ctx.Err()
+		}
+		if call.cancelled {
+			// try again, possibly leading the next connect
+			continue
+		}
+		if call.err != nil {
+			return nil, coalesced, call.err
+		}
+		conn, err := connect()
+		return conn, coalesced, err
+	}
+
+	conn, err = connect()
+
+	g.mu.Lock()
+	call := g.inflight[dest]
+	delete(g.inflight, dest)
+	g.mu.Unlock()
+	call.err = err
+	call.cancelled = err != nil && ctx.Err() != nil
+	close(call.done)
+	return conn, coalesced, err
+}
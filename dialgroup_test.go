@@ -0,0 +1,138 @@
+package i2p
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentDialsShareFailure(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInAcceptTimeout(200*time.Millisecond))
+	server, serverID := newStandInTransport(t, standIn)
+	tracer := &recordingTracer{}
+	client, _ := newStandInTransport(t, standIn, WithMetricsTracer(tracer))
+	addr := standInListenAddr(t, server)
+
+	const dials = 5
+	var wg sync.WaitGroup
+	errs := make([]error, dials)
+	for i := 0; i < dials; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = client.Dial(context.Background(), addr, serverID)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		var dialErr *DialError
+		assert.True(t, errors.As(err, &dialErr), "%v", err)
+	}
+	assert.Equal(t, 1, standIn.streamConnects(), "the unreachable peer is tried once")
+	tracer.mu.Lock()
+	assert.Equal(t, dials-1, tracer.coalesced)
+	tracer.mu.Unlock()
+}
+
+func TestConcurrentDialsGetTheirOwnConnections(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	server, serverID := newStandInTransport(t, standIn)
+	client, _ := newStandInTransport(t, standIn)
+
+	listener, err := server.Listen(standInListenAddr(t, server))
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveEcho(listener)
+
+	const dials = 5
+	var wg sync.WaitGroup
+	conns := make([]transport.CapableConn, dials)
+	errs := make([]error, dials)
+	for i := 0; i < dials; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			conns[i], errs[i] = client.Dial(ctx, listener.Multiaddr(), serverID)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[transport.CapableConn]bool)
+	for i := 0; i < dials; i++ {
+		require.NoError(t, errs[i])
+		assert.False(t, seen[conns[i]])
+		seen[conns[i]] = true
+		conns[i].Close()
+	}
+	assert.Equal(t, dials, standIn.streamConnects())
+}
+
+func TestDialGroupDoesNotShareCancellation(t *testing.T) {
+	var group dialGroup
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	started := make(chan struct{})
+
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, _, err := group.do(leaderCtx, "dest", func() (*samStream, error) {
+			close(started)
+			<-leaderCtx.Done()
+			return nil, leaderCtx.Err()
+		})
+		leaderErr <- err
+	}()
+	<-started
+
+	followerDone := make(chan struct{})
+	var (
+		conn      *samStream
+		coalesced bool
+		err       error
+	)
+	go func() {
+		defer close(followerDone)
+		conn, coalesced, err = group.do(context.Background(), "dest", func() (*samStream, error) {
+			return &samStream{}, nil
+		})
+	}()
+
+	// give the follower time to start waiting on the leader
+	time.Sleep(50 * time.Millisecond)
+	cancelLeader()
+	assert.ErrorIs(t, <-leaderErr, context.Canceled)
+	<-followerDone
+	require.NoError(t, err)
+	assert.NotNil(t, conn)
+	assert.True(t, coalesced)
+}
+
+func TestDialGroupFollowerHonorsItsContext(t *testing.T) {
+	var group dialGroup
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	go group.do(context.Background(), "dest", func() (*samStream, error) {
+		close(started)
+		<-release
+		return nil, errors.New("unreachable")
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, coalesced, err := group.do(ctx, "dest", func() (*samStream, error) {
+		t.Error("the follower must not connect while the leader is in flight")
+		return nil, nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, coalesced)
+}
@@ -0,0 +1,150 @@
+package i2p
+
+import (
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// EvtI2PSessionCreated is emitted whenever the PRIMARY session is created on
+// a SAM router: at startup, after failing over and when the transport
+// rebuilds its sessions to republish the LeaseSet. Subscribers receive the
+// latest one even when they subscribe later.
+type EvtI2PSessionCreated struct {
+	// Endpoint is the address of the router, see SAMConfig.Address
+	Endpoint string
+	// SessionID is the ID of the PRIMARY session
+	SessionID string
+}
+
+// EvtI2PTunnelsReady is emitted once the stream subsessions are up and
+// listeners are bound, so the transport can dial and accept. Subscribers
+// receive the latest one even when they subscribe later.
+type EvtI2PTunnelsReady struct {
+	Endpoint string
+	// ListenAddr is the address peers dial us on
+	ListenAddr ma.Multiaddr
+}
+
+// EvtI2PSessionLost is emitted when the router in use becomes unavailable.
+// The transport can neither dial nor accept until EvtI2PSessionRecovered.
+type EvtI2PSessionLost struct {
+	Endpoint string
+	Err      error
+}
+
+// EvtI2PSessionRecovered is emitted once the sessions are established again
+// after EvtI2PSessionLost, possibly on another router
+type EvtI2PSessionRecovered struct {
+	Endpoint string
+	// Downtime is how long the transport was without sessions
+	Downtime time.Duration
+}
+
+// EvtI2PDestinationChanged is emitted when the sessions were rebuilt and
+// the address peers dial us on differs from before
+type EvtI2PDestinationChanged struct {
+	Previous ma.Multiaddr
+	Current  ma.Multiaddr
+}
+
+// eventEmitters holds an emitter for each event type
+type eventEmitters map[reflect.Type]event.Emitter
+
+func newEventEmitters(bus event.Bus) (eventEmitters, error) {
+	emitters := make(eventEmitters)
+	stateful := []any{new(EvtI2PSessionCreated), new(EvtI2PTunnelsReady)}
+	transient := []any{new(EvtI2PSessionLost), new(EvtI2PSessionRecovered), new(EvtI2PDestinationChanged), new(SAMRouterEvent)}
+	for i, evtType := range append(stateful, transient...) {
+		var opts []event.EmitterOpt
+		if i < len(stateful) {
+			opts = append(opts, eventbus.Stateful)
+		}
+		emitter, err := bus.Emitter(evtType, opts...)
+		if err != nil {
+			emitters.Close()
+			return nil, err
+		}
+		emitters[reflect.TypeOf(evtType).Elem()] = emitter
+	}
+	return emitters, nil
+}
+
+func (e eventEmitters) emit(evt any) {
+	if emitter, ok := e[reflect.TypeOf(evt)]; ok {
+		emitter.Emit(evt)
+	}
+}
+
+func (e eventEmitters) Close() error {
+	var errs []error
+	for _, emitter := range e {
+		errs = append(errs, emitter.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// WithEventBus emits the EvtI2P* events and SAMRouterEvent on bus, starting
+// with the sessions created when the transport is built. A host's bus only
+// exists once the transport is built, see SetEventBus to attach it later.
+func WithEventBus(bus event.Bus) Option {
+	return func(i2p *I2PTransport) error {
+		emitters, err := newEventEmitters(bus)
+		if err != nil {
+			return err
+		}
+		i2p.events = emitters
+		return nil
+	}
+}
+
+// SetEventBus emits events on bus from now on instead of the bus set before.
+// When the sessions are up, EvtI2PSessionCreated and EvtI2PTunnelsReady are
+// emitted right away, so subscribers of a host's bus see the current state.
+func (i2p *I2PTransport) SetEventBus(bus event.Bus) error {
+	emitters, err := newEventEmitters(bus)
+	if err != nil {
+		return err
+	}
+
+	// held so the sessions do not change between reporting them and
+	// attaching the bus
+	i2p.republishMu.Lock()
+	defer i2p.republishMu.Unlock()
+	i2p.mu.Lock()
+	previous := i2p.events
+	i2p.events = emitters
+	up := i2p.lostAt.IsZero() && i2p.primarySession != nil
+	var created EvtI2PSessionCreated
+	var ready EvtI2PTunnelsReady
+	if up {
+		endpoint := i2p.samEndpoints[i2p.activeEndpoint].Address()
+		created = EvtI2PSessionCreated{Endpoint: endpoint, SessionID: i2p.primarySession.id}
+		ready = EvtI2PTunnelsReady{Endpoint: endpoint, ListenAddr: i2p.listenAddr}
+	}
+	i2p.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	if up {
+		emitters.emit(created)
+		emitters.emit(ready)
+	}
+	return nil
+}
+
+// emit sends evt to the event bus, if there is one. It must not be called
+// with i2p.mu held, subscribers may call back into the transport.
+func (i2p *I2PTransport) emit(evt any) {
+	i2p.mu.RLock()
+	emitters := i2p.events
+	i2p.mu.RUnlock()
+	if emitters != nil {
+		emitters.emit(evt)
+	}
+}
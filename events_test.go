@@ -0,0 +1,140 @@
+package i2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var lifecycleEvents = []any{
+	new(EvtI2PSessionCreated),
+	new(EvtI2PTunnelsReady),
+	new(EvtI2PSessionLost),
+	new(EvtI2PSessionRecovered),
+	new(EvtI2PDestinationChanged),
+}
+
+func subscribeLifecycle(t *testing.T, bus event.Bus) event.Subscription {
+	t.Helper()
+	sub, err := bus.Subscribe(lifecycleEvents, eventbus.BufSize(64))
+	require.NoError(t, err)
+	t.Cleanup(func() { sub.Close() })
+	return sub
+}
+
+func nextEvent(t *testing.T, sub event.Subscription) any {
+	t.Helper()
+	select {
+	case evt := <-sub.Out():
+		return evt
+	case <-time.After(10 * time.Second):
+		t.Fatal("no event was emitted")
+		return nil
+	}
+}
+
+func TestEventBusFailover(t *testing.T) {
+	first := newSAMStandIn(t)
+	second := newSAMStandIn(t)
+	bus := eventbus.NewBus()
+	sub := subscribeLifecycle(t, bus)
+	routerSub, err := bus.Subscribe(new(SAMRouterEvent), eventbus.BufSize(64))
+	require.NoError(t, err)
+	defer routerSub.Close()
+
+	server, _ := newStandInTransport(t, first,
+		WithSAMEndpoints(first.config(), second.config()),
+		WithSAMHealthCheck(50*time.Millisecond),
+		WithEventBus(bus),
+	)
+	listenAddr := standInListenAddr(t, server)
+
+	created := nextEvent(t, sub).(EvtI2PSessionCreated)
+	assert.Equal(t, first.config().Address(), created.Endpoint)
+	assert.NotEmpty(t, created.SessionID)
+	ready := nextEvent(t, sub).(EvtI2PTunnelsReady)
+	assert.Equal(t, first.config().Address(), ready.Endpoint)
+	assert.True(t, listenAddr.Equal(ready.ListenAddr))
+
+	first.Close()
+	lost := nextEvent(t, sub).(EvtI2PSessionLost)
+	assert.Equal(t, first.config().Address(), lost.Endpoint)
+	assert.Error(t, lost.Err)
+	created = nextEvent(t, sub).(EvtI2PSessionCreated)
+	assert.Equal(t, second.config().Address(), created.Endpoint)
+	ready = nextEvent(t, sub).(EvtI2PTunnelsReady)
+	assert.Equal(t, second.config().Address(), ready.Endpoint)
+	recovered := nextEvent(t, sub).(EvtI2PSessionRecovered)
+	assert.Equal(t, second.config().Address(), recovered.Endpoint)
+	assert.Positive(t, recovered.Downtime)
+
+	// SAMRouterEvents are bridged onto the bus as well
+	routerEvent := (<-routerSub.Out()).(SAMRouterEvent)
+	assert.True(t, routerEvent.Active)
+	assert.Equal(t, first.config().Address(), routerEvent.Endpoint)
+}
+
+func TestEventBusReportsLossOnce(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	bus := eventbus.NewBus()
+	sub := subscribeLifecycle(t, bus)
+	newStandInTransport(t, standIn, WithSAMHealthCheck(20*time.Millisecond), WithEventBus(bus))
+	nextEvent(t, sub)
+	nextEvent(t, sub)
+
+	standIn.Close()
+	assert.IsType(t, EvtI2PSessionLost{}, nextEvent(t, sub))
+	// every health check fails again while the router is gone
+	select {
+	case evt := <-sub.Out():
+		t.Fatalf("unexpected %T", evt)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestSetEventBus(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	transport, _ := newStandInTransport(t, standIn)
+
+	bus := eventbus.NewBus()
+	sub := subscribeLifecycle(t, bus)
+	require.NoError(t, transport.SetEventBus(bus))
+	created := nextEvent(t, sub).(EvtI2PSessionCreated)
+	assert.Equal(t, standIn.config().Address(), created.Endpoint)
+	ready := nextEvent(t, sub).(EvtI2PTunnelsReady)
+	assert.True(t, standInListenAddr(t, transport).Equal(ready.ListenAddr))
+
+	// components subscribing later still learn the tunnels are ready
+	late, err := bus.Subscribe(new(EvtI2PTunnelsReady))
+	require.NoError(t, err)
+	defer late.Close()
+	assert.Equal(t, ready, nextEvent(t, late))
+}
+
+func TestEventBusDestinationChanged(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	bus := eventbus.NewBus()
+	sub := subscribeLifecycle(t, bus)
+	transport, _ := newStandInTransport(t, standIn, WithEventBus(bus))
+	nextEvent(t, sub)
+	nextEvent(t, sub)
+	previous := standInListenAddr(t, transport)
+
+	// only the blinded address can be looked up once the LeaseSet is
+	// encrypted
+	transport.mu.Lock()
+	transport.encryptedLeaseSet = true
+	transport.mu.Unlock()
+	require.NoError(t, transport.republish(func() {}))
+
+	assert.IsType(t, EvtI2PSessionCreated{}, nextEvent(t, sub))
+	changed := nextEvent(t, sub).(EvtI2PDestinationChanged)
+	assert.True(t, previous.Equal(changed.Previous))
+	assert.True(t, standInListenAddr(t, transport).Equal(changed.Current))
+	assert.False(t, changed.Previous.Equal(changed.Current))
+	assert.IsType(t, EvtI2PTunnelsReady{}, nextEvent(t, sub))
+}
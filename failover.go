@@ -0,0 +1,335 @@
+package i2p
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/joomcode/errorx"
+)
+
+// defaultSAMHealthInterval is how often the primary session's SAM connection
+// is checked, see WithSAMHealthCheck
+const defaultSAMHealthInterval = 15 * time.Second
+
+// how long failover and republish wait for a router to create the sessions,
+// which includes building their tunnels
+const sessionCreateTimeout = 2 * time.Minute
+
+var errTransportClosed = errors.New("I2P transport closed")
+
+// SAMRouterEvent reports on the SAM routers the transport uses
+type SAMRouterEvent struct {
+	// Endpoint is the address of the router, see SAMConfig.Address
+	Endpoint string
+
+	// Active is set once the sessions are established on Endpoint.
+	// Otherwise the router was found unavailable, with Err as the reason.
+	Active bool
+	Err    error
+}
+
+// WithSAMEndpoints sets an ordered list of SAM bridges. Sessions are created
+// on the first one that is reachable. When the router in use becomes
+// unavailable, the sessions are recreated on the next one with the same
+// destination keys, wrapping around to the start of the list. Open I2P
+// connections do not survive the move.
+func WithSAMEndpoints(endpoints ...SAMConfig) Option {
+	return func(i2p *I2PTransport) error {
+		if len(endpoints) == 0 {
+			return errors.New("at least one SAM endpoint is required")
+		}
+		configs := make([]SAMConfig, len(endpoints))
+		for i, endpoint := range endpoints {
+			if err := endpoint.validate(); err != nil {
+				return errorx.Decorate(err, "Invalid SAM endpoint %d", i)
+			}
+			configs[i] = endpoint.withDefaults()
+		}
+		i2p.samEndpoints = configs
+		i2p.activeEndpoint = 0
+		return nil
+	}
+}
+
+// WithSAMRouterEvents calls handler whenever a router is found unavailable
+// and whenever the sessions are established on a router, including the first
+// time. It is called synchronously and must not block.
+func WithSAMRouterEvents(handler func(SAMRouterEvent)) Option {
+	return func(i2p *I2PTransport) error {
+		i2p.routerEvents = handler
+		return nil
+	}
+}
+
+// WithSAMHealthCheck sets how often the SAM connection of the primary
+// session is checked, every 15 seconds by default. Zero disables the check,
+// and with it failover.
+func WithSAMHealthCheck(interval time.Duration) Option {
+	return func(i2p *I2PTransport) error {
+		if interval < 0 {
+			return errors.New("SAM health check interval must not be negative")
+		}
+		i2p.samHealthInterval = interval
+		return nil
+	}
+}
+
+// ActiveSAMEndpoint returns the address of the SAM bridge the sessions are
+// on, or were last tried on while no router is available
+func (i2p *I2PTransport) ActiveSAMEndpoint() string {
+	return i2p.activeSAMConfig().Address()
+}
+
+func (i2p *I2PTransport) activeSAMConfig() SAMConfig {
+	i2p.mu.RLock()
+	defer i2p.mu.RUnlock()
+	return i2p.samEndpoints[i2p.activeEndpoint]
+}
+
+func (i2p *I2PTransport) emitRouterEvent(event SAMRouterEvent) {
+	if i2p.routerEvents != nil {
+		i2p.routerEvents(event)
+	}
+	i2p.emit(event)
+}
+
+// connectFirstEndpoint creates the sessions on the first reachable endpoint
+func (i2p *I2PTransport) connectFirstEndpoint(ctx context.Context) error {
+	var err error
+	for index := range i2p.samEndpoints {
+		if err = i2p.createSessionsOn(ctx, index); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// createSessionsOn makes endpoint the active one and creates the sessions
+// there, reporting the outcome as a SAMRouterEvent
+func (i2p *I2PTransport) createSessionsOn(ctx context.Context, endpoint int) error {
+	i2p.mu.Lock()
+	i2p.activeEndpoint = endpoint
+	i2p.mu.Unlock()
+
+	address := i2p.samEndpoints[endpoint].Address()
+	if err := i2p.createSessions(ctx); err != nil {
+		log.Warn("creating SAM sessions failed", "endpoint", address, i2p.logErr(err))
+		i2p.emitRouterEvent(SAMRouterEvent{Endpoint: address, Err: err})
+		return err
+	}
+	i2p.emitRouterEvent(SAMRouterEvent{Endpoint: address, Active: true})
+
+	i2p.mu.RLock()
+	id := i2p.primarySession.id
+	i2p.mu.RUnlock()
+	log.Info("SAM sessions created", "endpoint", address, "session", id)
+	i2p.emit(EvtI2PSessionCreated{Endpoint: address, SessionID: id})
+	return nil
+}
+
+// watchSAMRouter checks the primary session's SAM connection and fails over
+// when it is lost. While no router is available, every check retries the
+// whole list. A check is skipped while another command holds the connection,
+// such as a NAMING LOOKUP waiting for the router to search the network
+// database; that command fails on its own when the router is gone.
+func (i2p *I2PTransport) watchSAMRouter(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-i2p.closed:
+			return
+		case <-ticker.C:
+		}
+
+		i2p.mu.RLock()
+		primary := i2p.primarySession
+		i2p.mu.RUnlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), i2p.activeSAMConfig().ConnectTimeout)
+		err := primary.ping(ctx)
+		cancel()
+		if err != nil && !errors.Is(err, errSAMControlBusy) {
+			i2p.failover(primary, err)
+		}
+	}
+}
+
+// checkSAMRouter is called when a listener fails to accept, which is usually
+// the first sign of a lost router. Failing over here moves the listener
+// instead of letting it report the error.
+func (i2p *I2PTransport) checkSAMRouter(error) {
+	if i2p.samHealthInterval == 0 {
+		return
+	}
+	select {
+	case <-i2p.closed:
+		return
+	default:
+	}
+
+	i2p.mu.RLock()
+	primary := i2p.primarySession
+	i2p.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), i2p.activeSAMConfig().ConnectTimeout)
+	defer cancel()
+	if err := primary.ping(ctx); err != nil && !errors.Is(err, errSAMControlBusy) {
+		i2p.failover(primary, err)
+	}
+}
+
+// failover moves the sessions to the next endpoint that accepts them. When
+// none does, listeners stay suspended until a later attempt succeeds.
+// lost is the primary session found failing, nothing is done when it was
+// already replaced.
+func (i2p *I2PTransport) failover(lost *samPrimarySession, cause error) error {
+	i2p.republishMu.Lock()
+	defer i2p.republishMu.Unlock()
+
+	i2p.mu.RLock()
+	failed := i2p.activeEndpoint
+	current := i2p.primarySession
+	i2p.mu.RUnlock()
+	if current != lost {
+		return nil
+	}
+	i2p.emitRouterEvent(SAMRouterEvent{Endpoint: i2p.samEndpoints[failed].Address(), Err: cause})
+
+	// reported once, later attempts while no router is available find the
+	// sessions already lost
+	i2p.mu.Lock()
+	first := i2p.lostAt.IsZero()
+	if first {
+		i2p.lostAt = time.Now()
+	}
+	lostAt := i2p.lostAt
+	i2p.mu.Unlock()
+	if first {
+		log.Warn("SAM router lost", "endpoint", i2p.samEndpoints[failed].Address(), i2p.logErr(cause))
+		i2p.emit(EvtI2PSessionLost{Endpoint: i2p.samEndpoints[failed].Address(), Err: cause})
+	}
+
+	listeners := i2p.suspendListeners()
+	// blinded sessions live on the failed router too, they are recreated on
+	// the next dial
+	i2p.closeBlindedSessions()
+
+	err := cause
+	for offset := 1; offset <= len(i2p.samEndpoints); offset++ {
+		select {
+		case <-i2p.closed:
+			return errTransportClosed
+		default:
+		}
+		endpoint := (failed + offset) % len(i2p.samEndpoints)
+		if err = i2p.resumeListeners(listeners, endpoint); err == nil {
+			i2p.mu.Lock()
+			i2p.lostAt = time.Time{}
+			i2p.mu.Unlock()
+			log.Info("SAM sessions recovered", "endpoint", i2p.samEndpoints[endpoint].Address(), "downtime", time.Since(lostAt))
+			i2p.emit(EvtI2PSessionRecovered{
+				Endpoint: i2p.samEndpoints[endpoint].Address(),
+				Downtime: time.Since(lostAt),
+			})
+			return nil
+		}
+	}
+	if first {
+		log.Error("no SAM router is available, retrying on each health check", i2p.logErr(err))
+	}
+	return errorx.Decorate(err, "No SAM router is available")
+}
+
+// suspendListeners closes the primary session, holding off Accept on the
+// listeners until resumeListeners or releaseListeners
+func (i2p *I2PTransport) suspendListeners() []*TransportListener {
+	i2p.mu.RLock()
+	defer i2p.mu.RUnlock()
+
+	listeners := make([]*TransportListener, 0, len(i2p.listeners))
+	for listener := range i2p.listeners {
+		listeners = append(listeners, listener)
+	}
+	// the router refuses a second session for a destination that is still live
+	for _, listener := range listeners {
+		listener.suspend()
+	}
+	i2p.primarySession.Close()
+	return listeners
+}
+
+// resumeListeners creates the sessions on endpoint and moves the suspended
+// listeners over to the new inbound session
+func (i2p *I2PTransport) resumeListeners(listeners []*TransportListener, endpoint int) error {
+	ctx, cancel := i2p.sessionContext()
+	defer cancel()
+	if err := i2p.createSessionsOn(ctx, endpoint); err != nil {
+		return err
+	}
+
+	i2p.mu.Lock()
+	previousAddr := i2p.listenAddr
+	listenAddr, err := i2p.publishedAddr()
+	if err == nil {
+		i2p.listenAddr = listenAddr
+	}
+	primary := i2p.primarySession
+	inbound := i2p.inboundSession
+	i2p.mu.Unlock()
+	if err != nil {
+		primary.Close()
+		return err
+	}
+
+	for _, listener := range listeners {
+		listener.rebind(inbound.listen(), listenAddr)
+	}
+
+	if previousAddr != nil && !previousAddr.Equal(listenAddr) {
+		log.Info("listen address changed",
+			"previous", i2p.logRedaction.redactText(previousAddr.String()),
+			"current", i2p.logRedaction.redactText(listenAddr.String()))
+		i2p.emit(EvtI2PDestinationChanged{Previous: previousAddr, Current: listenAddr})
+	}
+	i2p.emit(EvtI2PTunnelsReady{Endpoint: i2p.samEndpoints[endpoint].Address(), ListenAddr: listenAddr})
+	return nil
+}
+
+// sessionContext bounds creating sessions in the background by
+// sessionCreateTimeout and the transport's lifetime, so a router that never
+// answers does not keep holding republishMu after Close
+func (i2p *I2PTransport) sessionContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), sessionCreateTimeout)
+	go func() {
+		select {
+		case <-i2p.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// releaseListeners lets Accept calls waiting on suspended listeners return
+// with their original error
+func releaseListeners(listeners []*TransportListener) {
+	for _, listener := range listeners {
+		listener.rebind(nil, nil)
+	}
+}
+
+// ping checks the control connection is still alive. It returns
+// errSAMControlBusy when another command holds the connection until ctx is
+// done.
+func (p *samPrimarySession) ping(ctx context.Context) error {
+	if err := p.lock(ctx); err != nil {
+		return err
+	}
+	defer p.unlock()
+	token := strconv.FormatInt(time.Now().UnixNano(), 10)
+	_, err := p.control.command(ctx, "PONG "+token, "PING "+token)
+	return err
+}
@@ -0,0 +1,127 @@
+package i2p
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// routerEvents collects SAMRouterEvents for a test
+func routerEvents() (chan SAMRouterEvent, Option) {
+	events := make(chan SAMRouterEvent, 64)
+	return events, WithSAMRouterEvents(func(event SAMRouterEvent) {
+		events <- event
+	})
+}
+
+func waitForActiveRouter(t *testing.T, events chan SAMRouterEvent, endpoint string) {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Active && event.Endpoint == endpoint {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("sessions were not established on %s", endpoint)
+		}
+	}
+}
+
+func TestSAMFailover(t *testing.T) {
+	first := newSAMStandIn(t)
+	second := newSAMStandIn(t)
+
+	events, eventsOption := routerEvents()
+	server, serverID := newStandInTransport(t, first,
+		WithSAMEndpoints(first.config(), second.config()),
+		WithSAMHealthCheck(100*time.Millisecond),
+		eventsOption,
+	)
+	waitForActiveRouter(t, events, first.config().Address())
+	assert.Equal(t, first.config().Address(), server.ActiveSAMEndpoint())
+
+	listener, err := server.Listen(standInListenAddr(t, server))
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveEcho(listener)
+
+	firstClient, _ := newStandInTransport(t, first)
+	requireEcho(t, firstClient, listener.Multiaddr(), serverID)
+
+	first.Close()
+	waitForActiveRouter(t, events, second.config().Address())
+	assert.Equal(t, second.config().Address(), server.ActiveSAMEndpoint())
+
+	// the same destination is now reachable through the second router, and
+	// the listener survived the move
+	secondClient, _ := newStandInTransport(t, second)
+	requireEcho(t, secondClient, listener.Multiaddr(), serverID)
+}
+
+func TestSAMFailoverSkipsUnreachableEndpoints(t *testing.T) {
+	// nothing listens on a closed listener's port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := closed.Addr().(*net.TCPAddr)
+	closed.Close()
+	unreachable := SAMConfig{Host: addr.IP.String(), Port: addr.Port, ConnectTimeout: time.Second}
+
+	standIn := newSAMStandIn(t)
+	events, eventsOption := routerEvents()
+	server, _ := newStandInTransport(t, standIn, WithSAMEndpoints(unreachable, standIn.config()), eventsOption)
+	assert.Equal(t, standIn.config().Address(), server.ActiveSAMEndpoint())
+
+	event := <-events
+	assert.Equal(t, unreachable.Address(), event.Endpoint)
+	assert.False(t, event.Active)
+	assert.Error(t, event.Err)
+	event = <-events
+	assert.Equal(t, standIn.config().Address(), event.Endpoint)
+	assert.True(t, event.Active)
+}
+
+func TestCloseStopsFailoverToSilentRouter(t *testing.T) {
+	first := newSAMStandIn(t)
+	silent := newSilentSAMBridge(t)
+
+	events, eventsOption := routerEvents()
+	server, _ := newStandInTransport(t, first,
+		WithSAMEndpoints(first.config(), silent),
+		WithSAMHealthCheck(100*time.Millisecond),
+		eventsOption,
+	)
+	waitForActiveRouter(t, events, first.config().Address())
+
+	first.Close()
+	require.Eventually(t, func() bool {
+		return server.ActiveSAMEndpoint() == silent.Address()
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// the silent router never creates the sessions, Close gives up on them
+	server.Close()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Endpoint == silent.Address() && event.Err != nil {
+				return
+			}
+		case <-timeout:
+			t.Fatal("failover kept waiting for the silent router after Close")
+		}
+	}
+}
+
+func TestWithSAMEndpointsValidates(t *testing.T) {
+	i2p := &I2PTransport{}
+	assert.Error(t, WithSAMEndpoints()(i2p))
+	assert.Error(t, WithSAMEndpoints(SAMConfig{}, SAMConfig{Port: -1})(i2p))
+	assert.Error(t, WithSAMHealthCheck(-time.Second)(i2p))
+	assert.NoError(t, WithSAMEndpoints(SAMConfig{}, SAMConfig{Port: 7657})(i2p))
+	assert.Len(t, i2p.samEndpoints, 2)
+}
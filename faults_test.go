@@ -0,0 +1,321 @@
+package i2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// faultyPair is a client transport and an echo server on a stand-in that
+// faults can be injected into
+type faultyPair struct {
+	standIn  *samStandIn
+	client   *I2PTransport
+	server   *I2PTransport
+	listener transport.Listener
+	addr     ma.Multiaddr
+	serverID peer.ID
+}
+
+func newFaultyPair(t *testing.T, clientOpts ...Option) *faultyPair {
+	t.Helper()
+	standIn := newSAMStandIn(t, withStandInAcceptTimeout(time.Second))
+	server, serverID := newStandInTransport(t, standIn)
+	client, _ := newStandInTransport(t, standIn, clientOpts...)
+
+	listener, err := server.Listen(standInListenAddr(t, server))
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go serveEcho(listener)
+	return &faultyPair{standIn: standIn, client: client, server: server, listener: listener, addr: listener.Multiaddr(), serverID: serverID}
+}
+
+func (p *faultyPair) dial(t *testing.T) transport.CapableConn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := p.client.Dial(ctx, p.addr, p.serverID)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// fastRetry retries quickly enough for faults cleared within a second
+var fastRetry = DialRetryPolicy{MaxAttempts: 20, InitialBackoff: 50 * time.Millisecond, MaxBackoff: 100 * time.Millisecond, Multiplier: 2}
+
+func TestDialLeaseSetNotFound(t *testing.T) {
+	pair := newFaultyPair(t, WithDialRetry(DialRetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond, Multiplier: 1}))
+	pair.standIn.inject(t, pair.addr, standInFaults{lookupFailure: true})
+
+	_, err := pair.client.Dial(context.Background(), pair.addr, pair.serverID)
+	var dialErr *DialError
+	require.True(t, errors.As(err, &dialErr), "%v", err)
+	assert.Equal(t, 3, dialErr.Attempts, "a missing LeaseSet is retried")
+	var samErr *SAMError
+	require.True(t, errors.As(err, &samErr), "%v", err)
+	assert.Equal(t, "CANT_REACH_PEER", samErr.Result)
+	resolver := pair.client.Diagnostics().Resolver
+	assert.EqualValues(t, 3, resolver.DialLookups)
+	assert.EqualValues(t, 3, resolver.DialLookupFailures, "each attempt missed the LeaseSet")
+
+	// looking the LeaseSet up ahead of time fails the same way
+	assert.ErrorContains(t, pair.client.Prefetch(context.Background(), pair.addr), "KEY_NOT_FOUND")
+}
+
+func TestDialDuringTunnelRebuild(t *testing.T) {
+	tracer := &recordingTracer{}
+	pair := newFaultyPair(t, WithDialRetry(fastRetry), WithMetricsTracer(tracer))
+	pair.standIn.inject(t, pair.addr, standInFaults{connectResult: "TIMEOUT"})
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		pair.standIn.inject(t, pair.addr, standInFaults{})
+	}()
+
+	requireEcho(t, pair.client, pair.addr, pair.serverID)
+	attempts, _ := tracer.recorded()
+	require.Len(t, attempts, 1)
+	assert.Greater(t, attempts[0], 1, "connects are retried until the tunnels are back")
+}
+
+func TestDialFailureNotRetried(t *testing.T) {
+	pair := newFaultyPair(t, WithDialRetry(fastRetry))
+	pair.standIn.inject(t, pair.addr, standInFaults{connectResult: `I2P_ERROR MESSAGE="Duplicated destination"`})
+
+	_, err := pair.client.Dial(context.Background(), pair.addr, pair.serverID)
+	var dialErr *DialError
+	require.True(t, errors.As(err, &dialErr), "%v", err)
+	assert.Equal(t, 1, dialErr.Attempts)
+	assert.ErrorContains(t, err, "Duplicated destination")
+}
+
+func TestDialSlowAccept(t *testing.T) {
+	pair := newFaultyPair(t)
+	pair.standIn.inject(t, pair.addr, standInFaults{acceptDelay: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := pair.client.Dial(ctx, pair.addr, pair.serverID)
+	assert.ErrorContains(t, err, "dial cancelled or timed out")
+	assert.Less(t, time.Since(start), time.Second, "the dial gives up at its deadline")
+
+	// the listener is handed the abandoned stream and keeps accepting
+	time.Sleep(time.Second)
+	pair.standIn.inject(t, pair.addr, standInFaults{acceptDelay: 100 * time.Millisecond})
+	start = time.Now()
+	requireEcho(t, pair.client, pair.addr, pair.serverID)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestStreamLatencyAndJitter(t *testing.T) {
+	pair := newFaultyPair(t)
+	pair.standIn.inject(t, pair.addr, standInFaults{latency: 10 * time.Millisecond, jitter: 30 * time.Millisecond})
+	conn := pair.dial(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := conn.OpenStream(ctx)
+	require.NoError(t, err)
+	defer stream.Close()
+
+	// jitter delays chunks by different amounts but must not reorder them
+	data := make([]byte, 256<<10)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	start := time.Now()
+	go func() {
+		for chunk := range slices.Chunk(data, 4<<10) {
+			if _, err := stream.Write(chunk); err != nil {
+				return
+			}
+		}
+		stream.CloseWrite()
+	}()
+	echoed, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, echoed), "echoed data differs")
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond, "a round trip crosses the latency twice")
+}
+
+func TestStalledStream(t *testing.T) {
+	pair := newFaultyPair(t)
+	conn := pair.dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := conn.OpenStream(ctx)
+	require.NoError(t, err)
+	defer stream.Close()
+
+	pair.standIn.inject(t, pair.addr, standInFaults{stall: true})
+	_, err = stream.Write([]byte("x"))
+	require.NoError(t, err)
+	buf := make([]byte, 1)
+	require.NoError(t, stream.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+	_, err = stream.Read(buf)
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr) && netErr.Timeout(), "nothing arrives while the stream is stalled: %v", err)
+
+	// the data was held back, not lost
+	pair.standIn.inject(t, pair.addr, standInFaults{})
+	require.NoError(t, stream.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.ReadFull(stream, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "x", string(buf))
+}
+
+func TestKeepaliveClosesStalledConnection(t *testing.T) {
+	pair := newFaultyPair(t, WithKeepalive(KeepaliveConfig{IdleTimeout: 300 * time.Millisecond}))
+	conn := pair.dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := conn.OpenStream(ctx)
+	require.NoError(t, err)
+
+	// an echoed heartbeat keeps the connection alive past the idle timeout
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := stream.Write(buf); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(stream, buf); err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	time.Sleep(600 * time.Millisecond)
+	require.False(t, conn.IsClosed())
+
+	pair.standIn.inject(t, pair.addr, standInFaults{stall: true})
+	require.Eventually(t, conn.IsClosed, 5*time.Second, 20*time.Millisecond, "the keepalive closes the stalled connection")
+}
+
+func TestStreamResetMidStream(t *testing.T) {
+	pair := newFaultyPair(t)
+	conn := pair.dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := conn.OpenStream(ctx)
+	require.NoError(t, err)
+	_, err = stream.Write([]byte("before"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(stream, make([]byte, len("before")))
+	require.NoError(t, err)
+	require.Equal(t, 1, pair.client.Diagnostics().Connections.Open)
+
+	pair.standIn.inject(t, pair.addr, standInFaults{resetAfter: 1})
+	stream.Write([]byte("after"))
+	_, err = io.ReadAll(stream)
+	assert.Error(t, err, "the reset fails the stream")
+	require.Eventually(t, conn.IsClosed, 5*time.Second, 20*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return pair.client.Diagnostics().Connections.Open == 0
+	}, 5*time.Second, 20*time.Millisecond, "the reset connection is no longer tracked")
+
+	// the listener survives the reset of an accepted connection
+	pair.standIn.inject(t, pair.addr, standInFaults{})
+	requireEcho(t, pair.client, pair.addr, pair.serverID)
+}
+
+func TestResetDuringHandshake(t *testing.T) {
+	pair := newFaultyPair(t)
+	pair.standIn.inject(t, pair.addr, standInFaults{resetAfter: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := pair.client.Dial(ctx, pair.addr, pair.serverID)
+	assert.ErrorContains(t, err, "failed to upgrade connection")
+
+	pair.standIn.inject(t, pair.addr, standInFaults{})
+	requireEcho(t, pair.client, pair.addr, pair.serverID)
+}
+
+func TestCloseStalledConnection(t *testing.T) {
+	pair := newFaultyPair(t)
+	conn := pair.dial(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := conn.OpenStream(ctx)
+	require.NoError(t, err)
+
+	pair.standIn.inject(t, pair.addr, standInFaults{stall: true})
+	// fill the stalled stream so a close has data to flush
+	go stream.Write(make([]byte, 1<<20))
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- conn.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("closing a stalled connection blocked")
+	}
+	assert.True(t, conn.IsClosed())
+
+	done := make(chan struct{})
+	go func() {
+		pair.client.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("closing the transport blocked")
+	}
+}
+
+func TestCloseListenerDuringSlowAccept(t *testing.T) {
+	pair := newFaultyPair(t)
+	listener, err := pair.server.Listen(pair.addr)
+	require.NoError(t, err)
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if conn != nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+
+	// hold a dial while the router hands it to the listener
+	pair.standIn.inject(t, pair.addr, standInFaults{acceptDelay: 500 * time.Millisecond})
+	dialed := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := pair.client.Dial(ctx, pair.addr, pair.serverID)
+		if conn != nil {
+			conn.Close()
+		}
+		dialed <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	pair.listener.Close()
+	require.NoError(t, listener.Close())
+	select {
+	case err := <-accepted:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+	select {
+	case err := <-dialed:
+		assert.Error(t, err, "no listener is left to take the stream")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the dial did not return")
+	}
+}
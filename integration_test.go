@@ -631,7 +631,7 @@ func setupNode(t *testing.T, samAddr, name string) (*testNode, string, func()) {
 	require.NoError(t, err, fmt.Sprintf("Failed to connect to SAM at %s", samAddr))
 
 	// Generate I2P keys
-	i2pKeys, err := sam.NewKeys()
+	i2pKeys, err := GenerateKeys(sam, DefaultKeyTypes)
 	require.NoError(t, err, "Failed to generate I2P keys")
 
 	// Generate libp2p identity
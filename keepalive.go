@@ -0,0 +1,183 @@
+package i2p
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeepaliveConfig detects I2P streams that stalled, e.g. because the tunnels
+// they ran over failed, see WithKeepalive
+type KeepaliveConfig struct {
+	// ProbeInterval has the router send a keepalive on streams that were
+	// idle this long. When the peer is gone the probe goes unanswered and
+	// the router resets the stream. Zero leaves idle streams alone.
+	ProbeInterval time.Duration
+
+	// IdleTimeout closes connections that received nothing for this long.
+	// Keep it above the keepalive interval of the muxer, yamux pings idle
+	// connections every 30 seconds by default, and above ProbeInterval.
+	IdleTimeout time.Duration
+
+	// WriteTimeout closes connections a write was blocked on for this long
+	WriteTimeout time.Duration
+}
+
+// DefaultKeepaliveConfig probes streams idle for a minute and closes
+// connections silent for two
+var DefaultKeepaliveConfig = KeepaliveConfig{
+	ProbeInterval: time.Minute,
+	IdleTimeout:   2 * time.Minute,
+	WriteTimeout:  time.Minute,
+}
+
+func (k KeepaliveConfig) validate() error {
+	if k.ProbeInterval < 0 || k.IdleTimeout < 0 || k.WriteTimeout < 0 {
+		return errors.New("keepalive durations must not be negative")
+	}
+	if k.ProbeInterval == 0 && k.IdleTimeout == 0 && k.WriteTimeout == 0 {
+		return errors.New("keepalive needs a probe interval or a timeout")
+	}
+	return nil
+}
+
+// sessionOptions returns the streaming options that make the router probe
+// idle streams
+func (k KeepaliveConfig) sessionOptions() []string {
+	if k.ProbeInterval == 0 {
+		return nil
+	}
+	return []string{
+		"i2p.streaming.inactivityTimeout=" + strconv.FormatInt(k.ProbeInterval.Milliseconds(), 10),
+		// 2 sends a keepalive, 1 would disconnect
+		"i2p.streaming.inactivityAction=2",
+	}
+}
+
+// WithKeepalive probes idle streams and closes connections that stalled.
+// Reads and writes on a closed connection return an *InactivityError, which
+// the muxer above sees as a failed connection instead of waiting forever.
+func WithKeepalive(config KeepaliveConfig) Option {
+	return func(i2p *I2PTransport) error {
+		if err := config.validate(); err != nil {
+			return err
+		}
+		i2p.keepalive = &config
+		return nil
+	}
+}
+
+// InactivityError is returned by reads and writes on a connection the
+// keepalive closed. It is a net.Error reporting a timeout.
+type InactivityError struct {
+	// Op is "read" when nothing was received for the idle timeout, "write"
+	// when a write was blocked for the write timeout
+	Op   string
+	Idle time.Duration
+}
+
+func (e *InactivityError) Error() string {
+	if e.Op == "write" {
+		return fmt.Sprintf("I2P connection closed, a write was blocked for %s", e.Idle.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("I2P connection closed, nothing was received for %s", e.Idle.Round(time.Millisecond))
+}
+
+func (e *InactivityError) Timeout() bool {
+	return true
+}
+
+func (e *InactivityError) Temporary() bool {
+	return false
+}
+
+// keepalive watches a connection for inactivity and closes it when it stalls
+type keepalive struct {
+	config KeepaliveConfig
+	conn   ConnWithoutAddr
+
+	// unix nanoseconds of the last read that returned data
+	lastRead atomic.Int64
+
+	mu        sync.Mutex
+	idleTimer *time.Timer
+	stopped   bool
+	dead      *InactivityError
+}
+
+func newKeepalive(conn ConnWithoutAddr, config KeepaliveConfig) *keepalive {
+	k := &keepalive{config: config, conn: conn}
+	k.lastRead.Store(time.Now().UnixNano())
+	if config.IdleTimeout > 0 {
+		k.mu.Lock()
+		k.idleTimer = time.AfterFunc(config.IdleTimeout, k.checkIdle)
+		k.mu.Unlock()
+	}
+	return k
+}
+
+func (k *keepalive) received() {
+	k.lastRead.Store(time.Now().UnixNano())
+}
+
+// checkIdle runs when the connection may have been idle for IdleTimeout.
+// Reads only record their time, the timer is moved here rather than on
+// every read.
+func (k *keepalive) checkIdle() {
+	idle := time.Since(time.Unix(0, k.lastRead.Load()))
+	if idle < k.config.IdleTimeout {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		if !k.stopped {
+			k.idleTimer.Reset(k.config.IdleTimeout - idle)
+		}
+		return
+	}
+	k.kill(&InactivityError{Op: "read", Idle: idle})
+}
+
+// writing guards a write, the returned function ends the guard
+func (k *keepalive) writing() func() {
+	if k.config.WriteTimeout == 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(k.config.WriteTimeout, func() {
+		k.kill(&InactivityError{Op: "write", Idle: k.config.WriteTimeout})
+	})
+	return func() {
+		timer.Stop()
+	}
+}
+
+func (k *keepalive) kill(err *InactivityError) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.stopped || k.dead != nil {
+		return
+	}
+	k.dead = err
+	k.conn.Close()
+}
+
+// err returns the reason the keepalive closed the connection in place of
+// the error the closed connection reported
+func (k *keepalive) err(err error) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.dead != nil {
+		return k.dead
+	}
+	return err
+}
+
+func (k *keepalive) stop() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.stopped = true
+	if k.idleTimer != nil {
+		k.idleTimer.Stop()
+	}
+}
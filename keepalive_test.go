@@ -0,0 +1,129 @@
+package i2p
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestConnection wraps one end of a pipe in a Connection between two
+// random destinations, and returns it with the other end
+func newTestConnection(t testing.TB) (*Connection, net.Conn) {
+	t.Helper()
+	addr := func() ma.Multiaddr {
+		identity, _, err := crypto.GenerateEd25519Key(nil)
+		require.NoError(t, err)
+		keys, err := DeriveKeys(identity)
+		require.NoError(t, err)
+		multiAddr, err := I2PAddrToMultiAddr(string(keys.Addr()))
+		require.NoError(t, err)
+		return multiAddr
+	}
+
+	local, remote := net.Pipe()
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	conn, err := NewConnection(local, addr(), addr())
+	require.NoError(t, err)
+	return conn, remote
+}
+
+func TestKeepaliveClosesIdleConnection(t *testing.T) {
+	conn, _ := newTestConnection(t)
+	conn.startKeepalive(KeepaliveConfig{IdleTimeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	_, err := conn.Read(make([]byte, 1))
+	var inactive *InactivityError
+	require.True(t, errors.As(err, &inactive), "%v", err)
+	assert.Equal(t, "read", inactive.Op)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+
+	_, err = conn.Write([]byte("late"))
+	assert.True(t, errors.As(err, &inactive), "later calls report the same reason: %v", err)
+}
+
+func TestKeepaliveKeepsActiveConnection(t *testing.T) {
+	conn, remote := newTestConnection(t)
+	conn.startKeepalive(KeepaliveConfig{IdleTimeout: 100 * time.Millisecond})
+
+	go func() {
+		for i := 0; i < 10; i++ {
+			time.Sleep(30 * time.Millisecond)
+			if _, err := remote.Write([]byte{byte(i)}); err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, 1)
+	for i := 0; i < 10; i++ {
+		_, err := conn.Read(buf)
+		require.NoError(t, err, "read %d", i)
+	}
+	require.NoError(t, conn.Close())
+}
+
+func TestKeepaliveClosesStalledWrite(t *testing.T) {
+	conn, _ := newTestConnection(t)
+	conn.startKeepalive(KeepaliveConfig{WriteTimeout: 50 * time.Millisecond})
+
+	// nobody reads the other end of the pipe
+	_, err := conn.Write([]byte("stalled"))
+	var inactive *InactivityError
+	require.True(t, errors.As(err, &inactive), "%v", err)
+	assert.Equal(t, "write", inactive.Op)
+}
+
+func TestKeepaliveConfig(t *testing.T) {
+	require.NoError(t, DefaultKeepaliveConfig.validate())
+	assert.Error(t, KeepaliveConfig{}.validate())
+	assert.Error(t, KeepaliveConfig{IdleTimeout: -time.Second}.validate())
+	assert.Nil(t, KeepaliveConfig{IdleTimeout: time.Second}.sessionOptions())
+	assert.Equal(t, []string{
+		"i2p.streaming.inactivityTimeout=60000",
+		"i2p.streaming.inactivityAction=2",
+	}, DefaultKeepaliveConfig.sessionOptions())
+}
+
+func TestTransportKeepalive(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	server, serverID := newStandInTransport(t, standIn)
+	client, _ := newStandInTransport(t, standIn, WithKeepalive(KeepaliveConfig{
+		ProbeInterval: 100 * time.Millisecond,
+		IdleTimeout:   300 * time.Millisecond,
+	}))
+
+	probing := false
+	for _, line := range standIn.createdSessions() {
+		probing = probing || strings.Contains(line, "i2p.streaming.inactivityAction=2")
+	}
+	assert.True(t, probing, "the router is asked to probe idle streams")
+
+	listener, err := server.Listen(standInListenAddr(t, server))
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveEcho(listener)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, listener.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// yamux only pings every 30 seconds, so the connection looks stalled
+	assert.Eventually(t, conn.IsClosed, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,175 @@
+package i2p
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/eyedeekay/sam3"
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"github.com/joomcode/errorx"
+)
+
+// SignatureType is the signing algorithm of a destination
+type SignatureType uint16
+
+const (
+	// SignatureDSASHA1 is the legacy default of older routers. It is rejected
+	// unless KeyTypes.AllowDSA is set.
+	SignatureDSASHA1         SignatureType = sigTypeDSASHA1
+	SignatureECDSASHA256P256 SignatureType = sigTypeECDSASHA256
+	SignatureECDSASHA384P384 SignatureType = sigTypeECDSASHA384
+	SignatureECDSASHA512P521 SignatureType = sigTypeECDSASHA512
+	SignatureEd25519         SignatureType = sigTypeEd25519
+)
+
+// samSignatureNames are the SIGNATURE_TYPE values SAM understands
+var samSignatureNames = map[SignatureType]string{
+	SignatureDSASHA1:         "DSA_SHA1",
+	SignatureECDSASHA256P256: "ECDSA_SHA256_P256",
+	SignatureECDSASHA384P384: "ECDSA_SHA384_P384",
+	SignatureECDSASHA512P521: "ECDSA_SHA512_P521",
+	SignatureEd25519:         "EdDSA_SHA512_Ed25519",
+}
+
+func (s SignatureType) String() string {
+	if name, ok := samSignatureNames[s]; ok {
+		return name
+	}
+	return "unknown(" + strconv.Itoa(int(s)) + ")"
+}
+
+// samOption returns the SIGNATURE_TYPE argument of DEST GENERATE and
+// SESSION CREATE
+func (s SignatureType) samOption() string {
+	return "SIGNATURE_TYPE=" + s.String()
+}
+
+// EncryptionType is an end-to-end encryption type published in the LeaseSet
+type EncryptionType uint16
+
+const (
+	EncryptionElGamal     EncryptionType = encTypeElGamal
+	EncryptionECIESX25519 EncryptionType = encTypeECIESX25519
+)
+
+func (e EncryptionType) String() string {
+	switch e {
+	case EncryptionElGamal:
+		return "ElGamal"
+	case EncryptionECIESX25519:
+		return "ECIES-X25519"
+	}
+	return "unknown(" + strconv.Itoa(int(e)) + ")"
+}
+
+// KeyTypes selects the cryptography used for our destination, both when
+// generating keys and when creating SAM sessions
+type KeyTypes struct {
+	// Signature is the signature type of generated and transient keys
+	Signature SignatureType
+
+	// Encryption lists the LeaseSet encryption types in order of preference.
+	// Keeping EncryptionElGamal in the list lets routers that predate
+	// ECIES-X25519 reach us.
+	Encryption []EncryptionType
+
+	// AllowDSA permits destinations using SignatureDSASHA1
+	AllowDSA bool
+}
+
+// DefaultKeyTypes uses Ed25519 signatures and prefers ECIES-X25519, with
+// ElGamal kept for compatibility with older routers
+var DefaultKeyTypes = KeyTypes{
+	Signature:  SignatureEd25519,
+	Encryption: []EncryptionType{EncryptionECIESX25519, EncryptionElGamal},
+}
+
+func (k KeyTypes) validate() error {
+	if _, ok := samSignatureNames[k.Signature]; !ok {
+		return fmt.Errorf("unsupported signature type %s", k.Signature)
+	}
+	if k.Signature == SignatureDSASHA1 && !k.AllowDSA {
+		return errors.New("DSA_SHA1 signatures are not allowed, set AllowDSA to use them")
+	}
+	if len(k.Encryption) == 0 {
+		return errors.New("at least one encryption type is required")
+	}
+	seen := make(map[EncryptionType]bool, len(k.Encryption))
+	for _, encryption := range k.Encryption {
+		if encryption != EncryptionElGamal && encryption != EncryptionECIESX25519 {
+			return fmt.Errorf("unsupported encryption type %s", encryption)
+		}
+		if seen[encryption] {
+			return fmt.Errorf("encryption type %s is listed twice", encryption)
+		}
+		seen[encryption] = true
+	}
+	return nil
+}
+
+// checkDestination rejects destinations the key types do not allow
+func (k KeyTypes) checkDestination(addr i2pkeys.I2PAddr) error {
+	dest, err := parseDestination(addr)
+	if err != nil {
+		return err
+	}
+	if dest.sigType == sigTypeDSASHA1 && !k.AllowDSA {
+		return errors.New("destination uses legacy DSA_SHA1 signatures, set AllowDSA to load it")
+	}
+	return nil
+}
+
+// sessionOption returns the I2CP option publishing the encryption types
+func (k KeyTypes) sessionOption() string {
+	types := make([]string, len(k.Encryption))
+	for i, encryption := range k.Encryption {
+		types[i] = strconv.Itoa(int(encryption))
+	}
+	return "i2cp.leaseSetEncType=" + strings.Join(types, ",")
+}
+
+// GenerateKeys asks the router for a new destination of the given signature
+// type. Use it in place of sam.NewKeys, which leaves the signature type to
+// the router's default, DSA_SHA1 on most routers.
+func GenerateKeys(sam *sam3.SAM, types KeyTypes) (i2pkeys.I2PKeys, error) {
+	if err := types.validate(); err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+
+	keys, err := sam.NewKeys(types.Signature.samOption())
+	if err != nil {
+		return i2pkeys.I2PKeys{}, errorx.Decorate(err, "Failed to generate I2P keys")
+	}
+	if err := checkGeneratedKeys(keys, types); err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	return keys, nil
+}
+
+// checkGeneratedKeys verifies the router honored the requested signature
+// type, older routers ignore SIGNATURE_TYPE rather than refusing it
+func checkGeneratedKeys(keys i2pkeys.I2PKeys, types KeyTypes) error {
+	dest, err := parseDestination(keys.Addr())
+	if err != nil {
+		return errorx.Decorate(err, "Router returned an invalid destination")
+	}
+	if SignatureType(dest.sigType) != types.Signature {
+		return fmt.Errorf("router generated a %s destination, %s was requested", SignatureType(dest.sigType), types.Signature)
+	}
+	return nil
+}
+
+// WithKeyTypes sets the key types used for the transport's sessions. The
+// destination passed to I2PTransportBuilder is checked against them. Without
+// this option DefaultKeyTypes is used, which rejects DSA destinations.
+func WithKeyTypes(types KeyTypes) Option {
+	return func(i2p *I2PTransport) error {
+		if err := types.validate(); err != nil {
+			return err
+		}
+		i2p.keyTypes = types
+		return nil
+	}
+}
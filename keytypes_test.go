@@ -0,0 +1,45 @@
+package i2p
+
+import (
+	"testing"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyTypesValidate(t *testing.T) {
+	assert.NoError(t, DefaultKeyTypes.validate())
+	assert.Equal(t, "SIGNATURE_TYPE=EdDSA_SHA512_Ed25519", DefaultKeyTypes.Signature.samOption())
+	assert.Equal(t, "i2cp.leaseSetEncType=4,0", DefaultKeyTypes.sessionOption())
+
+	assert.Error(t, KeyTypes{Signature: SignatureDSASHA1, Encryption: []EncryptionType{EncryptionElGamal}}.validate())
+	assert.NoError(t, KeyTypes{Signature: SignatureDSASHA1, Encryption: []EncryptionType{EncryptionElGamal}, AllowDSA: true}.validate())
+	assert.Error(t, KeyTypes{Signature: SignatureEd25519}.validate(), "no encryption type")
+	assert.Error(t, KeyTypes{Signature: SignatureEd25519, Encryption: []EncryptionType{EncryptionECIESX25519, EncryptionECIESX25519}}.validate())
+	assert.Error(t, KeyTypes{Signature: SignatureType(sigTypeRedDSAEd25519), Encryption: []EncryptionType{EncryptionECIESX25519}}.validate())
+	assert.Error(t, KeyTypes{Signature: SignatureEd25519, Encryption: []EncryptionType{3}}.validate())
+}
+
+func TestKeyTypesRejectDSADestination(t *testing.T) {
+	dsa := i2pkeys.I2PAddr(base64Addr)
+	assert.Error(t, DefaultKeyTypes.checkDestination(dsa))
+
+	allowDSA := DefaultKeyTypes
+	allowDSA.AllowDSA = true
+	assert.NoError(t, allowDSA.checkDestination(dsa))
+
+	ed25519Dest, _ := testEd25519Destination(t)
+	assert.NoError(t, DefaultKeyTypes.checkDestination(ed25519Dest))
+}
+
+func TestKeyTypesSessionOptions(t *testing.T) {
+	i2p := &I2PTransport{}
+	require.NoError(t, WithKeyTypes(KeyTypes{
+		Signature:  SignatureEd25519,
+		Encryption: []EncryptionType{EncryptionECIESX25519},
+	})(i2p))
+	assert.Contains(t, i2p.sessionOptions(), "i2cp.leaseSetEncType=4")
+
+	assert.Error(t, WithKeyTypes(KeyTypes{Signature: SignatureDSASHA1, Encryption: []EncryptionType{EncryptionElGamal}})(i2p))
+}
@@ -3,6 +3,7 @@ package i2p
 import (
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/joomcode/errorx"
 	ma "github.com/multiformats/go-multiaddr"
@@ -14,14 +15,55 @@ import (
 // this struct only exists to satisfy the interface requirements for libp2p connection
 // upgrader
 type TransportListener struct {
-	streamListener *sam3.StreamListener
+	mu             sync.RWMutex
+	streamListener streamAcceptor
 	multiAddr      ma.Multiaddr
+
+	// closed once the transport is done rebuilding its sessions, see suspend
+	rebinding chan struct{}
+
+	// called once the listener is closed, lets the transport stop tracking it
+	onClose func()
+	// called when accepting fails, lets the transport move the listener to
+	// another router before the error is reported
+	onAcceptError func(error)
+	closed        bool
+
+	// called with each accepted connection, lets the transport set it up
+	onConnection func(*Connection)
+}
+
+// streamAcceptor is the part of a SAM stream listener the transport listener
+// uses
+type streamAcceptor interface {
+	accept() (net.Conn, error)
+	Close() error
+	Addr() net.Addr
+}
+
+// sam3Acceptor adapts listeners of sessions created with sam3
+type sam3Acceptor struct {
+	*sam3.StreamListener
+}
+
+func (a sam3Acceptor) accept() (net.Conn, error) {
+	return a.StreamListener.Accept()
 }
 
 func NewTransportListener(streamListener *sam3.StreamListener) (*TransportListener, error) {
-	multiAddr, err := I2PAddrToMultiAddr(streamListener.Addr().String())
-	if err != nil {
-		return nil, errorx.Decorate(err, "Failed to create MultiAddr from i2p")
+	return newTransportListener(sam3Acceptor{streamListener}, nil)
+}
+
+// newTransportListener creates a listener that reports multiAddr as its
+// address, or the session's own destination when multiAddr is nil. The two
+// differ when the destination is only reachable through a blinded address.
+func newTransportListener(streamListener streamAcceptor, multiAddr ma.Multiaddr) (*TransportListener, error) {
+	if multiAddr == nil {
+		var err error
+		multiAddr, err = I2PAddrToMultiAddr(streamListener.Addr().String())
+		if err != nil {
+			return nil, errorx.Decorate(err, "Failed to create MultiAddr from i2p")
+		}
 	}
 
 	return &TransportListener{
@@ -30,10 +72,68 @@ func NewTransportListener(streamListener *sam3.StreamListener) (*TransportListen
 	}, nil
 }
 
+// suspend is called before the transport closes the session the listener
+// is bound to, so that a failing Accept waits for rebind instead of
+// reporting the error
+func (t *TransportListener) suspend() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rebinding == nil {
+		t.rebinding = make(chan struct{})
+	}
+}
+
+// rebind moves the listener onto a new stream session after the transport
+// rebuilt its SAM sessions. Passing a nil streamListener keeps the old one,
+// which releases waiting Accept calls with their original error.
+func (t *TransportListener) rebind(streamListener streamAcceptor, multiAddr ma.Multiaddr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if streamListener != nil {
+		t.streamListener = streamListener
+		t.multiAddr = multiAddr
+	}
+	if t.rebinding != nil {
+		close(t.rebinding)
+		t.rebinding = nil
+	}
+}
+
+func (t *TransportListener) current() (streamAcceptor, ma.Multiaddr) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.streamListener, t.multiAddr
+}
+
 func (t *TransportListener) Accept() (manet.Conn, error) {
-	conn, err := t.streamListener.Accept()
-	if err != nil {
-		return nil, errorx.Decorate(err, "Failed to accept connection")
+	streamListener, localAddress := t.current()
+	conn, err := streamListener.accept()
+	for err != nil {
+		t.mu.RLock()
+		closed := t.closed
+		t.mu.RUnlock()
+		if closed {
+			// the upgrader reports this as transport.ErrListenerClosed
+			return nil, errorx.Decorate(net.ErrClosed, "Failed to accept connection")
+		}
+		if t.onAcceptError != nil {
+			t.onAcceptError(err)
+		}
+
+		// the listener may be rebound to a new session while we were waiting
+		t.mu.RLock()
+		rebinding := t.rebinding
+		t.mu.RUnlock()
+		if rebinding != nil {
+			<-rebinding
+		}
+
+		rebound, reboundAddress := t.current()
+		if rebound == streamListener {
+			return nil, errorx.Decorate(err, "Failed to accept connection")
+		}
+		streamListener, localAddress = rebound, reboundAddress
+		conn, err = streamListener.accept()
 	}
 
 	// Verify connection is not nil
@@ -41,12 +141,6 @@ func (t *TransportListener) Accept() (manet.Conn, error) {
 		return nil, fmt.Errorf("accepted connection is nil")
 	}
 
-	localAddress, err := I2PAddrToMultiAddr(t.streamListener.Addr().String())
-	if err != nil {
-		conn.Close()
-		return nil, errorx.Decorate(err, "Unable to construct multi-addr from local address")
-	}
-
 	remoteAddress, err := I2PAddrToMultiAddr(conn.RemoteAddr().String())
 	if err != nil {
 		conn.Close()
@@ -58,18 +152,34 @@ func (t *TransportListener) Accept() (manet.Conn, error) {
 		conn.Close()
 		return nil, errorx.Decorate(err, "Failed to construct Connection type")
 	}
+	if t.onConnection != nil {
+		t.onConnection(inboundConnection)
+	}
 
 	return inboundConnection, nil
 }
 
 func (t *TransportListener) Close() error {
-	return t.streamListener.Close()
+	if t.onClose != nil {
+		t.onClose()
+	}
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	streamListener, _ := t.current()
+	err := streamListener.Close()
+	// an Accept waiting for the transport to move to another router
+	// returns now
+	t.rebind(nil, nil)
+	return err
 }
 
 func (t *TransportListener) Addr() net.Addr {
-	return t.streamListener.Addr()
+	streamListener, _ := t.current()
+	return streamListener.Addr()
 }
 
 func (t *TransportListener) Multiaddr() ma.Multiaddr {
-	return t.multiAddr
+	_, multiAddr := t.current()
+	return multiAddr
 }
@@ -0,0 +1,133 @@
+package i2p
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"github.com/libp2p/go-libp2p/core/peer"
+	logging "github.com/libp2p/go-libp2p/gologshim"
+)
+
+// log is the transport's subsystem, set its level with GOLOG_LOG_LEVEL, e.g.
+// "i2p=debug", or with go-log's SetLogLevel once go-log is connected to
+// go-libp2p's logging
+var log = logging.Logger("i2p")
+
+// LogRedaction controls how I2P destinations and peer IDs appear in logs
+type LogRedaction int
+
+const (
+	// RedactHash logs a hash of destinations and peer IDs, which tells them
+	// apart without revealing them. It is the default.
+	RedactHash LogRedaction = iota
+	// RedactTruncate logs the first characters of a destination's b32
+	// address and the last characters of a peer ID
+	RedactTruncate
+	// RedactNone logs destinations and peer IDs in full
+	RedactNone
+)
+
+// WithLogRedaction sets how destinations and peer IDs are logged, including
+// those within logged errors
+func WithLogRedaction(mode LogRedaction) Option {
+	return func(i2p *I2PTransport) error {
+		if mode < RedactHash || mode > RedactNone {
+			return errors.New("unknown log redaction mode")
+		}
+		i2p.logRedaction = mode
+		return nil
+	}
+}
+
+// destinationPattern finds b32 and b33 addresses and full base64
+// destinations, which are at least 387 bytes long, as well as the
+// /garlic32 and /garlic64 components of multiaddrs, whatever their length
+var destinationPattern = regexp.MustCompile(`/garlic(32)/([a-z2-7]+)|/garlic(64)/([A-Za-z0-9~-]+={0,2})|[a-z2-7]{52,}\.b32\.i2p|[A-Za-z0-9~-]{516,}={0,2}`)
+
+// redactDestination returns how dest is logged
+func (m LogRedaction) redactDestination(dest string) string {
+	switch m {
+	case RedactNone:
+		return dest
+	case RedactTruncate:
+		dest = b32Name(dest)
+		if len(dest) > 8 {
+			return dest[:8] + "…"
+		}
+		return dest
+	default:
+		return "#" + hashDestination(dest)
+	}
+}
+
+func (m LogRedaction) redactPeer(id peer.ID) string {
+	switch m {
+	case RedactNone:
+		return id.String()
+	case RedactTruncate:
+		s := id.String()
+		if len(s) > 6 {
+			return "…" + s[len(s)-6:]
+		}
+		return s
+	default:
+		sum := sha256.Sum256([]byte(id))
+		return "#" + hex.EncodeToString(sum[:8])
+	}
+}
+
+// redactText redacts the destinations within s, e.g. an error message
+func (m LogRedaction) redactText(s string) string {
+	if m == RedactNone {
+		return s
+	}
+	return destinationPattern.ReplaceAllStringFunc(s, func(match string) string {
+		groups := destinationPattern.FindStringSubmatch(match)
+		switch {
+		case groups[1] != "":
+			// hashed like the name MultiAddrToI2PAddr returns
+			return "/garlic32/" + m.redactDestination(groups[2]+b32Suffix)
+		case groups[3] != "":
+			return "/garlic64/" + m.redactDestination(groups[4])
+		}
+		return m.redactDestination(match)
+	})
+}
+
+// b32Name returns the b32 address of dest, a full base64 destination, or
+// dest itself when it is a name or not a destination at all
+func b32Name(dest string) string {
+	if strings.HasSuffix(dest, ".i2p") {
+		return dest
+	}
+	raw, err := i2pkeys.I2PAddr(dest).ToBytes()
+	if err != nil || len(raw) == 0 {
+		return dest
+	}
+	return i2pkeys.I2PAddr(dest).Base32()
+}
+
+// hashDestination hashes the b32 address of dest, so a destination hashes
+// the same whether it was given in full or as its b32 address
+func hashDestination(dest string) string {
+	sum := sha256.Sum256([]byte(b32Name(dest)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// logDest is the structured field for a destination
+func (i2p *I2PTransport) logDest(dest string) slog.Attr {
+	return slog.String("destination", i2p.logRedaction.redactDestination(dest))
+}
+
+func (i2p *I2PTransport) logPeer(id peer.ID) slog.Attr {
+	return slog.String("peer", i2p.logRedaction.redactPeer(id))
+}
+
+func (i2p *I2PTransport) logErr(err error) slog.Attr {
+	return slog.String("err", i2p.logRedaction.redactText(err.Error()))
+}
@@ -0,0 +1,74 @@
+package i2p
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactDestination(t *testing.T) {
+	keys, err := newStandInKeys()
+	require.NoError(t, err)
+	full := string(keys.Addr())
+	b32 := keys.Addr().Base32()
+
+	assert.Equal(t, full, RedactNone.redactDestination(full))
+	assert.Equal(t, b32[:8]+"…", RedactTruncate.redactDestination(full))
+	assert.Equal(t, b32[:8]+"…", RedactTruncate.redactDestination(b32))
+
+	hashed := RedactHash.redactDestination(full)
+	assert.Equal(t, hashed, RedactHash.redactDestination(b32), "both forms of a destination hash the same")
+	assert.True(t, strings.HasPrefix(hashed, "#"))
+	assert.NotContains(t, hashed, b32[:8])
+}
+
+func TestRedactText(t *testing.T) {
+	keys, err := newStandInKeys()
+	require.NoError(t, err)
+	full := string(keys.Addr())
+	b32 := keys.Addr().Base32()
+	message := (&DialError{Addr: full, Attempts: 2, Err: errors.New("CANT_REACH_PEER " + b32)}).Error()
+
+	for _, mode := range []LogRedaction{RedactHash, RedactTruncate} {
+		redacted := mode.redactText(message)
+		assert.NotContains(t, redacted, full)
+		assert.NotContains(t, redacted, b32)
+		assert.Contains(t, redacted, "after 2 attempts", "the rest of the message is kept")
+	}
+	assert.Equal(t, message, RedactNone.redactText(message))
+
+	// multiaddrs carry b32 names without the suffix
+	name := strings.TrimSuffix(b32, b32Suffix)
+	message = `can't dial "/garlic32/` + name + `" or "/garlic64/` + full + `"`
+	redacted := RedactHash.redactText(message)
+	assert.NotContains(t, redacted, name)
+	assert.NotContains(t, redacted, full)
+	assert.Contains(t, redacted, "/garlic32/"+RedactHash.redactDestination(b32), "hashed like the b32 address")
+	assert.Contains(t, redacted, "/garlic64/"+RedactHash.redactDestination(full))
+}
+
+func TestRedactPeer(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+
+	assert.Equal(t, id.String(), RedactNone.redactPeer(id))
+	assert.Equal(t, "…"+id.String()[len(id.String())-6:], RedactTruncate.redactPeer(id))
+	hashed := RedactHash.redactPeer(id)
+	assert.True(t, strings.HasPrefix(hashed, "#"))
+	assert.NotContains(t, id.String(), hashed[1:])
+}
+
+func TestWithLogRedaction(t *testing.T) {
+	i2p := &I2PTransport{}
+	assert.Equal(t, RedactHash, i2p.logRedaction, "destinations are hashed by default")
+	require.NoError(t, WithLogRedaction(RedactNone)(i2p))
+	assert.Equal(t, RedactNone, i2p.logRedaction)
+	assert.Error(t, WithLogRedaction(LogRedaction(7))(i2p))
+}
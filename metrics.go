@@ -0,0 +1,103 @@
+package i2p
+
+import (
+	"strconv"
+
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricNamespace = "libp2p_i2p"
+
+var (
+	dialAttempts = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "dial_attempts",
+			Help:      "Stream connect attempts per dial, including retries",
+			Buckets:   []float64{1, 2, 3, 4, 5, 6, 8, 10},
+		},
+		[]string{"outcome"},
+	)
+	dialsCoalesced = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "dials_coalesced_total",
+			Help:      "Dials that waited for a concurrent dial to the same destination",
+		},
+	)
+	collectors = []prometheus.Collector{
+		dialAttempts,
+		dialsCoalesced,
+	}
+)
+
+// MetricsTracer records metrics of the transport
+type MetricsTracer interface {
+	// DialAttempts is called once per dial that reached the router, with the
+	// number of stream connect attempts it took
+	DialAttempts(attempts int, err error)
+
+	// DialCoalesced is called for each dial that waited for a concurrent
+	// dial to the same destination
+	DialCoalesced()
+}
+
+type metricsTracer struct{}
+
+var _ MetricsTracer = &metricsTracer{}
+
+type metricsTracerSetting struct {
+	reg prometheus.Registerer
+}
+
+type MetricsTracerOption func(*metricsTracerSetting)
+
+// WithRegisterer registers the metrics with reg instead of the default
+// prometheus registerer
+func WithRegisterer(reg prometheus.Registerer) MetricsTracerOption {
+	return func(s *metricsTracerSetting) {
+		if reg != nil {
+			s.reg = reg
+		}
+	}
+}
+
+// NewMetricsTracer returns a MetricsTracer exporting prometheus metrics
+func NewMetricsTracer(opts ...MetricsTracerOption) MetricsTracer {
+	setting := &metricsTracerSetting{reg: prometheus.DefaultRegisterer}
+	for _, opt := range opts {
+		opt(setting)
+	}
+	metricshelper.RegisterCollectors(setting.reg, collectors...)
+	return &metricsTracer{}
+}
+
+func (m *metricsTracer) DialAttempts(attempts int, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	dialAttempts.WithLabelValues(outcome).Observe(float64(attempts))
+}
+
+func (m *metricsTracer) DialCoalesced() {
+	dialsCoalesced.Inc()
+}
+
+// WithMetricsTracer records the transport's metrics with tracer, see
+// NewMetricsTracer
+func WithMetricsTracer(tracer MetricsTracer) Option {
+	return func(i2p *I2PTransport) error {
+		i2p.metricsTracer = tracer
+		return nil
+	}
+}
+
+// attemptsLabel is the attempt count as used in log and error messages
+func attemptsLabel(attempts int) string {
+	if attempts == 1 {
+		return "1 attempt"
+	}
+	return strconv.Itoa(attempts) + " attempts"
+}
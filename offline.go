@@ -0,0 +1,271 @@
+package i2p
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+const (
+	// how long before expiry of an offline signature warnings start, and how
+	// often they are repeated from then on
+	defaultOfflineExpiryWarning = 7 * 24 * time.Hour
+	offlineExpiryWarnInterval   = time.Hour
+
+	// expires(4) and transient sig type(2) precede the transient public key
+	offlineSignatureHeaderLength = 6
+)
+
+// OfflineSignature authorizes a transient signing key to sign for a
+// destination whose own signing key is kept offline. The router signs the
+// LeaseSet with the transient key and publishes this structure alongside it,
+// so the destination stays unchanged while the transient key is rotated.
+// See the OfflineSignature structure in https://geti2p.net/spec/common-structures
+type OfflineSignature struct {
+	Expires            time.Time
+	TransientSigType   uint16
+	TransientPublicKey []byte
+	Signature          []byte
+}
+
+// signedData returns the bytes covered by Signature
+func (o *OfflineSignature) signedData() []byte {
+	data := binary.BigEndian.AppendUint32(nil, uint32(o.Expires.Unix()))
+	data = binary.BigEndian.AppendUint16(data, o.TransientSigType)
+	return append(data, o.TransientPublicKey...)
+}
+
+// NewOfflineKeys signs a fresh transient Ed25519 key with the signing key of
+// cold, valid until expires, and returns keys for the same destination that
+// carry the transient key in place of the offline one. It is meant to be run
+// on the host holding the cold key; only the result needs to be copied to the
+// node, which passes it to I2PTransportBuilder like any other keys. SAM
+// detects the offline signature from the all-zero signing private key.
+func NewOfflineKeys(cold i2pkeys.I2PKeys, expires time.Time) (i2pkeys.I2PKeys, error) {
+	if !expires.After(time.Now()) {
+		return i2pkeys.I2PKeys{}, errors.New("offline signature expiry must be in the future")
+	}
+	if expires.Unix() > 1<<32-1 {
+		return i2pkeys.I2PKeys{}, errors.New("offline signature expiry does not fit the 32 bit timestamp")
+	}
+
+	keys, err := parsePrivateKeys(cold)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	if keys.offline != nil {
+		return i2pkeys.I2PKeys{}, errors.New("keys already use an offline signature, the cold key is required")
+	}
+	if keys.dest.sigType != sigTypeEd25519 {
+		return i2pkeys.I2PKeys{}, fmt.Errorf("offline signing needs an Ed25519 destination, got signature type %d", keys.dest.sigType)
+	}
+
+	transientPublic, transientPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	offline := &OfflineSignature{
+		Expires:            time.Unix(expires.Unix(), 0),
+		TransientSigType:   sigTypeEd25519,
+		TransientPublicKey: transientPublic,
+	}
+	coldKey := ed25519.NewKeyFromSeed(keys.signingPrivateKey)
+	offline.Signature = ed25519.Sign(coldKey, offline.signedData())
+
+	raw := append([]byte{}, keys.dest.raw...)
+	raw = append(raw, keys.encryptionPrivateKey...)
+	raw = append(raw, make([]byte, len(keys.signingPrivateKey))...)
+	raw = append(raw, offline.signedData()...)
+	raw = append(raw, offline.Signature...)
+	raw = append(raw, transientPrivate.Seed()...)
+
+	return i2pkeys.NewKeys(cold.Addr(), i2pB64Encoding.EncodeToString(raw)), nil
+}
+
+// ParseOfflineSignature returns the offline signature carried by keys, or nil
+// when the keys hold the destination's own signing key
+func ParseOfflineSignature(keys i2pkeys.I2PKeys) (*OfflineSignature, error) {
+	parsed, err := parsePrivateKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.offline, nil
+}
+
+// privateKeys is the decoded form of the private key blob SAM hands out and
+// accepts in SESSION CREATE: destination, encryption private key, signing
+// private key and, when the latter is all zeroes, the offline signature section
+type privateKeys struct {
+	dest                 *destination
+	encryptionPrivateKey []byte
+	signingPrivateKey    []byte
+	offline              *OfflineSignature
+}
+
+func parsePrivateKeys(keys i2pkeys.I2PKeys) (*privateKeys, error) {
+	raw, err := i2pB64Encoding.DecodeString(keys.String())
+	if err != nil {
+		return nil, fmt.Errorf("private keys are not valid I2P base64: %w", err)
+	}
+	dest, err := parseDestinationBytes(raw)
+	if err != nil {
+		return nil, err
+	}
+	rest := raw[len(dest.raw):]
+
+	encryptionLength, ok := encTypePrivateKeyLength[dest.encType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported destination encryption type %d", dest.encType)
+	}
+	signingLength, ok := sigTypePrivateKeyLength[dest.sigType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported destination signature type %d", dest.sigType)
+	}
+	if len(rest) < encryptionLength+signingLength {
+		return nil, fmt.Errorf("private keys are %d bytes past the destination, expected at least %d", len(rest), encryptionLength+signingLength)
+	}
+	parsed := &privateKeys{
+		dest:                 dest,
+		encryptionPrivateKey: rest[:encryptionLength],
+		signingPrivateKey:    rest[encryptionLength : encryptionLength+signingLength],
+	}
+	rest = rest[encryptionLength+signingLength:]
+
+	if !bytes.Equal(parsed.signingPrivateKey, make([]byte, signingLength)) {
+		return parsed, nil
+	}
+
+	if len(rest) < offlineSignatureHeaderLength {
+		return nil, errors.New("signing private key is empty but the offline signature is missing")
+	}
+	offline := &OfflineSignature{
+		Expires:          time.Unix(int64(binary.BigEndian.Uint32(rest)), 0),
+		TransientSigType: binary.BigEndian.Uint16(rest[4:]),
+	}
+	rest = rest[offlineSignatureHeaderLength:]
+
+	transientLength, ok := sigTypePublicKeyLength[offline.TransientSigType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported transient signature type %d", offline.TransientSigType)
+	}
+	signatureLength := sigTypeSignatureLength[dest.sigType]
+	if len(rest) < transientLength+signatureLength {
+		return nil, errors.New("offline signature is truncated")
+	}
+	offline.TransientPublicKey = rest[:transientLength]
+	offline.Signature = rest[transientLength : transientLength+signatureLength]
+
+	if err := verifyOfflineSignature(dest, offline); err != nil {
+		return nil, err
+	}
+
+	parsed.offline = offline
+	return parsed, nil
+}
+
+// ecdsaSigTypes are the curves and digests of the ECDSA signature types
+var ecdsaSigTypes = map[uint16]struct {
+	curve  elliptic.Curve
+	digest func([]byte) []byte
+}{
+	sigTypeECDSASHA256: {elliptic.P256(), func(data []byte) []byte { sum := sha256.Sum256(data); return sum[:] }},
+	sigTypeECDSASHA384: {elliptic.P384(), func(data []byte) []byte { sum := sha512.Sum384(data); return sum[:] }},
+	sigTypeECDSASHA512: {elliptic.P521(), func(data []byte) []byte { sum := sha512.Sum512(data); return sum[:] }},
+}
+
+// verifyOfflineSignature checks offline was signed by the destination's
+// signing key. Signature types it cannot check are rejected rather than
+// accepted unchecked.
+func verifyOfflineSignature(dest *destination, offline *OfflineSignature) error {
+	var ok bool
+	switch dest.sigType {
+	case sigTypeEd25519:
+		ok = ed25519.Verify(dest.signingPublicKey, offline.signedData(), offline.Signature)
+	case sigTypeECDSASHA256, sigTypeECDSASHA384, sigTypeECDSASHA512:
+		ecdsaType := ecdsaSigTypes[dest.sigType]
+		// keys are X then Y and signatures R then S, each padded to half the length
+		keyHalf, sigHalf := len(dest.signingPublicKey)/2, len(offline.Signature)/2
+		publicKey := &ecdsa.PublicKey{
+			Curve: ecdsaType.curve,
+			X:     new(big.Int).SetBytes(dest.signingPublicKey[:keyHalf]),
+			Y:     new(big.Int).SetBytes(dest.signingPublicKey[keyHalf:]),
+		}
+		r := new(big.Int).SetBytes(offline.Signature[:sigHalf])
+		s := new(big.Int).SetBytes(offline.Signature[sigHalf:])
+		ok = ecdsa.Verify(publicKey, ecdsaType.digest(offline.signedData()), r, s)
+	default:
+		return fmt.Errorf("offline signatures of destinations with signature type %d cannot be verified", dest.sigType)
+	}
+	if !ok {
+		return errors.New("offline signature does not verify against the destination signing key")
+	}
+	return nil
+}
+
+// WithOfflineExpiryWarning sets how long before the offline signature of the
+// transport's keys expires warn starts being called, then once an hour until
+// the transport is closed. The transport keeps its keys, new transient keys
+// take effect only in a transport built with them. The default logs a Warning
+// a week ahead through the transport's logger. It has no effect on keys
+// without an offline signature.
+func WithOfflineExpiryWarning(before time.Duration, warn func(expires time.Time)) Option {
+	return func(i2p *I2PTransport) error {
+		if before <= 0 {
+			return errors.New("offline expiry warning must be a positive duration")
+		}
+		i2p.offlineExpiryWarning = before
+		if warn != nil {
+			i2p.offlineExpiryWarn = warn
+		}
+		return nil
+	}
+}
+
+func warnOfflineExpiry(expires time.Time) {
+	if time.Until(expires) <= 0 {
+		log.Error("offline signature of the destination expired, the router can no longer publish our LeaseSet", "expires", expires.UTC().Format(time.RFC3339))
+		return
+	}
+	log.Warn("offline signature of the destination expires soon, generate new transient keys from the cold key", "expires", expires.UTC().Format(time.RFC3339))
+}
+
+// OfflineSignatureExpiry returns when the offline signature of the
+// transport's keys expires, and false when they carry none
+func (i2p *I2PTransport) OfflineSignatureExpiry() (time.Time, bool) {
+	if i2p.offlineSignature == nil {
+		return time.Time{}, false
+	}
+	return i2p.offlineSignature.Expires, true
+}
+
+// watchOfflineExpiry calls the expiry warning once the offline signature is
+// within the warning period, then repeatedly until the transport is closed
+func (i2p *I2PTransport) watchOfflineExpiry(expires time.Time) {
+	for {
+		wait := offlineExpiryWarnInterval
+		if remaining := time.Until(expires); remaining > i2p.offlineExpiryWarning {
+			wait = remaining - i2p.offlineExpiryWarning
+		} else {
+			i2p.offlineExpiryWarn(expires)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-i2p.closed:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
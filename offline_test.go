@@ -0,0 +1,163 @@
+package i2p
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testEd25519Keys returns private keys for the destination built by
+// testEd25519Destination, with a zeroed ElGamal private key
+func testEd25519Keys(t *testing.T) i2pkeys.I2PKeys {
+	t.Helper()
+	dest, _ := testEd25519Destination(t)
+	seed := sha256.Sum256([]byte(t.Name()))
+
+	raw, err := i2pB64Encoding.DecodeString(string(dest))
+	require.NoError(t, err)
+	raw = append(raw, make([]byte, encTypePrivateKeyLength[encTypeElGamal])...)
+	raw = append(raw, seed[:]...)
+
+	return i2pkeys.NewKeys(dest, i2pB64Encoding.EncodeToString(raw))
+}
+
+func TestNewOfflineKeys(t *testing.T) {
+	cold := testEd25519Keys(t)
+	offline, err := ParseOfflineSignature(cold)
+	require.NoError(t, err)
+	assert.Nil(t, offline)
+
+	expires := time.Now().Add(30 * 24 * time.Hour)
+	hot, err := NewOfflineKeys(cold, expires)
+	require.NoError(t, err)
+	assert.Equal(t, cold.Addr(), hot.Addr())
+
+	offline, err = ParseOfflineSignature(hot)
+	require.NoError(t, err)
+	require.NotNil(t, offline)
+	assert.Equal(t, expires.Unix(), offline.Expires.Unix())
+	assert.Equal(t, uint16(sigTypeEd25519), offline.TransientSigType)
+	assert.Len(t, offline.TransientPublicKey, ed25519.PublicKeySize)
+
+	// the hot keys must not contain the cold signing key
+	coldRaw, err := parsePrivateKeys(cold)
+	require.NoError(t, err)
+	hotRaw, err := i2pB64Encoding.DecodeString(hot.String())
+	require.NoError(t, err)
+	assert.NotContains(t, string(hotRaw), string(coldRaw.signingPrivateKey))
+
+	_, err = NewOfflineKeys(hot, expires)
+	assert.Error(t, err, "transient keys cannot sign another offline signature")
+	_, err = NewOfflineKeys(cold, time.Now().Add(-time.Hour))
+	assert.Error(t, err)
+}
+
+func TestOfflineSignatureRejectsTampering(t *testing.T) {
+	hot, err := NewOfflineKeys(testEd25519Keys(t), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	raw, err := i2pB64Encoding.DecodeString(hot.String())
+	require.NoError(t, err)
+	// push the expiry out without the cold key
+	expiresOffset := len(raw) - 32 - 64 - ed25519.PublicKeySize - offlineSignatureHeaderLength
+	raw[expiresOffset]++
+
+	_, err = ParseOfflineSignature(i2pkeys.NewKeys(hot.Addr(), i2pB64Encoding.EncodeToString(raw)))
+	assert.Error(t, err)
+}
+
+// offlineKeysFor returns private keys for dest with an offline signature
+// section made by sign, for signature types NewOfflineKeys cannot sign with
+func offlineKeysFor(t *testing.T, dest []byte, sigType uint16, sign func(data []byte) []byte) i2pkeys.I2PKeys {
+	t.Helper()
+	transient, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	offline := &OfflineSignature{
+		Expires:            time.Unix(time.Now().Add(time.Hour).Unix(), 0),
+		TransientSigType:   sigTypeEd25519,
+		TransientPublicKey: transient,
+	}
+
+	raw := append([]byte{}, dest...)
+	raw = append(raw, make([]byte, encTypePrivateKeyLength[encTypeElGamal])...)
+	raw = append(raw, make([]byte, sigTypePrivateKeyLength[sigType])...)
+	raw = append(raw, offline.signedData()...)
+	raw = append(raw, sign(offline.signedData())...)
+	raw = append(raw, make([]byte, ed25519.SeedSize)...)
+	return i2pkeys.NewKeys(i2pkeys.I2PAddr(i2pB64Encoding.EncodeToString(dest)), i2pB64Encoding.EncodeToString(raw))
+}
+
+func TestOfflineSignatureECDSA(t *testing.T) {
+	cold, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	public := append(cold.X.FillBytes(make([]byte, 32)), cold.Y.FillBytes(make([]byte, 32))...)
+	dest := make([]byte, destKeysLength, destMinLength+keyCertPayloadMinSize)
+	copy(dest[destKeysLength-len(public):], public)
+	dest = append(dest, certTypeKey, 0, keyCertPayloadMinSize)
+	dest = binary.BigEndian.AppendUint16(dest, sigTypeECDSASHA256)
+	dest = binary.BigEndian.AppendUint16(dest, encTypeElGamal)
+
+	sign := func(data []byte) []byte {
+		digest := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, cold, digest[:])
+		require.NoError(t, err)
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	offline, err := ParseOfflineSignature(offlineKeysFor(t, dest, sigTypeECDSASHA256, sign))
+	require.NoError(t, err)
+	require.NotNil(t, offline)
+
+	forged := func(data []byte) []byte {
+		return sign(append(data, 0))
+	}
+	_, err = ParseOfflineSignature(offlineKeysFor(t, dest, sigTypeECDSASHA256, forged))
+	assert.ErrorContains(t, err, "does not verify")
+}
+
+func TestOfflineSignatureRejectsUnverifiableTypes(t *testing.T) {
+	dest, err := i2pB64Encoding.DecodeString(string(base64Addr))
+	require.NoError(t, err)
+	unsigned := func([]byte) []byte {
+		return make([]byte, sigTypeSignatureLength[sigTypeDSASHA1])
+	}
+	_, err = ParseOfflineSignature(offlineKeysFor(t, dest, sigTypeDSASHA1, unsigned))
+	assert.ErrorContains(t, err, "cannot be verified")
+}
+
+func TestWatchOfflineExpiry(t *testing.T) {
+	warned := make(chan time.Time, 1)
+	i2p := &I2PTransport{closed: make(chan struct{})}
+	require.NoError(t, WithOfflineExpiryWarning(time.Hour, func(expires time.Time) {
+		warned <- expires
+	})(i2p))
+
+	expires := time.Now().Add(30 * time.Minute)
+	done := make(chan struct{})
+	go func() {
+		i2p.watchOfflineExpiry(expires)
+		close(done)
+	}()
+
+	select {
+	case got := <-warned:
+		assert.Equal(t, expires, got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a warning for a signature inside the warning period")
+	}
+
+	close(i2p.closed)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher did not stop when the transport closed")
+	}
+}
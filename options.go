@@ -0,0 +1,50 @@
+package i2p
+
+import (
+	"github.com/eyedeekay/sam3"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// WithEncryptedLeaseSet publishes the destination as an encrypted LeaseSet2.
+// Peers can then only reach us through the blinded b33 address, which the
+// builder returns in place of the regular b32 address. When secret is not
+// empty, it is also required to look up the LeaseSet.
+func WithEncryptedLeaseSet(secret string) Option {
+	return func(i2p *I2PTransport) error {
+		i2p.encryptedLeaseSet = true
+		i2p.leaseSetSecret = secret
+		return nil
+	}
+}
+
+// WithLookupPassword registers the password needed to dial a blinded
+// destination, see I2PTransport.SetLookupPassword.
+func WithLookupPassword(addr ma.Multiaddr, password string) Option {
+	return func(i2p *I2PTransport) error {
+		return i2p.SetLookupPassword(addr, password)
+	}
+}
+
+// sessionOptions returns the I2CP options used when creating the primary session
+func (i2p *I2PTransport) sessionOptions() []string {
+	i2p.mu.RLock()
+	defer i2p.mu.RUnlock()
+
+	options := append([]string{}, sam3.Options_Default...)
+	if len(i2p.keyTypes.Encryption) > 0 {
+		options = append(options, i2p.keyTypes.sessionOption())
+	}
+	if i2p.keepalive != nil {
+		options = append(options, i2p.keepalive.sessionOptions()...)
+	}
+
+	if i2p.encryptedLeaseSet {
+		options = append(options, "i2cp.leaseSetType=5")
+		if i2p.leaseSetSecret != "" {
+			options = append(options, "i2cp.leaseSetSecret="+i2pB64Encoding.EncodeToString([]byte(i2p.leaseSetSecret)))
+		}
+		options = append(options, i2p.clientAuthOptions()...)
+	}
+
+	return options
+}
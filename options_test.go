@@ -0,0 +1,18 @@
+package i2p
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedLeaseSetSessionOptions(t *testing.T) {
+	i2p := &I2PTransport{}
+	assert.NotContains(t, i2p.sessionOptions(), "i2cp.leaseSetType=5")
+
+	require.NoError(t, WithEncryptedLeaseSet("hunter2")(i2p))
+	options := i2p.sessionOptions()
+	assert.Contains(t, options, "i2cp.leaseSetType=5")
+	assert.Contains(t, options, "i2cp.leaseSetSecret="+i2pB64Encoding.EncodeToString([]byte("hunter2")))
+}
@@ -0,0 +1,141 @@
+package i2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// defaultPrefetchInterval keeps LeaseSets warm, they are valid for about
+// ten minutes
+const defaultPrefetchInterval = 5 * time.Minute
+
+// Prefetch has the router look up the LeaseSets of addrs ahead of dialing
+// them, so the first Dial does not wait for a network lookup. Addresses the
+// transport cannot dial are skipped. The lookups run one after another on
+// the primary session, so the router keeps the results for our destination.
+// The returned error lists the addresses whose lookup failed.
+func (i2p *I2PTransport) Prefetch(ctx context.Context, addrs ...ma.Multiaddr) error {
+	seen := make(map[string]bool, len(addrs))
+	var errs []error
+	for _, addr := range addrs {
+		if !i2p.CanDial(addr) {
+			continue
+		}
+		name, err := lookupName(addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		i2p.mu.RLock()
+		primary := i2p.primarySession
+		i2p.mu.RUnlock()
+		_, err = primary.lookup(ctx, name)
+		i2p.resolver.prefetch.record(err != nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to prefetch LeaseSet of %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// lookupName returns the name whose lookup fetches the LeaseSet of addr.
+// Dialing a full destination needs no lookup of the destination, but still
+// one of its LeaseSet, which looking up its b32 address performs.
+func lookupName(addr ma.Multiaddr) (string, error) {
+	i2pAddr, err := MultiAddrToI2PAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(i2pAddr, ".i2p") {
+		return i2pAddr, nil
+	}
+	return i2pkeys.I2PAddr(i2pAddr).Base32(), nil
+}
+
+// lookup resolves name, a .b32.i2p or .b33.i2p address, to a destination
+func (p *samPrimarySession) lookup(ctx context.Context, name string) (string, error) {
+	if err := p.lock(ctx); err != nil {
+		return "", err
+	}
+	defer p.unlock()
+	reply, err := p.control.command(ctx, "NAMING REPLY", "NAMING LOOKUP NAME="+name)
+	if err != nil {
+		return "", err
+	}
+	return reply.values["VALUE"], nil
+}
+
+type prefetchJob struct {
+	addrs    peerstore.AddrBook
+	peers    func() []peer.ID
+	interval time.Duration
+}
+
+// WithPeerstorePrefetch keeps the LeaseSets of a set of peers warm. Right
+// after the transport is built and then every interval, or every 5 minutes
+// when interval is zero, the garlic addresses addrs holds for the peers
+// returned by peers are prefetched. peers is called from a background
+// goroutine, and could return bootstrap peers or the peers the connection
+// manager protects.
+func WithPeerstorePrefetch(addrs peerstore.AddrBook, peers func() []peer.ID, interval time.Duration) Option {
+	return func(i2p *I2PTransport) error {
+		if addrs == nil || peers == nil {
+			return errors.New("prefetching needs an address book and a set of peers")
+		}
+		if interval < 0 {
+			return errors.New("prefetch interval must not be negative")
+		}
+		if interval == 0 {
+			interval = defaultPrefetchInterval
+		}
+		i2p.prefetch = &prefetchJob{addrs: addrs, peers: peers, interval: interval}
+		return nil
+	}
+}
+
+// runPrefetch prefetches the job's peers until the transport is closed
+func (i2p *I2PTransport) runPrefetch(job *prefetchJob) {
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+	for {
+		var addrs []ma.Multiaddr
+		for _, id := range job.peers() {
+			addrs = append(addrs, job.addrs.Addrs(id)...)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), job.interval)
+		go func() {
+			select {
+			case <-i2p.closed:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		if err := i2p.Prefetch(ctx, addrs...); err != nil && ctx.Err() == nil {
+			log.Warn("prefetching LeaseSets failed", i2p.logErr(err))
+		}
+		cancel()
+
+		select {
+		case <-i2p.closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package i2p
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefetch(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	server, _ := newStandInTransport(t, standIn)
+	client, _ := newStandInTransport(t, standIn)
+
+	serverAddr := standInListenAddr(t, server)
+	serverB32 := server.i2PKeys.Addr().Base32()
+	serverB32Addr, err := I2PAddrToMultiAddr(serverB32)
+	require.NoError(t, err)
+
+	identity, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	unknownKeys, err := DeriveKeys(identity)
+	require.NoError(t, err)
+	unknownB32 := unknownKeys.Addr().Base32()
+	unknownAddr, err := I2PAddrToMultiAddr(unknownB32)
+	require.NoError(t, err)
+
+	err = client.Prefetch(context.Background(),
+		serverAddr,
+		serverB32Addr, // the same destination, looked up once
+		ma.StringCast("/ip4/127.0.0.1/tcp/4001"),
+		unknownAddr,
+	)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), unknownB32)
+	assert.NotContains(t, err.Error(), serverB32)
+	assert.Equal(t, []string{serverB32, unknownB32}, standIn.namingLookups())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, client.Prefetch(ctx, serverAddr), context.Canceled)
+}
+
+func TestPrefetchDoesNotHoldUpHealthCheck(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	server, _ := newStandInTransport(t, standIn)
+	client, _ := newStandInTransport(t, standIn)
+
+	serverAddr := standInListenAddr(t, server)
+	standIn.inject(t, serverAddr, standInFaults{lookupDelay: 2 * time.Second})
+	prefetched := make(chan error, 1)
+	go func() { prefetched <- client.Prefetch(context.Background(), serverAddr) }()
+	require.Eventually(t, func() bool {
+		return len(standIn.namingLookups()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the lookup holds the primary session's control connection, a ping
+	// gives up with its context rather than waiting for the router
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	client.mu.RLock()
+	primary := client.primarySession
+	client.mu.RUnlock()
+	start := time.Now()
+	assert.ErrorIs(t, primary.ping(ctx), errSAMControlBusy)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// and the health check does not take it for a lost router
+	client.checkSAMRouter(nil)
+	assert.NoError(t, <-prefetched)
+	client.mu.RLock()
+	assert.Same(t, primary, client.primarySession)
+	client.mu.RUnlock()
+	assert.True(t, client.Diagnostics().SessionsUp)
+}
+
+func TestPeerstorePrefetch(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	server, serverID := newStandInTransport(t, standIn)
+
+	ps, err := pstoremem.NewPeerstore()
+	require.NoError(t, err)
+	defer ps.Close()
+	ps.AddAddr(serverID, standInListenAddr(t, server), peerstore.PermanentAddrTTL)
+
+	newStandInTransport(t, standIn, WithPeerstorePrefetch(ps, func() []peer.ID {
+		return []peer.ID{serverID}
+	}, time.Hour))
+
+	serverB32 := server.i2PKeys.Addr().Base32()
+	assert.Eventually(t, func() bool {
+		for _, name := range standIn.namingLookups() {
+			if name == serverB32 {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Error(t, WithPeerstorePrefetch(nil, nil, 0)(&I2PTransport{}))
+	assert.Error(t, WithPeerstorePrefetch(ps, func() []peer.ID { return nil }, -time.Second)(&I2PTransport{}))
+}
+
+func TestSAMCommandSkipsReplyOfAbortedCommand(t *testing.T) {
+	clientSide, bridgeSide := net.Pipe()
+	defer clientSide.Close()
+	defer bridgeSide.Close()
+
+	// a bridge that answers the first command late
+	go func() {
+		reader := bufio.NewReader(bridgeSide)
+		reader.ReadString('\n')
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(bridgeSide, "NAMING REPLY RESULT=OK NAME=first VALUE=late\n")
+		reader.ReadString('\n')
+		io.WriteString(bridgeSide, "NAMING REPLY RESULT=OK NAME=second VALUE=current\n")
+	}()
+
+	conn := &samConn{Conn: clientSide, reader: bufio.NewReader(clientSide)}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := conn.command(ctx, "NAMING REPLY", "NAMING LOOKUP NAME=first")
+	require.Error(t, err)
+
+	reply, err := conn.command(context.Background(), "NAMING REPLY", "NAMING LOOKUP NAME=second")
+	require.NoError(t, err)
+	assert.Equal(t, "current", reply.values["VALUE"])
+}
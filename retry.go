@@ -0,0 +1,160 @@
+package i2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DialRetryPolicy retries stream connects that fail for reasons that tend to
+// pass, such as tunnels still being built or the peer's LeaseSet not being
+// found yet. The first dial to a freshly published destination often fails
+// this way. Other failures are returned right away.
+type DialRetryPolicy struct {
+	// MaxAttempts is the number of connect attempts, including the first
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry. It is multiplied by
+	// Multiplier for each further retry, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter randomizes each wait by up to this fraction in either
+	// direction, e.g. 0.2 for ±20%
+	Jitter float64
+}
+
+// DefaultDialRetryPolicy makes up to four attempts within about 15 seconds
+var DefaultDialRetryPolicy = DialRetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 2 * time.Second,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+func (p DialRetryPolicy) validate() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return errors.New("dial retry policy needs at least one attempt")
+	case p.InitialBackoff <= 0:
+		return errors.New("dial retry backoff must be positive")
+	case p.MaxBackoff < p.InitialBackoff:
+		return errors.New("maximum dial retry backoff must not be below the initial backoff")
+	case p.Multiplier < 1:
+		return errors.New("dial retry multiplier must be at least 1")
+	case p.Jitter < 0 || p.Jitter > 1:
+		return errors.New("dial retry jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// backoff returns the wait before the given retry, counting from 1
+func (p DialRetryPolicy) backoff(retry int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < retry && backoff < float64(p.MaxBackoff); i++ {
+		backoff *= p.Multiplier
+	}
+	backoff = min(backoff, float64(p.MaxBackoff))
+	if p.Jitter > 0 {
+		backoff *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(backoff)
+}
+
+// WithDialRetry retries dials according to policy. Retries stop early when
+// the next wait would run past the deadline of the dial's context.
+func WithDialRetry(policy DialRetryPolicy) Option {
+	return func(i2p *I2PTransport) error {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+		i2p.dialRetry = &policy
+		return nil
+	}
+}
+
+// DialError is returned by Dial when connecting the stream failed. Attempts
+// counts the tries made under the retry policy.
+type DialError struct {
+	Addr     string
+	Attempts int
+	Err      error
+}
+
+func (e *DialError) Error() string {
+	return fmt.Sprintf("failed to dial I2P address %s after %s (this may indicate I2P tunnels are not established): %v", e.Addr, attemptsLabel(e.Attempts), e.Err)
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// isRetriableDialError tells failures that may pass on their own, like a
+// LeaseSet that cannot be found yet, from ones that will not
+func isRetriableDialError(err error) bool {
+	var samErr *SAMError
+	if !errors.As(err, &samErr) || samErr.Command != "STREAM CONNECT" {
+		return false
+	}
+	switch samErr.Result {
+	case "TIMEOUT", "CANT_REACH_PEER", "PEER_NOT_FOUND":
+		return true
+	case "I2P_ERROR":
+		// Java I2P reports missing tunnels and LeaseSets as generic errors
+		message := strings.ToLower(samErr.Message)
+		return strings.Contains(message, "leaseset") || strings.Contains(message, "tunnel") || strings.Contains(message, "timeout")
+	}
+	return false
+}
+
+// dialWithRetry connects a stream to addr, retrying retriable failures
+// according to the transport's policy
+func (i2p *I2PTransport) dialWithRetry(ctx context.Context, session *samStreamSession, addr string) (*samStream, error) {
+	policy := DialRetryPolicy{MaxAttempts: 1}
+	if i2p.dialRetry != nil {
+		policy = *i2p.dialRetry
+	}
+
+	attempts := 0
+	done := func(conn *samStream, err error) (*samStream, error) {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("i2p.dial.attempts", attempts))
+		if i2p.metricsTracer != nil {
+			i2p.metricsTracer.DialAttempts(attempts, err)
+		}
+		if err != nil {
+			return nil, &DialError{Addr: addr, Attempts: attempts, Err: err}
+		}
+		return conn, nil
+	}
+
+	for {
+		attempts++
+		conn, err := session.dial(ctx, addr)
+		i2p.resolver.recordDial(err)
+		if err == nil || attempts >= policy.MaxAttempts || !isRetriableDialError(err) || ctx.Err() != nil {
+			return done(conn, err)
+		}
+
+		backoff := policy.backoff(attempts)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			// no time left for another attempt
+			return done(nil, err)
+		}
+		i2p.tracing.retry(ctx, attempts, err, addr)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return done(nil, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package i2p
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingTracer struct {
+	mu        sync.Mutex
+	attempts  []int
+	errs      []error
+	coalesced int
+}
+
+func (r *recordingTracer) DialAttempts(attempts int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts = append(r.attempts, attempts)
+	r.errs = append(r.errs, err)
+}
+
+func (r *recordingTracer) DialCoalesced() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.coalesced++
+}
+
+func (r *recordingTracer) recorded() ([]int, []error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int{}, r.attempts...), append([]error{}, r.errs...)
+}
+
+func TestDialRetryPolicyBackoff(t *testing.T) {
+	policy := DialRetryPolicy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 350 * time.Millisecond, Multiplier: 2}
+	require.NoError(t, policy.validate())
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 350*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, 350*time.Millisecond, policy.backoff(4))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		backoff := policy.backoff(1)
+		assert.GreaterOrEqual(t, backoff, 50*time.Millisecond)
+		assert.LessOrEqual(t, backoff, 150*time.Millisecond)
+	}
+
+	require.NoError(t, DefaultDialRetryPolicy.validate())
+	for _, invalid := range []DialRetryPolicy{
+		{MaxAttempts: 0, InitialBackoff: time.Second, MaxBackoff: time.Second, Multiplier: 2},
+		{MaxAttempts: 2, InitialBackoff: 0, MaxBackoff: time.Second, Multiplier: 2},
+		{MaxAttempts: 2, InitialBackoff: time.Second, MaxBackoff: time.Millisecond, Multiplier: 2},
+		{MaxAttempts: 2, InitialBackoff: time.Second, MaxBackoff: time.Second, Multiplier: 0.5},
+		{MaxAttempts: 2, InitialBackoff: time.Second, MaxBackoff: time.Second, Multiplier: 2, Jitter: 2},
+	} {
+		assert.Error(t, invalid.validate(), "%+v", invalid)
+	}
+}
+
+func TestIsRetriableDialError(t *testing.T) {
+	connect := func(result, message string) error {
+		return &SAMError{Command: "STREAM CONNECT", Result: result, Message: message}
+	}
+	assert.True(t, isRetriableDialError(connect("TIMEOUT", "")))
+	assert.True(t, isRetriableDialError(connect("CANT_REACH_PEER", "")))
+	assert.True(t, isRetriableDialError(&DialError{Err: connect("CANT_REACH_PEER", "")}))
+	assert.True(t, isRetriableDialError(connect("I2P_ERROR", "Could not find LeaseSet")))
+	assert.True(t, isRetriableDialError(connect("I2P_ERROR", "No outbound tunnels")))
+
+	assert.False(t, isRetriableDialError(connect("INVALID_KEY", "")))
+	assert.False(t, isRetriableDialError(connect("INVALID_ID", "")))
+	assert.False(t, isRetriableDialError(connect("I2P_ERROR", "Duplicated destination")))
+	assert.False(t, isRetriableDialError(&SAMError{Command: "SESSION CREATE", Result: "TIMEOUT"}))
+	assert.False(t, isRetriableDialError(errors.New("connection refused")))
+}
+
+func TestDialRetriesUntilPeerListens(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInAcceptTimeout(20*time.Millisecond))
+	server, serverID := newStandInTransport(t, standIn)
+	tracer := &recordingTracer{}
+	client, _ := newStandInTransport(t, standIn,
+		WithDialRetry(DialRetryPolicy{MaxAttempts: 20, InitialBackoff: 50 * time.Millisecond, MaxBackoff: 100 * time.Millisecond, Multiplier: 2, Jitter: 0.2}),
+		WithMetricsTracer(tracer),
+	)
+
+	// the server only starts listening once the client has failed a few times
+	addr := standInListenAddr(t, server)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		listener, err := server.Listen(addr)
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { listener.Close() })
+		serveEcho(listener)
+	}()
+
+	requireEcho(t, client, addr, serverID)
+	attempts, errs := tracer.recorded()
+	require.Len(t, attempts, 1)
+	assert.Greater(t, attempts[0], 1)
+	assert.NoError(t, errs[0])
+}
+
+func TestDialWithoutRetryPolicyTriesOnce(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInAcceptTimeout(20*time.Millisecond))
+	server, serverID := newStandInTransport(t, standIn)
+	tracer := &recordingTracer{}
+	client, _ := newStandInTransport(t, standIn, WithMetricsTracer(tracer))
+
+	_, err := client.Dial(context.Background(), standInListenAddr(t, server), serverID)
+	var dialErr *DialError
+	require.True(t, errors.As(err, &dialErr), "%v", err)
+	assert.Equal(t, 1, dialErr.Attempts)
+	assert.Contains(t, err.Error(), "after 1 attempt")
+
+	attempts, errs := tracer.recorded()
+	assert.Equal(t, []int{1}, attempts)
+	assert.Error(t, errs[0])
+}
+
+func TestDialRetryStopsBeforeDeadline(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInAcceptTimeout(20*time.Millisecond))
+	server, serverID := newStandInTransport(t, standIn)
+	client, _ := newStandInTransport(t, standIn,
+		WithDialRetry(DialRetryPolicy{MaxAttempts: 5, InitialBackoff: 5 * time.Second, MaxBackoff: 5 * time.Second, Multiplier: 1}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Dial(ctx, standInListenAddr(t, server), serverID)
+	assert.Less(t, time.Since(start), time.Second, "a wait past the deadline is not started")
+	var dialErr *DialError
+	require.True(t, errors.As(err, &dialErr), "%v", err)
+	assert.Equal(t, 1, dialErr.Attempts)
+}
@@ -0,0 +1,516 @@
+package i2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"github.com/joomcode/errorx"
+)
+
+const (
+	DefaultSAMHost = "127.0.0.1"
+	DefaultSAMPort = 7656
+
+	defaultSAMConnectTimeout = 30 * time.Second
+	defaultSAMMinVersion     = samPrimaryMinVersion
+
+	// the newest SAM version we speak
+	samMaxVersion = "3.3"
+	// PRIMARY sessions, which the transport always creates, need 3.3
+	samPrimaryMinVersion = "3.3"
+	// USER and PASSWORD on HELLO were added in 3.2
+	samAuthMinVersion = "3.2"
+)
+
+// ErrSAMAuthentication is returned when the SAM bridge rejects the configured
+// USER and PASSWORD, or requires credentials that were not configured
+var ErrSAMAuthentication = errors.New("SAM authentication failed")
+
+// SAMError is a failure the SAM bridge reported in the RESULT of a reply
+type SAMError struct {
+	// Command is the command that failed, e.g. "STREAM CONNECT"
+	Command string
+	// Result is the RESULT value, e.g. "CANT_REACH_PEER"
+	Result  string
+	Message string
+}
+
+func (e *SAMError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("SAM %s failed: %s", e.Command, e.Result)
+	}
+	return fmt.Sprintf("SAM %s failed: %s: %s", e.Command, e.Result, e.Message)
+}
+
+// SAMConfig describes how to reach the router's SAM bridge. Every SAM
+// connection the transport opens, for sessions as well as for each stream,
+// goes through the same handshake.
+type SAMConfig struct {
+	// Host and Port of the SAM bridge, 127.0.0.1:7656 when left empty
+	Host string
+	Port int
+
+	// UnixSocket is the path of a Unix domain socket the bridge listens on,
+	// for routers on the same host. When set, Host and Port are not used.
+	UnixSocket string
+
+	// TLS enables TLS for every SAM connection. SESSION CREATE sends the
+	// destination's private keys, so use it when the router is on another
+	// host. ServerName defaults to Host.
+	TLS *tls.Config
+
+	// TLSPins restricts the certificates the bridge may present to those
+	// whose public key matches one of the pins, see CertificatePin. Without
+	// TLS.RootCAs a matching pin is enough, which suits self-signed
+	// certificates.
+	TLSPins []string
+
+	// User and Password authenticate to bridges that require it. They need
+	// SAM 3.2, which raises the minimum version accordingly.
+	User     string
+	Password string
+
+	// ConnectTimeout bounds connecting and the HELLO handshake of each SAM
+	// connection, 30 seconds when zero
+	ConnectTimeout time.Duration
+
+	// MinVersion is the oldest SAM version the bridge may negotiate, "3.3"
+	// when empty. The transport needs 3.3 for its PRIMARY session, a lower
+	// minimum only helps ProbeSAM and key generation on older bridges.
+	MinVersion string
+}
+
+// Address returns the host:port of the SAM bridge, or the socket path
+func (c SAMConfig) Address() string {
+	if c.UnixSocket != "" {
+		return c.UnixSocket
+	}
+	c = c.withDefaults()
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+func (c SAMConfig) network() string {
+	if c.UnixSocket != "" {
+		return "unix"
+	}
+	return "tcp"
+}
+
+func (c SAMConfig) withDefaults() SAMConfig {
+	if c.Host == "" {
+		c.Host = DefaultSAMHost
+	}
+	if c.Port == 0 {
+		c.Port = DefaultSAMPort
+	}
+	if c.ConnectTimeout == 0 {
+		c.ConnectTimeout = defaultSAMConnectTimeout
+	}
+	if c.MinVersion == "" {
+		c.MinVersion = defaultSAMMinVersion
+	}
+	return c
+}
+
+func (c SAMConfig) validate() error {
+	c = c.withDefaults()
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("SAM port %d is out of range", c.Port)
+	}
+	if c.ConnectTimeout < 0 {
+		return errors.New("SAM connect timeout must not be negative")
+	}
+	minVersion, err := parseSAMVersion(c.MinVersion)
+	if err != nil {
+		return err
+	}
+	if samVersionLess(mustParseSAMVersion(samMaxVersion), minVersion) {
+		return fmt.Errorf("minimum SAM version %s is newer than the supported %s", c.MinVersion, samMaxVersion)
+	}
+	if len(c.TLSPins) > 0 && c.TLS == nil {
+		return errors.New("SAM certificate pins need TLS to be enabled")
+	}
+	if _, err := c.tlsConfig(); err != nil {
+		return err
+	}
+	if (c.User == "") != (c.Password == "") {
+		return errors.New("SAM user and password must be set together")
+	}
+	for _, value := range []string{c.User, c.Password} {
+		if strings.ContainsAny(value, "\r\n") {
+			return errors.New("SAM credentials must not contain line breaks")
+		}
+	}
+	return nil
+}
+
+// ParseSAMAddress parses the host:port of a SAM bridge, such as the address
+// a sam3.SAM was created with, into a SAMConfig without further settings
+func ParseSAMAddress(address string) (SAMConfig, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return SAMConfig{}, errorx.Decorate(err, "Invalid SAM address %q", address)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return SAMConfig{}, errorx.Decorate(err, "Invalid SAM port %q", port)
+	}
+	config := SAMConfig{Host: host, Port: portNumber}
+	if err := config.validate(); err != nil {
+		return SAMConfig{}, err
+	}
+	return config, nil
+}
+
+// WithSAMConfig sets how the transport reaches the SAM bridge. It takes
+// precedence over the address of the *sam3.SAM passed to
+// I2PTransportBuilder, which may then be nil. See WithSAMEndpoints for
+// failing over to other bridges.
+func WithSAMConfig(config SAMConfig) Option {
+	return func(i2p *I2PTransport) error {
+		if err := config.validate(); err != nil {
+			return err
+		}
+		i2p.samEndpoints = []SAMConfig{config.withDefaults()}
+		i2p.activeEndpoint = 0
+		return nil
+	}
+}
+
+// samConn is a connection to the SAM bridge that completed the HELLO
+// handshake. Commands are line based, once a STREAM CONNECT or ACCEPT
+// succeeds the connection carries the stream instead.
+type samConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	version string
+
+	// replies still due to commands that were aborted while waiting, they
+	// are skipped before the next reply is read
+	owed int
+}
+
+// dialSAM connects to the SAM bridge and performs the HELLO handshake
+func dialSAM(ctx context.Context, config SAMConfig) (*samConn, error) {
+	config = config.withDefaults()
+	ctx, cancel := context.WithTimeout(ctx, config.ConnectTimeout)
+	defer cancel()
+
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, config.network(), config.Address())
+	if err != nil {
+		return nil, errorx.Decorate(err, "Failed to connect to SAM bridge at %s", config.Address())
+	}
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, errorx.Decorate(err, "TLS handshake with SAM bridge at %s failed", config.Address())
+		}
+		conn = tlsConn
+	}
+
+	sam := &samConn{Conn: conn, reader: bufio.NewReader(conn)}
+	if err := sam.hello(ctx, config); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return sam, nil
+}
+
+func (c *samConn) hello(ctx context.Context, config SAMConfig) error {
+	minVersion := config.MinVersion
+	if config.User != "" && samVersionLess(mustParseSAMVersion(minVersion), mustParseSAMVersion(samAuthMinVersion)) {
+		minVersion = samAuthMinVersion
+	}
+
+	command := "HELLO VERSION MIN=" + minVersion + " MAX=" + samMaxVersion
+	if config.User != "" {
+		command += " USER=" + samQuote(config.User) + " PASSWORD=" + samQuote(config.Password)
+	}
+
+	reply, err := c.command(ctx, "HELLO REPLY", command)
+	if err != nil {
+		var samErr *SAMError
+		if errors.As(err, &samErr) {
+			switch {
+			case samErr.Result == "NOVERSION":
+				return fmt.Errorf("SAM bridge supports no version between %s and %s: %w", minVersion, samMaxVersion, err)
+			case isSAMAuthFailure(samErr):
+				return fmt.Errorf("%w: %w", ErrSAMAuthentication, err)
+			}
+		}
+		return err
+	}
+
+	version, ok := reply.values["VERSION"]
+	if !ok {
+		return errors.New("SAM bridge did not report a version in its HELLO reply")
+	}
+	negotiated, err := parseSAMVersion(version)
+	if err != nil {
+		return errorx.Decorate(err, "SAM bridge replied with an invalid version")
+	}
+	if samVersionLess(negotiated, mustParseSAMVersion(minVersion)) || samVersionLess(mustParseSAMVersion(samMaxVersion), negotiated) {
+		return fmt.Errorf("SAM bridge negotiated version %s, outside of %s to %s", version, minVersion, samMaxVersion)
+	}
+	c.version = version
+	return nil
+}
+
+// isSAMAuthFailure recognizes the errors Java I2P and i2pd return for missing
+// or wrong credentials. Neither uses a dedicated RESULT.
+func isSAMAuthFailure(err *SAMError) bool {
+	message := strings.ToLower(err.Message)
+	return strings.Contains(message, "auth") || strings.Contains(message, "user") || strings.Contains(message, "password")
+}
+
+// command sends one command line and reads the reply, which must be of the
+// expected kind, e.g. "SESSION STATUS". A RESULT other than OK is returned
+// as a *SAMError. Cancelling ctx aborts the command by expiring the
+// connection's deadline.
+func (c *samConn) command(ctx context.Context, expect string, command string) (*samReply, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.Conn.SetDeadline(deadline)
+	}
+	aborted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		c.Conn.SetDeadline(time.Now())
+		close(aborted)
+	})
+	defer func() {
+		if !stop() {
+			// the deadline must not be set after it is cleared below
+			<-aborted
+		}
+		c.Conn.SetDeadline(time.Time{})
+	}()
+
+	name := commandName(command)
+	for c.owed > 0 {
+		if _, err := c.reader.ReadString('\n'); err != nil {
+			return nil, c.contextError(ctx, errorx.Decorate(err, "Failed to skip reply to an aborted SAM command"))
+		}
+		c.owed--
+	}
+	if _, err := c.Conn.Write([]byte(command + "\n")); err != nil {
+		return nil, c.contextError(ctx, errorx.Decorate(err, "Failed to send SAM %s", name))
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			// the bridge will still reply, possibly with part of the line
+			// already read
+			c.owed++
+		}
+		return nil, c.contextError(ctx, errorx.Decorate(err, "Failed to read SAM %s reply", name))
+	}
+
+	reply, err := parseSAMReply(line)
+	if err != nil {
+		return nil, err
+	}
+	if reply.topic+" "+reply.kind != expect {
+		return nil, fmt.Errorf("unexpected reply to SAM %s: %q", name, strings.TrimSpace(line))
+	}
+	if result, ok := reply.values["RESULT"]; ok && result != "OK" {
+		return nil, &SAMError{Command: name, Result: result, Message: reply.values["MESSAGE"]}
+	}
+	return reply, nil
+}
+
+// contextError prefers the context's error when it caused err
+func (c *samConn) contextError(ctx context.Context, err error) error {
+	if ctxErr := ctxErr(ctx); ctxErr != nil {
+		return errorx.Decorate(ctxErr, "SAM command aborted")
+	}
+	return err
+}
+
+// ctxErr returns the context's error, or DeadlineExceeded once its deadline
+// passed. A connection deadline set from the context can expire before the
+// context notices.
+func ctxErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// commandName returns the first two words of a command, e.g. "SESSION CREATE"
+func commandName(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) > 2 {
+		fields = fields[:2]
+	}
+	return strings.Join(fields, " ")
+}
+
+// samReply is a parsed reply line, e.g.
+// SESSION STATUS RESULT=I2P_ERROR MESSAGE="Duplicate destination"
+type samReply struct {
+	topic  string
+	kind   string
+	values map[string]string
+}
+
+// parseSAMReply splits a reply line into its topic, kind and key=value
+// pairs. Values may be double quoted, with backslash escapes inside quotes.
+func parseSAMReply(line string) (*samReply, error) {
+	line = strings.TrimRight(line, "\r\n")
+	tokens, err := samTokens(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) < 2 {
+		return nil, fmt.Errorf("SAM reply %q is too short", line)
+	}
+
+	reply := &samReply{topic: tokens[0], kind: tokens[1], values: make(map[string]string)}
+	for _, token := range tokens[2:] {
+		key, value, _ := strings.Cut(token, "=")
+		if key == "" {
+			return nil, fmt.Errorf("SAM reply %q has an empty key", line)
+		}
+		reply.values[key] = value
+	}
+	return reply, nil
+}
+
+// samTokens splits on spaces, keeping quoted sections together and
+// removing the quotes
+func samTokens(line string) ([]string, error) {
+	var tokens []string
+	var token strings.Builder
+	inToken, quoted, escaped := false, false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			token.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+			inToken = true
+		case r == ' ' && !quoted:
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+		default:
+			token.WriteRune(r)
+			inToken = true
+		}
+	}
+	if quoted || escaped {
+		return nil, fmt.Errorf("SAM reply %q has an unterminated quote", line)
+	}
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+	return tokens, nil
+}
+
+// samQuote quotes a value for a command when it contains characters that
+// would otherwise split it
+func samQuote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\"\\=") {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+type samVersion struct {
+	major, minor int
+}
+
+func parseSAMVersion(version string) (samVersion, error) {
+	major, minor, found := strings.Cut(version, ".")
+	if !found {
+		minor = "0"
+	}
+	majorNumber, err := strconv.Atoi(major)
+	if err != nil || majorNumber < 0 {
+		return samVersion{}, fmt.Errorf("invalid SAM version %q", version)
+	}
+	minorNumber, err := strconv.Atoi(minor)
+	if err != nil || minorNumber < 0 {
+		return samVersion{}, fmt.Errorf("invalid SAM version %q", version)
+	}
+	return samVersion{major: majorNumber, minor: minorNumber}, nil
+}
+
+// mustParseSAMVersion is for versions that were validated before
+func mustParseSAMVersion(version string) samVersion {
+	parsed, err := parseSAMVersion(version)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func samVersionLess(a, b samVersion) bool {
+	return a.major < b.major || (a.major == b.major && a.minor < b.minor)
+}
+
+// GenerateKeysWithConfig is GenerateKeys for bridges that need the SAM
+// configuration, e.g. because they require authentication
+func GenerateKeysWithConfig(ctx context.Context, config SAMConfig, types KeyTypes) (i2pkeys.I2PKeys, error) {
+	if err := types.validate(); err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	if err := config.validate(); err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+
+	conn, err := dialSAM(ctx, config)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	defer conn.Close()
+
+	reply, err := conn.command(ctx, "DEST REPLY", "DEST GENERATE "+types.Signature.samOption())
+	if err != nil {
+		return i2pkeys.I2PKeys{}, errorx.Decorate(err, "Failed to generate I2P keys")
+	}
+	keys := i2pkeys.NewKeys(i2pkeys.I2PAddr(reply.values["PUB"]), reply.values["PRIV"])
+	if err := checkGeneratedKeys(keys, types); err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	return keys, nil
+}
+
+// ProbeSAM connects to the SAM bridge and performs the HELLO handshake
+// without creating a session, returning the negotiated version. It checks
+// that a router is up and accepts the configuration, e.g. in health checks.
+func ProbeSAM(ctx context.Context, config SAMConfig) (string, error) {
+	if err := config.validate(); err != nil {
+		return "", err
+	}
+	conn, err := dialSAM(ctx, config)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.version, nil
+}
@@ -0,0 +1,318 @@
+package i2p
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joomcode/errorx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSAMReply(t *testing.T) {
+	reply, err := parseSAMReply("SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"Duplicate \\\"dest\\\"\"\n")
+	require.NoError(t, err)
+	assert.Equal(t, "SESSION", reply.topic)
+	assert.Equal(t, "STATUS", reply.kind)
+	assert.Equal(t, "I2P_ERROR", reply.values["RESULT"])
+	assert.Equal(t, `Duplicate "dest"`, reply.values["MESSAGE"])
+
+	reply, err = parseSAMReply("HELLO REPLY RESULT=OK VERSION=3.3")
+	require.NoError(t, err)
+	assert.Equal(t, "3.3", reply.values["VERSION"])
+
+	reply, err = parseSAMReply("DEST REPLY PUB=abc= PRIV=def==")
+	require.NoError(t, err)
+	assert.Equal(t, "abc=", reply.values["PUB"], "only the first = separates key and value")
+	assert.Equal(t, "def==", reply.values["PRIV"])
+
+	for _, line := range []string{"", "HELLO", "HELLO REPLY MESSAGE=\"open", "HELLO REPLY =OK"} {
+		_, err := parseSAMReply(line)
+		assert.Error(t, err, "%q", line)
+	}
+}
+
+func TestSAMQuoteRoundTrip(t *testing.T) {
+	for _, value := range []string{"plain", "with space", `back\slash`, `"quoted"`, "a=b", ""} {
+		reply, err := parseSAMReply("HELLO VERSION USER=" + samQuote(value))
+		require.NoError(t, err, value)
+		assert.Equal(t, value, reply.values["USER"])
+	}
+}
+
+// FuzzParseSAMReply checks that parsing never panics and that what it
+// parsed is parsed the same after being written out again with samQuote
+func FuzzParseSAMReply(f *testing.F) {
+	f.Add("HELLO REPLY RESULT=OK VERSION=3.3\n")
+	f.Add(`SESSION STATUS RESULT=I2P_ERROR MESSAGE="Duplicate \"dest\""`)
+	f.Add("STREAM STATUS RESULT=CANT_REACH_PEER MESSAGE=\"Could not find LeaseSet\"\r\n")
+	f.Fuzz(func(t *testing.T, line string) {
+		reply, err := parseSAMReply(line)
+		if err != nil {
+			return
+		}
+		// line breaks cannot be quoted, SAMConfig.validate rejects them in
+		// the values we send
+		if strings.ContainsAny(reply.topic+reply.kind, "\r\n") {
+			return
+		}
+		written := samQuote(reply.topic) + " " + samQuote(reply.kind)
+		for key, value := range reply.values {
+			if strings.ContainsAny(key+value, "\r\n") {
+				return
+			}
+			written += " " + samQuote(key) + "=" + samQuote(value)
+		}
+
+		again, err := parseSAMReply(written)
+		require.NoError(t, err, "%q written as %q", line, written)
+		require.Equal(t, reply, again, "%q written as %q", line, written)
+	})
+}
+
+// FuzzParseSAMVersion checks that parsing never panics and that a parsed
+// version reads back the same
+func FuzzParseSAMVersion(f *testing.F) {
+	f.Add("3.3")
+	f.Add("3")
+	f.Add("-1.0")
+	f.Fuzz(func(t *testing.T, version string) {
+		parsed, err := parseSAMVersion(version)
+		if err != nil {
+			return
+		}
+		written := fmt.Sprintf("%d.%d", parsed.major, parsed.minor)
+		again, err := parseSAMVersion(written)
+		require.NoError(t, err, "%q written as %q", version, written)
+		require.Equal(t, parsed, again)
+	})
+}
+
+func TestSAMConfigValidate(t *testing.T) {
+	assert.NoError(t, SAMConfig{}.validate())
+	assert.Equal(t, "127.0.0.1:7656", SAMConfig{}.Address())
+	assert.NoError(t, SAMConfig{User: "user", Password: "secret"}.validate())
+
+	assert.Error(t, SAMConfig{Port: 70000}.validate())
+	assert.Error(t, SAMConfig{ConnectTimeout: -time.Second}.validate())
+	assert.Error(t, SAMConfig{MinVersion: "three"}.validate())
+	assert.Error(t, SAMConfig{MinVersion: "4.0"}.validate())
+	assert.Error(t, SAMConfig{User: "user"}.validate(), "a user needs a password")
+	assert.Error(t, SAMConfig{User: "user", Password: "se\ncret"}.validate())
+}
+
+func TestParseSAMAddress(t *testing.T) {
+	config, err := ParseSAMAddress("10.0.0.1:7000")
+	require.NoError(t, err)
+	assert.Equal(t, SAMConfig{Host: "10.0.0.1", Port: 7000}, config)
+
+	config, err = ParseSAMAddress(SAMConfig{}.Address())
+	require.NoError(t, err)
+	assert.Equal(t, SAMConfig{}.Address(), config.Address())
+
+	for _, address := range []string{"localhost", "localhost:sam", "localhost:70000", ""} {
+		_, err := ParseSAMAddress(address)
+		assert.Error(t, err, address)
+	}
+}
+
+func TestSAMAuthentication(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInAuth("libp2p", "pass word"))
+	ctx := context.Background()
+
+	conn, err := dialSAM(ctx, standIn.config())
+	require.NoError(t, err)
+	assert.Equal(t, samMaxVersion, conn.version)
+	conn.Close()
+
+	wrong := standIn.config()
+	wrong.Password = "guess"
+	_, err = dialSAM(ctx, wrong)
+	assert.True(t, errors.Is(err, ErrSAMAuthentication), "wrong password: %v", err)
+
+	missing := standIn.config()
+	missing.User, missing.Password = "", ""
+	_, err = dialSAM(ctx, missing)
+	assert.True(t, errors.Is(err, ErrSAMAuthentication), "missing credentials: %v", err)
+
+	_, err = GenerateKeysWithConfig(ctx, wrong, DefaultKeyTypes)
+	assert.True(t, errors.Is(err, ErrSAMAuthentication), "key generation: %v", err)
+}
+
+func TestSAMMinVersion(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInVersion("3.1"))
+	ctx := context.Background()
+
+	// the default minimum is what PRIMARY sessions need
+	_, err := dialSAM(ctx, standIn.config())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "NOVERSION")
+
+	config := standIn.config()
+	config.MinVersion = "3.0"
+	conn, err := dialSAM(ctx, config)
+	require.NoError(t, err)
+	assert.Equal(t, "3.1", conn.version)
+	conn.Close()
+
+	config.MinVersion = "3.2"
+	_, err = dialSAM(ctx, config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "NOVERSION")
+
+	// credentials need 3.2, so they raise the minimum on their own
+	config.MinVersion = "3.0"
+	config.User, config.Password = "user", "secret"
+	_, err = dialSAM(ctx, config)
+	assert.Error(t, err)
+}
+
+func TestTransportNeedsPrimarySessionVersion(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInVersion("3.2"))
+	config := standIn.config()
+	config.MinVersion = "3.0"
+	keys, err := GenerateKeysWithConfig(context.Background(), config, DefaultKeyTypes)
+	require.NoError(t, err)
+
+	_, _, err = I2PTransportBuilder(nil, keys, "0", 0, WithSAMConfig(config))
+	assert.ErrorContains(t, err, "PRIMARY sessions need 3.3")
+	assert.Empty(t, standIn.createdSessions())
+}
+
+func TestProbeSAM(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInVersion("3.2"), withStandInAuth("user", "secret"))
+	ctx := context.Background()
+
+	config := standIn.config()
+	config.MinVersion = "3.2"
+	version, err := ProbeSAM(ctx, config)
+	require.NoError(t, err)
+	assert.Equal(t, "3.2", version)
+
+	_, err = ProbeSAM(ctx, standIn.config())
+	assert.ErrorContains(t, err, "NOVERSION")
+
+	config.Password = "guess"
+	_, err = ProbeSAM(ctx, config)
+	assert.ErrorIs(t, err, ErrSAMAuthentication)
+
+	config = standIn.config()
+	config.MinVersion = "4.0"
+	_, err = ProbeSAM(ctx, config)
+	assert.Error(t, err, "the configuration is validated first")
+}
+
+func TestSAMConnectTimeout(t *testing.T) {
+	// accepts connections but never answers HELLO
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	config := SAMConfig{Host: addr.IP.String(), Port: addr.Port, ConnectTimeout: 200 * time.Millisecond}
+	start := time.Now()
+	_, err = dialSAM(context.Background(), config)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+// expiredContext has a deadline in the past but has not noticed yet, like a
+// context whose timer has not fired when a connection deadline expires
+type expiredContext struct {
+	context.Context
+}
+
+func (expiredContext) Deadline() (time.Time, bool) {
+	return time.Now().Add(-time.Millisecond), true
+}
+
+func TestSAMContextErrorAfterDeadline(t *testing.T) {
+	ctx := expiredContext{context.Background()}
+	require.NoError(t, ctx.Err())
+	assert.ErrorIs(t, ctxErr(ctx), context.DeadlineExceeded)
+
+	readErr := errors.New("i/o timeout")
+	assert.Equal(t, context.DeadlineExceeded, errorx.Cast((&samConn{}).contextError(ctx, readErr)).Cause())
+	assert.Equal(t, readErr, (&samConn{}).contextError(context.Background(), readErr))
+	assert.NoError(t, ctxErr(context.Background()))
+}
+
+func TestTransportWithSAMAuthentication(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInAuth("libp2p", "secret"))
+	requireStandInEcho(t, standIn)
+
+	for _, line := range standIn.createdSessions() {
+		if strings.Contains(line, "STYLE=PRIMARY") {
+			assert.Contains(t, line, "i2cp.leaseSetEncType=4,0")
+		}
+	}
+}
+
+func TestTransportBuilderReportsSAMAuthentication(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInAuth("libp2p", "secret"))
+	keys, err := GenerateKeysWithConfig(context.Background(), standIn.config(), DefaultKeyTypes)
+	require.NoError(t, err)
+
+	wrong := standIn.config()
+	wrong.Password = "guess"
+	_, _, err = I2PTransportBuilder(nil, keys, "0", 0, WithSAMConfig(wrong))
+	assert.True(t, errors.Is(err, ErrSAMAuthentication), "%v", err)
+}
+
+func TestTransportBuilderContext(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	keys, err := GenerateKeysWithConfig(context.Background(), standIn.config(), DefaultKeyTypes)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err = I2PTransportBuilderContext(ctx, nil, keys, "0", 0, WithSAMConfig(newSilentSAMBridge(t)), WithSAMHealthCheck(0))
+	assert.ErrorContains(t, err, context.DeadlineExceeded.Error())
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+// newSilentSAMBridge negotiates SAM but never answers SESSION CREATE, as
+// while the router builds tunnels
+func newSilentSAMBridge(t *testing.T) SAMConfig {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				if _, err := reader.ReadString('\n'); err != nil {
+					return
+				}
+				conn.Write([]byte("HELLO REPLY RESULT=OK VERSION=3.3\n"))
+				io.Copy(io.Discard, reader)
+			}()
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return SAMConfig{Host: addr.IP.String(), Port: addr.Port}
+}
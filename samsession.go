@@ -0,0 +1,299 @@
+package i2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"github.com/joomcode/errorx"
+)
+
+// samPrimarySession is a SAM PRIMARY session for our destination. It lives
+// as long as its control connection, the stream subsessions share its tunnels.
+type samPrimarySession struct {
+	config  SAMConfig
+	id      string
+	addr    i2pkeys.I2PAddr
+	version string
+
+	// SAM answers commands on the control connection in order, holding busy
+	// is holding the connection
+	busy    chan struct{}
+	control *samConn
+}
+
+// errSAMControlBusy is returned when a command gives up waiting for the
+// control connection, which another command still holds
+var errSAMControlBusy = errors.New("SAM control connection is busy with another command")
+
+// lock waits for the control connection until ctx is done
+func (p *samPrimarySession) lock(ctx context.Context) error {
+	select {
+	case p.busy <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errSAMControlBusy
+	}
+}
+
+func (p *samPrimarySession) unlock() {
+	<-p.busy
+}
+
+func newSAMPrimarySession(ctx context.Context, config SAMConfig, id string, keys i2pkeys.I2PKeys, options []string) (*samPrimarySession, error) {
+	control, err := dialSAM(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if samVersionLess(mustParseSAMVersion(control.version), mustParseSAMVersion(samPrimaryMinVersion)) {
+		control.Close()
+		return nil, fmt.Errorf("SAM bridge negotiated version %s, PRIMARY sessions need %s", control.version, samPrimaryMinVersion)
+	}
+
+	command := "SESSION CREATE STYLE=PRIMARY ID=" + id + " DESTINATION=" + keys.String()
+	if len(options) > 0 {
+		command += " " + strings.Join(options, " ")
+	}
+	if _, err := control.command(ctx, "SESSION STATUS", command); err != nil {
+		control.Close()
+		return nil, err
+	}
+
+	return &samPrimarySession{
+		config:  config,
+		id:      id,
+		addr:    keys.Addr(),
+		version: control.version,
+		busy:    make(chan struct{}, 1),
+		control: control,
+	}, nil
+}
+
+// newStreamSubSession adds a STREAM subsession. SAM tells subsessions of one
+// primary session apart by protocol and port, so each needs its own ports.
+func (p *samPrimarySession) newStreamSubSession(ctx context.Context, id, fromPort, toPort string) (*samStreamSession, error) {
+	if err := p.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer p.unlock()
+
+	command := "SESSION ADD STYLE=STREAM ID=" + id + " FROM_PORT=" + fromPort + " TO_PORT=" + toPort
+	if _, err := p.control.command(ctx, "SESSION STATUS", command); err != nil {
+		return nil, err
+	}
+	return &samStreamSession{config: p.config, id: id, addr: p.addr}, nil
+}
+
+func (p *samPrimarySession) Addr() i2pkeys.I2PAddr {
+	return p.addr
+}
+
+// Close ends the primary session along with its subsessions
+func (p *samPrimarySession) Close() error {
+	return p.control.Close()
+}
+
+// samStreamSession is a STREAM session, either a subsession of a primary
+// session or a standalone one owning its control connection
+type samStreamSession struct {
+	config SAMConfig
+	id     string
+	addr   i2pkeys.I2PAddr
+
+	// only set for standalone sessions
+	control *samConn
+}
+
+// newSAMTransientStreamSession creates a standalone STREAM session for a new
+// transient destination of the given signature type
+func newSAMTransientStreamSession(ctx context.Context, config SAMConfig, id string, sigType SignatureType, options []string) (*samStreamSession, error) {
+	control, err := dialSAM(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	command := "SESSION CREATE STYLE=STREAM ID=" + id + " DESTINATION=TRANSIENT " + sigType.samOption()
+	if len(options) > 0 {
+		command += " " + strings.Join(options, " ")
+	}
+	reply, err := control.command(ctx, "SESSION STATUS", command)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+
+	// the reply carries the private keys, the destination is their prefix
+	private, err := i2pB64Encoding.DecodeString(reply.values["DESTINATION"])
+	if err != nil {
+		control.Close()
+		return nil, fmt.Errorf("SAM returned invalid transient keys: %w", err)
+	}
+	dest, err := parseDestinationBytes(private)
+	if err != nil {
+		control.Close()
+		return nil, errorx.Decorate(err, "SAM returned invalid transient keys")
+	}
+
+	return &samStreamSession{
+		config:  config,
+		id:      id,
+		addr:    i2pkeys.I2PAddr(i2pB64Encoding.EncodeToString(dest.raw)),
+		control: control,
+	}, nil
+}
+
+func (s *samStreamSession) LocalAddr() i2pkeys.I2PAddr {
+	return s.addr
+}
+
+// dial opens a stream to dest, a base64 destination or a .b32.i2p address
+func (s *samStreamSession) dial(ctx context.Context, dest string) (*samStream, error) {
+	conn, err := dialSAM(ctx, s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	command := "STREAM CONNECT ID=" + s.id + " DESTINATION=" + dest + " SILENT=false"
+	if _, err := conn.command(ctx, "STREAM STATUS", command); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var remoteAddr net.Addr = i2pkeys.I2PAddr(dest)
+	if strings.HasSuffix(dest, ".i2p") {
+		remoteAddr = i2pName(dest)
+	}
+	return &samStream{samConn: conn, localAddr: s.addr, remoteAddr: remoteAddr, session: s.id}, nil
+}
+
+// listen returns a listener accepting streams for the session
+func (s *samStreamSession) listen() *samStreamListener {
+	return &samStreamListener{session: s, pending: make(map[*samConn]struct{})}
+}
+
+// Close ends a standalone session, subsessions end with their primary session
+func (s *samStreamSession) Close() error {
+	if s.control == nil {
+		return nil
+	}
+	return s.control.Close()
+}
+
+// samStreamListener accepts streams with STREAM ACCEPT, one SAM connection
+// per accepted stream
+type samStreamListener struct {
+	session *samStreamSession
+
+	mu      sync.Mutex
+	closed  bool
+	pending map[*samConn]struct{}
+}
+
+var errListenerClosed = errors.New("SAM stream listener closed")
+
+func (l *samStreamListener) accept() (net.Conn, error) {
+	conn, err := dialSAM(context.Background(), l.session.config)
+	if err != nil {
+		return nil, err
+	}
+
+	// tracked so Close can interrupt the wait for a peer
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		conn.Close()
+		return nil, errListenerClosed
+	}
+	l.pending[conn] = struct{}{}
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.pending, conn)
+		l.mu.Unlock()
+	}()
+
+	stream, err := l.acceptOn(conn)
+	if err != nil {
+		conn.Close()
+		l.mu.Lock()
+		closed := l.closed
+		l.mu.Unlock()
+		if closed {
+			return nil, errListenerClosed
+		}
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (l *samStreamListener) acceptOn(conn *samConn) (*samStream, error) {
+	command := "STREAM ACCEPT ID=" + l.session.id + " SILENT=false"
+	if _, err := conn.command(context.Background(), "STREAM STATUS", command); err != nil {
+		return nil, err
+	}
+
+	// once a peer connects, SAM sends its destination and ports on one line
+	// before the stream data
+	line, err := conn.reader.ReadString('\n')
+	if err != nil {
+		return nil, errorx.Decorate(err, "Failed to read peer destination of accepted stream")
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, errors.New("SAM sent an empty peer destination for an accepted stream")
+	}
+
+	return &samStream{samConn: conn, localAddr: l.session.addr, remoteAddr: i2pkeys.I2PAddr(fields[0]), session: l.session.id}, nil
+}
+
+func (l *samStreamListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for conn := range l.pending {
+		conn.Close()
+	}
+	return nil
+}
+
+func (l *samStreamListener) Addr() net.Addr {
+	return l.session.addr
+}
+
+// samStream is an established I2P stream. Reads go through the buffered
+// reader, which may already hold data that arrived with the reply.
+type samStream struct {
+	*samConn
+	localAddr  i2pkeys.I2PAddr
+	remoteAddr net.Addr
+
+	// ID of the session the stream was opened on
+	session string
+}
+
+// i2pName is the address of a stream dialed by name, e.g. a .b32.i2p
+// address, rather than by full destination
+type i2pName string
+
+func (n i2pName) Network() string {
+	return "I2P"
+}
+
+func (n i2pName) String() string {
+	return string(n)
+}
+
+func (s *samStream) Read(b []byte) (int, error) {
+	return s.reader.Read(b)
+}
+
+func (s *samStream) LocalAddr() net.Addr {
+	return s.localAddr
+}
+
+func (s *samStream) RemoteAddr() net.Addr {
+	return s.remoteAddr
+}
@@ -0,0 +1,900 @@
+package i2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/core/transport"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/x/rate"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// samStandIn is a minimal in-process SAM bridge. It implements the parts of
+// SAM 3.3 the transport uses: HELLO with optional authentication, DEST
+// GENERATE, PRIMARY and STREAM sessions, SESSION ADD, STREAM CONNECT and
+// STREAM ACCEPT. Streams between its own destinations are piped locally, no
+// I2P network is involved. The pipe can emulate tunnels, see standInLink,
+// and inject failures per destination, see standInFaults.
+type samStandIn struct {
+	listener net.Listener
+
+	version  string
+	user     string
+	password string
+
+	// set to serve SAM over TLS or a Unix domain socket instead of TCP
+	certificate *tls.Certificate
+	unixSocket  string
+
+	// how long STREAM CONNECT waits for the peer to have a STREAM ACCEPT
+	// pending before reporting CANT_REACH_PEER
+	acceptTimeout time.Duration
+	// the emulated tunnels streams are carried over
+	link standInLink
+
+	// closed with the stand-in
+	done chan struct{}
+
+	mu           sync.Mutex
+	sessions     map[string]*standInSession
+	destinations map[string]*standInDestination
+	conns        map[net.Conn]struct{}
+	// the SESSION CREATE lines received, to check the options sent
+	created []string
+	// refuses the SESSION CREATE lines it returns true for, see refuseSessions
+	refuse func(line string) bool
+	// the number of STREAM CONNECTs received
+	connects int
+	// the names of NAMING LOOKUPs received
+	lookups []string
+	// closed and replaced whenever faults are injected
+	faultsChanged chan struct{}
+}
+
+type standInSession struct {
+	id   string
+	dest *standInDestination
+	conn net.Conn
+}
+
+type standInDestination struct {
+	dest     string
+	b32      string
+	sessions int
+
+	accepts []*standInAccept
+	// closed and replaced whenever an accept is queued
+	queued chan struct{}
+
+	faults standInFaults
+}
+
+type standInAccept struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+type standInOption func(*samStandIn)
+
+func withStandInAuth(user, password string) standInOption {
+	return func(s *samStandIn) {
+		s.user = user
+		s.password = password
+	}
+}
+
+// withStandInAcceptTimeout sets how long STREAM CONNECT waits for a pending
+// STREAM ACCEPT before failing with CANT_REACH_PEER
+func withStandInAcceptTimeout(timeout time.Duration) standInOption {
+	return func(s *samStandIn) {
+		s.acceptTimeout = timeout
+	}
+}
+
+func withStandInVersion(version string) standInOption {
+	return func(s *samStandIn) {
+		s.version = version
+	}
+}
+
+// withStandInTLS terminates TLS with the certificate
+func withStandInTLS(certificate tls.Certificate) standInOption {
+	return func(s *samStandIn) {
+		s.certificate = &certificate
+	}
+}
+
+func withStandInUnixSocket(path string) standInOption {
+	return func(s *samStandIn) {
+		s.unixSocket = path
+	}
+}
+
+// withStandInLink carries streams over link instead of piping them straight
+// through
+func withStandInLink(link standInLink) standInOption {
+	return func(s *samStandIn) {
+		s.link = link
+	}
+}
+
+func newSAMStandIn(t testing.TB, opts ...standInOption) *samStandIn {
+	t.Helper()
+	s := &samStandIn{
+		version:       samMaxVersion,
+		acceptTimeout: 5 * time.Second,
+		sessions:      make(map[string]*standInSession),
+		destinations:  make(map[string]*standInDestination),
+		conns:         make(map[net.Conn]struct{}),
+		done:          make(chan struct{}),
+		faultsChanged: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	var err error
+	if s.unixSocket != "" {
+		s.listener, err = net.Listen("unix", s.unixSocket)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	require.NoError(t, err)
+	if s.certificate != nil {
+		s.listener = tls.NewListener(s.listener, &tls.Config{Certificates: []tls.Certificate{*s.certificate}})
+	}
+
+	go s.acceptLoop()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// config returns the SAM configuration for reaching the stand-in. With TLS
+// the stand-in's certificate is pinned.
+func (s *samStandIn) config() SAMConfig {
+	config := SAMConfig{
+		User:           s.user,
+		Password:       s.password,
+		ConnectTimeout: 5 * time.Second,
+	}
+	if s.unixSocket != "" {
+		config.UnixSocket = s.unixSocket
+	} else {
+		addr := s.listener.Addr().(*net.TCPAddr)
+		config.Host = addr.IP.String()
+		config.Port = addr.Port
+	}
+	if s.certificate != nil {
+		config.TLS = &tls.Config{}
+		config.TLSPins = []string{CertificatePin(s.certificate.Leaf)}
+	}
+	return config
+}
+
+func (s *samStandIn) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// streamConnects returns the number of STREAM CONNECTs received so far
+func (s *samStandIn) streamConnects() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connects
+}
+
+// namingLookups returns the names looked up so far
+func (s *samStandIn) namingLookups() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.lookups...)
+}
+
+// refuseSessions makes SESSION CREATE fail for the lines refuse returns true
+// for, nil accepts them all again
+func (s *samStandIn) refuseSessions(refuse func(line string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refuse = refuse
+}
+
+// createdSessions returns the SESSION CREATE lines received so far
+func (s *samStandIn) createdSessions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.created...)
+}
+
+func (s *samStandIn) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.serve(conn)
+	}
+}
+
+func (s *samStandIn) forget(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+func (s *samStandIn) serve(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	reply := func(line string) bool {
+		_, err := io.WriteString(conn, line+"\n")
+		return err == nil
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		s.forget(conn)
+		return
+	}
+	if response, ok := s.hello(line); !reply(response) || !ok {
+		conn.Close()
+		s.forget(conn)
+		return
+	}
+
+	var owned []string
+	defer func() {
+		s.closeSessions(owned)
+	}()
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			s.forget(conn)
+			return
+		}
+		if ping, ok := strings.CutPrefix(strings.TrimSpace(line), "PING"); ok {
+			reply("PONG" + ping)
+			continue
+		}
+		command, err := parseSAMReply(line)
+		if err != nil {
+			reply("ERROR RESULT=I2P_ERROR MESSAGE=" + samQuote(err.Error()))
+			continue
+		}
+
+		switch command.topic + " " + command.kind {
+		case "DEST GENERATE":
+			reply(s.generate(command))
+		case "SESSION CREATE":
+			response, id := s.createSession(conn, line, command)
+			if id != "" {
+				owned = append(owned, id)
+			}
+			reply(response)
+		case "SESSION ADD":
+			response, id := s.addSession(owned, command)
+			if id != "" {
+				owned = append(owned, id)
+			}
+			reply(response)
+		case "STREAM CONNECT":
+			// the connection carries the stream from here on
+			s.connect(conn, reader, command)
+			return
+		case "STREAM ACCEPT":
+			s.accept(conn, reader, command)
+			return
+		case "NAMING LOOKUP":
+			reply(s.lookup(command))
+		default:
+			reply(command.topic + " STATUS RESULT=I2P_ERROR MESSAGE=" + samQuote("unsupported command "+command.topic+" "+command.kind))
+		}
+	}
+}
+
+func (s *samStandIn) hello(line string) (string, bool) {
+	command, err := parseSAMReply(line)
+	if err != nil || command.topic != "HELLO" || command.kind != "VERSION" {
+		return "HELLO REPLY RESULT=I2P_ERROR MESSAGE=\"HELLO expected\"", false
+	}
+
+	version := mustParseSAMVersion(s.version)
+	if min, ok := command.values["MIN"]; ok {
+		if parsed, err := parseSAMVersion(min); err != nil || samVersionLess(version, parsed) {
+			return "HELLO REPLY RESULT=NOVERSION", false
+		}
+	}
+	if max, ok := command.values["MAX"]; ok {
+		if parsed, err := parseSAMVersion(max); err != nil || samVersionLess(parsed, version) {
+			return "HELLO REPLY RESULT=NOVERSION", false
+		}
+	}
+
+	if s.user != "" {
+		user, hasUser := command.values["USER"]
+		password, hasPassword := command.values["PASSWORD"]
+		if !hasUser || !hasPassword {
+			return "HELLO REPLY RESULT=I2P_ERROR MESSAGE=\"USER and PASSWORD required\"", false
+		}
+		if user != s.user || password != s.password {
+			return "HELLO REPLY RESULT=I2P_ERROR MESSAGE=\"Authorization failed\"", false
+		}
+	}
+	return "HELLO REPLY RESULT=OK VERSION=" + s.version, true
+}
+
+// newStandInKeys makes Ed25519 keys the same way DeriveKeys lays them out
+func newStandInKeys() (i2pkeys.I2PKeys, error) {
+	identity, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	return DeriveKeys(identity)
+}
+
+func (s *samStandIn) generate(command *samReply) string {
+	if sigType, ok := command.values["SIGNATURE_TYPE"]; ok && sigType != SignatureEd25519.String() {
+		return "DEST REPLY RESULT=I2P_ERROR MESSAGE=\"only EdDSA_SHA512_Ed25519 is supported\""
+	}
+	keys, err := newStandInKeys()
+	if err != nil {
+		return "DEST REPLY RESULT=I2P_ERROR MESSAGE=" + samQuote(err.Error())
+	}
+	return "DEST REPLY PUB=" + string(keys.Addr()) + " PRIV=" + keys.String()
+}
+
+func (s *samStandIn) createSession(conn net.Conn, line string, command *samReply) (string, string) {
+	id := command.values["ID"]
+	private := command.values["DESTINATION"]
+	if id == "" || private == "" {
+		return "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"ID and DESTINATION are required\"", ""
+	}
+
+	if private == "TRANSIENT" {
+		keys, err := newStandInKeys()
+		if err != nil {
+			return "SESSION STATUS RESULT=I2P_ERROR MESSAGE=" + samQuote(err.Error()), ""
+		}
+		private = keys.String()
+	}
+	parsed, err := parsePrivateKeys(i2pkeys.NewKeys("", private))
+	if err != nil {
+		return "SESSION STATUS RESULT=INVALID_KEY MESSAGE=" + samQuote(err.Error()), ""
+	}
+	dest := i2pB64Encoding.EncodeToString(parsed.dest.raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.created = append(s.created, strings.TrimSpace(line))
+	if s.refuse != nil && s.refuse(line) {
+		return "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"session refused\"", ""
+	}
+	if _, exists := s.sessions[id]; exists {
+		return "SESSION STATUS RESULT=DUPLICATED_ID", ""
+	}
+	if _, exists := s.destinations[dest]; exists {
+		return "SESSION STATUS RESULT=DUPLICATED_DEST", ""
+	}
+
+	destination := &standInDestination{
+		dest:     dest,
+		b32:      i2pkeys.I2PAddr(dest).Base32(),
+		sessions: 1,
+		queued:   make(chan struct{}),
+	}
+	s.destinations[dest] = destination
+	s.sessions[id] = &standInSession{id: id, dest: destination, conn: conn}
+	return "SESSION STATUS RESULT=OK DESTINATION=" + private, id
+}
+
+func (s *samStandIn) addSession(owned []string, command *samReply) (string, string) {
+	id := command.values["ID"]
+	if len(owned) == 0 {
+		return "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"no primary session\"", ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.sessions[id]; exists || id == "" {
+		return "SESSION STATUS RESULT=DUPLICATED_ID", ""
+	}
+	primary := s.sessions[owned[0]]
+	primary.dest.sessions++
+	s.sessions[id] = &standInSession{id: id, dest: primary.dest, conn: primary.conn}
+	return "SESSION STATUS RESULT=OK ID=" + id, id
+}
+
+// closeSessions drops the sessions of a closed control connection, along
+// with the STREAM ACCEPTs pending on their destinations
+func (s *samStandIn) closeSessions(ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		session, ok := s.sessions[id]
+		if !ok {
+			continue
+		}
+		delete(s.sessions, id)
+		session.dest.sessions--
+		if session.dest.sessions == 0 {
+			delete(s.destinations, session.dest.dest)
+			for _, pending := range session.dest.accepts {
+				pending.conn.Close()
+			}
+			session.dest.accepts = nil
+		}
+	}
+}
+
+func (s *samStandIn) resolve(name string) *standInDestination {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if destination, ok := s.destinations[name]; ok {
+		return destination
+	}
+	for _, destination := range s.destinations {
+		if destination.b32 == name {
+			return destination
+		}
+	}
+	return nil
+}
+
+func (s *samStandIn) lookup(command *samReply) string {
+	name := command.values["NAME"]
+	s.mu.Lock()
+	s.lookups = append(s.lookups, name)
+	s.mu.Unlock()
+
+	destination := s.resolve(name)
+	if destination != nil {
+		select {
+		case <-time.After(s.faults(destination).lookupDelay):
+		case <-s.done:
+		}
+	}
+	if destination == nil || s.faults(destination).lookupFailure {
+		return "NAMING REPLY RESULT=KEY_NOT_FOUND NAME=" + name
+	}
+	return "NAMING REPLY RESULT=OK NAME=" + name + " VALUE=" + destination.dest
+}
+
+func (s *samStandIn) session(id string) *standInSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
+
+func (s *samStandIn) accept(conn net.Conn, reader *bufio.Reader, command *samReply) {
+	session := s.session(command.values["ID"])
+	if session == nil {
+		io.WriteString(conn, "STREAM STATUS RESULT=INVALID_ID\n")
+		conn.Close()
+		s.forget(conn)
+		return
+	}
+	if _, err := io.WriteString(conn, "STREAM STATUS RESULT=OK\n"); err != nil {
+		conn.Close()
+		s.forget(conn)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session.dest.accepts = append(session.dest.accepts, &standInAccept{conn: conn, reader: reader})
+	close(session.dest.queued)
+	session.dest.queued = make(chan struct{})
+}
+
+// takeAccept waits for a STREAM ACCEPT pending on destination
+func (s *samStandIn) takeAccept(destination *standInDestination, timeout time.Duration) *standInAccept {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.mu.Lock()
+		if len(destination.accepts) > 0 {
+			pending := destination.accepts[0]
+			destination.accepts = destination.accepts[1:]
+			s.mu.Unlock()
+			return pending
+		}
+		queued := destination.queued
+		s.mu.Unlock()
+
+		select {
+		case <-queued:
+		case <-deadline.C:
+			return nil
+		}
+	}
+}
+
+func (s *samStandIn) connect(conn net.Conn, reader *bufio.Reader, command *samReply) {
+	s.mu.Lock()
+	s.connects++
+	s.mu.Unlock()
+
+	fail := func(result string) {
+		io.WriteString(conn, "STREAM STATUS RESULT="+result+"\n")
+		conn.Close()
+		s.forget(conn)
+	}
+
+	session := s.session(command.values["ID"])
+	if session == nil {
+		fail("INVALID_ID")
+		return
+	}
+	target := s.resolve(command.values["DESTINATION"])
+	if target == nil {
+		fail("CANT_REACH_PEER MESSAGE=\"unknown destination\"")
+		return
+	}
+	faults := s.faults(target)
+	switch {
+	case faults.lookupFailure:
+		fail("CANT_REACH_PEER MESSAGE=\"LeaseSet not found\"")
+		return
+	case faults.connectResult != "":
+		fail(faults.connectResult)
+		return
+	}
+
+	for {
+		pending := s.takeAccept(target, s.acceptTimeout)
+		if pending == nil {
+			fail("CANT_REACH_PEER MESSAGE=\"no STREAM ACCEPT pending\"")
+			return
+		}
+		// the SYN and its reply each cross the tunnels
+		delay := s.link.latency + s.faults(session.dest, target).latency
+		time.Sleep(delay + faults.acceptDelay)
+		// the accepting side may have given up in the meantime
+		if _, err := io.WriteString(pending.conn, session.dest.dest+" FROM_PORT=0 TO_PORT=0\n"); err != nil {
+			pending.conn.Close()
+			s.forget(pending.conn)
+			continue
+		}
+		time.Sleep(delay)
+		if _, err := io.WriteString(conn, "STREAM STATUS RESULT=OK\n"); err != nil {
+			pending.conn.Close()
+			s.forget(pending.conn)
+			conn.Close()
+			s.forget(conn)
+			return
+		}
+		s.pipe(&standInStream{a: conn, b: pending.conn, ends: []*standInDestination{session.dest, target}}, reader, pending.reader)
+		return
+	}
+}
+
+// standInStream is a stream piped between two destinations
+type standInStream struct {
+	a, b net.Conn
+	ends []*standInDestination
+	// bytes that crossed the stream in either direction
+	crossed atomic.Int64
+}
+
+// reset closes both ends of the stream with a TCP RST where possible, as
+// the router does when it gives up on a stream
+func (st *standInStream) reset() {
+	for _, conn := range []net.Conn{st.a, st.b} {
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0)
+		}
+		conn.Close()
+	}
+}
+
+// pipe copies between the two ends of a stream until both directions are
+// done, passing on half closes
+func (s *samStandIn) pipe(stream *standInStream, aReader, bReader *bufio.Reader) {
+	var wg sync.WaitGroup
+	copyHalf := func(dst net.Conn, src io.Reader) {
+		defer wg.Done()
+		s.carry(stream, dst, src)
+		if halfCloser, ok := dst.(interface{ CloseWrite() error }); ok {
+			halfCloser.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	wg.Add(2)
+	go copyHalf(stream.b, aReader)
+	go copyHalf(stream.a, bReader)
+	go func() {
+		wg.Wait()
+		stream.a.Close()
+		stream.b.Close()
+		s.forget(stream.a)
+		s.forget(stream.b)
+	}()
+}
+
+// standInLink emulates the tunnels between two destinations. The zero value
+// is a direct pipe.
+type standInLink struct {
+	// one way delay, added to each chunk of a stream and twice to STREAM
+	// CONNECT
+	latency time.Duration
+	// bytes per second in each direction of a stream, 0 for no limit
+	bandwidth int64
+}
+
+// standInFaults are failures injected on the streams of a destination, see
+// samStandIn.inject. The zero value injects none.
+type standInFaults struct {
+	// added to the link's latency for the destination's streams and STREAM
+	// CONNECTs, plus a random share of jitter for each chunk of data
+	latency time.Duration
+	jitter  time.Duration
+	// holds back the data of the destination's streams without closing
+	// them, until the fault is cleared
+	stall bool
+	// resets the destination's streams at the first chunk of data after
+	// resetAfter bytes crossed them, 1 resets established streams as soon
+	// as they carry data
+	resetAfter int64
+	// NAMING LOOKUP and STREAM CONNECT cannot find the destination, as when
+	// its LeaseSet expired
+	lookupFailure bool
+	// holds back the NAMING REPLY for the destination this long, as while
+	// the router searches the network database
+	lookupDelay time.Duration
+	// STREAM CONNECTs to the destination fail with this result, e.g.
+	// TIMEOUT while its tunnels are rebuilt
+	connectResult string
+	// holds back STREAM CONNECTs to the destination this long before its
+	// pending STREAM ACCEPT receives them
+	acceptDelay time.Duration
+}
+
+// inject sets the faults of the destination addr names, replacing those set
+// before. Streams already open see the change with their next chunk of data.
+func (s *samStandIn) inject(t testing.TB, addr ma.Multiaddr, faults standInFaults) {
+	t.Helper()
+	name, err := MultiAddrToI2PAddr(addr)
+	require.NoError(t, err)
+	destination := s.resolve(name)
+	require.NotNil(t, destination, "no session for %s", addr)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	destination.faults = faults
+	close(s.faultsChanged)
+	s.faultsChanged = make(chan struct{})
+}
+
+// faults returns the faults of the destinations combined
+func (s *samStandIn) faults(destinations ...*standInDestination) standInFaults {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var combined standInFaults
+	for _, destination := range destinations {
+		faults := destination.faults
+		combined.latency += faults.latency
+		combined.jitter += faults.jitter
+		combined.stall = combined.stall || faults.stall
+		if faults.resetAfter > 0 && (combined.resetAfter == 0 || faults.resetAfter < combined.resetAfter) {
+			combined.resetAfter = faults.resetAfter
+		}
+		combined.lookupFailure = combined.lookupFailure || faults.lookupFailure
+		combined.lookupDelay += faults.lookupDelay
+		if combined.connectResult == "" {
+			combined.connectResult = faults.connectResult
+		}
+		combined.acceptDelay += faults.acceptDelay
+	}
+	return combined
+}
+
+// waitWhileStalled blocks while the stream's data is held back, it reports
+// false when the stand-in closed in the meantime
+func (s *samStandIn) waitWhileStalled(stream *standInStream) bool {
+	for {
+		s.mu.Lock()
+		changed := s.faultsChanged
+		s.mu.Unlock()
+		if !s.faults(stream.ends...).stall {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-s.done:
+			return false
+		}
+	}
+}
+
+// carry copies src to dst until src is done, delivering each chunk read
+// after the latency of the link and the stream's faults and no faster than
+// the link's bandwidth allows
+func (s *samStandIn) carry(stream *standInStream, dst io.Writer, src io.Reader) {
+	type chunk struct {
+		data []byte
+		due  time.Time
+	}
+	// bounds how much is in flight, like the streaming library's window
+	chunks := make(chan chunk, 64)
+	go func() {
+		defer close(chunks)
+		// when the link is done sending what has been read so far, and when
+		// the last chunk is due, jitter does not reorder data
+		var sent, due time.Time
+		buf := make([]byte, 16<<10)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				now := time.Now()
+				if sent.Before(now) {
+					sent = now
+				}
+				if s.link.bandwidth > 0 {
+					sent = sent.Add(time.Duration(int64(n) * int64(time.Second) / s.link.bandwidth))
+				}
+				faults := s.faults(stream.ends...)
+				delay := s.link.latency + faults.latency
+				if faults.jitter > 0 {
+					delay += rand.N(faults.jitter)
+				}
+				if next := sent.Add(delay); next.After(due) {
+					due = next
+				}
+				chunks <- chunk{data: append([]byte(nil), buf[:n]...), due: due}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	// lets the reader run until src is closed
+	defer func() {
+		go func() {
+			for range chunks {
+			}
+		}()
+	}()
+
+	for c := range chunks {
+		time.Sleep(time.Until(c.due))
+		if !s.waitWhileStalled(stream) {
+			return
+		}
+		if resetAfter := s.faults(stream.ends...).resetAfter; resetAfter > 0 && stream.crossed.Load() >= resetAfter {
+			stream.reset()
+			return
+		}
+		if _, err := dst.Write(c.data); err != nil {
+			return
+		}
+		stream.crossed.Add(int64(len(c.data)))
+	}
+}
+
+// newStandInTransport builds a transport on the stand-in with a fresh
+// destination and an insecure upgrader, and returns it with its peer ID
+func newStandInTransport(t testing.TB, standIn *samStandIn, opts ...Option) (*I2PTransport, peer.ID) {
+	t.Helper()
+	config := standIn.config()
+	keys, err := GenerateKeysWithConfig(context.Background(), config, DefaultKeyTypes)
+	require.NoError(t, err)
+
+	opts = append([]Option{WithSAMConfig(config)}, opts...)
+	builder, _, err := I2PTransportBuilder(nil, keys, "0", int(time.Now().UnixNano()), opts...)
+	require.NoError(t, err)
+
+	peerID, sm := makeInsecureMuxer(t)
+	// dials all come from the stand-in, the benchmarks would trip the
+	// default connection rate limit
+	rcmgr, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(rcmgr.InfiniteLimits), rcmgr.WithConnRateLimiters(&rate.Limiter{}))
+	require.NoError(t, err)
+	upg, err := upgrader.New(
+		[]sec.SecureTransport{sm},
+		[]upgrader.StreamMuxer{{
+			ID:    yamux.ID,
+			Muxer: yamux.DefaultTransport,
+		}},
+		nil,
+		rcmgr,
+		nil,
+	)
+	require.NoError(t, err)
+
+	transport, err := builder(upg, rcmgr)
+	require.NoError(t, err)
+	t.Cleanup(transport.Close)
+	return transport, peerID
+}
+
+// standInListenAddr returns the multiaddr a stand-in transport listens on
+func standInListenAddr(t testing.TB, transport *I2PTransport) ma.Multiaddr {
+	t.Helper()
+	transport.mu.RLock()
+	defer transport.mu.RUnlock()
+	return transport.listenAddr
+}
+
+// requireStandInEcho connects two transports on the stand-in and checks that
+// a stream echoes back what was written to it
+func requireStandInEcho(t *testing.T, standIn *samStandIn) {
+	t.Helper()
+	server, serverID := newStandInTransport(t, standIn)
+	client, _ := newStandInTransport(t, standIn)
+
+	listener, err := server.Listen(standInListenAddr(t, server))
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveEcho(listener)
+
+	requireEcho(t, client, listener.Multiaddr(), serverID)
+}
+
+// serveEcho echoes the streams of each accepted connection
+func serveEcho(listener transport.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for {
+				stream, err := conn.AcceptStream()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(stream, stream)
+					stream.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// requireEcho dials addr and checks a stream is echoed back
+func requireEcho(t *testing.T, client *I2PTransport, addr ma.Multiaddr, serverID peer.ID) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, addr, serverID)
+	require.NoError(t, err)
+	defer conn.Close()
+	stream, err := conn.OpenStream(ctx)
+	require.NoError(t, err)
+	_, err = stream.Write([]byte("Hello!"))
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseWrite())
+	echoed, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	require.Equal(t, "Hello!", string(echoed))
+}
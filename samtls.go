@@ -0,0 +1,83 @@
+package i2p
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const samPinPrefix = "sha256/"
+
+// CertificatePin returns the pin of a certificate for SAMConfig.TLSPins, the
+// base64 SHA-256 of its public key in the "sha256/<base64>" form also used by
+// HPKP. Pinning the key rather than the certificate lets the router renew its
+// certificate without breaking the pin as long as it keeps the key.
+func CertificatePin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return samPinPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func parseCertificatePin(pin string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(pin, samPinPrefix)
+	if !ok {
+		return nil, fmt.Errorf("certificate pin %q does not start with %q", pin, samPinPrefix)
+	}
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("certificate pin %q is not a base64 SHA-256 hash", pin)
+	}
+	return sum, nil
+}
+
+// tlsConfig returns the TLS configuration for SAM connections, nil when TLS
+// is not enabled. Pins are checked on top of the usual verification. Without
+// RootCAs a matching pin alone authenticates the bridge, which lets routers
+// use self-signed certificates.
+func (c SAMConfig) tlsConfig() (*tls.Config, error) {
+	if c.TLS == nil {
+		return nil, nil
+	}
+	config := c.TLS.Clone()
+	if config.ServerName == "" && c.UnixSocket == "" {
+		config.ServerName = c.Host
+	}
+	if len(c.TLSPins) == 0 {
+		return config, nil
+	}
+
+	pins := make([][]byte, len(c.TLSPins))
+	for i, pin := range c.TLSPins {
+		sum, err := parseCertificatePin(pin)
+		if err != nil {
+			return nil, err
+		}
+		pins[i] = sum
+	}
+	if config.RootCAs == nil {
+		config.InsecureSkipVerify = true
+	}
+	verify := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(state); err != nil {
+				return err
+			}
+		}
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("SAM bridge presented no certificate")
+		}
+		sum := sha256.Sum256(state.PeerCertificates[0].RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(pin, sum[:]) {
+				return nil
+			}
+		}
+		return fmt.Errorf("SAM bridge certificate %s matches none of the pinned keys", CertificatePin(state.PeerCertificates[0]))
+	}
+	return config, nil
+}
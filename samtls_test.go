@@ -0,0 +1,101 @@
+package i2p
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCertificate returns a self-signed certificate for 127.0.0.1
+func newTestCertificate(t testing.TB) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "SAM stand-in"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestSAMOverTLS(t *testing.T) {
+	certificate := newTestCertificate(t)
+	standIn := newSAMStandIn(t, withStandInTLS(certificate))
+	ctx := context.Background()
+
+	conn, err := dialSAM(ctx, standIn.config())
+	require.NoError(t, err)
+	_, ok := conn.Conn.(*tls.Conn)
+	assert.True(t, ok)
+	conn.Close()
+
+	// a self-signed certificate is only accepted when pinned
+	unpinned := standIn.config()
+	unpinned.TLSPins = nil
+	_, err = dialSAM(ctx, unpinned)
+	assert.Error(t, err)
+
+	wrongPin := standIn.config()
+	wrongPin.TLSPins = []string{CertificatePin(newTestCertificate(t).Leaf)}
+	_, err = dialSAM(ctx, wrongPin)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pinned")
+
+	// with RootCAs the chain is verified as well as the pin
+	trusted := standIn.config()
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate.Leaf)
+	trusted.TLS = &tls.Config{RootCAs: roots}
+	conn, err = dialSAM(ctx, trusted)
+	require.NoError(t, err)
+	conn.Close()
+
+	plaintext := standIn.config()
+	plaintext.TLS, plaintext.TLSPins = nil, nil
+	plaintext.ConnectTimeout = time.Second
+	_, err = dialSAM(ctx, plaintext)
+	assert.Error(t, err)
+}
+
+func TestSAMTLSConfigValidate(t *testing.T) {
+	pin := CertificatePin(newTestCertificate(t).Leaf)
+	assert.NoError(t, SAMConfig{TLS: &tls.Config{}, TLSPins: []string{pin}}.validate())
+	assert.Error(t, SAMConfig{TLSPins: []string{pin}}.validate(), "pins need TLS")
+	assert.Error(t, SAMConfig{TLS: &tls.Config{}, TLSPins: []string{"sha256/short"}}.validate())
+	assert.Error(t, SAMConfig{TLS: &tls.Config{}, TLSPins: []string{"md5/" + pin[len("sha256/"):]}}.validate())
+}
+
+func TestTransportOverSAMTLS(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInTLS(newTestCertificate(t)))
+	requireStandInEcho(t, standIn)
+}
+
+func TestTransportOverSAMUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sam.sock")
+	standIn := newSAMStandIn(t, withStandInUnixSocket(path))
+	assert.Equal(t, path, standIn.config().Address())
+	requireStandInEcho(t, standIn)
+}
@@ -20,20 +20,23 @@ Write-Host "Containers started. Waiting for I2P routers to be ready..."
 Write-Host "This can take 5-15 minutes for initial integration."
 Write-Host ""
 
-# Wait for both nodes to be ready
+$node1Port = (docker port i2p-node1 7656) -replace '.*:', ''
+$node2Port = (docker port i2p-node2 7656) -replace '.*:', ''
+
+# Wait for both nodes to be ready, see cmd/i2pready for the exit codes
 Write-Host "=== Checking Node 1 ===" -ForegroundColor Cyan
-& "$scriptDir\check_i2p_ready.ps1" -ContainerName "i2p-node1" -MinNetDbSize 200 -TimeoutSeconds 3600
+go run ./cmd/i2pready -sam "127.0.0.1:$node1Port" -timeout 60m
+if ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE }
 
 Write-Host ""
 Write-Host "=== Checking Node 2 ===" -ForegroundColor Cyan
-& "$scriptDir\check_i2p_ready.ps1" -ContainerName "i2p-node2" -MinNetDbSize 200 -TimeoutSeconds 3600
+go run ./cmd/i2pready -sam "127.0.0.1:$node2Port" -timeout 60m
+if ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE }
 
 Write-Host ""
 Write-Host "=== All I2P Instances Ready! ===" -ForegroundColor Green
 Write-Host ""
 Write-Host "SAM Addresses:"
-$node1Port = (docker port i2p-node1 7656) -replace '.*:', ''
-$node2Port = (docker port i2p-node2 7656) -replace '.*:', ''
 Write-Host "  Node 1: 127.0.0.1:$node1Port"
 Write-Host "  Node 2: 127.0.0.1:$node2Port"
 Write-Host ""
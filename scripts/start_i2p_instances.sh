@@ -21,20 +21,21 @@ echo "Containers started. Waiting for I2P routers to be ready..."
 echo "This can take 5-15 minutes for initial integration."
 echo ""
 
-# Wait for both nodes to be ready
+NODE1_PORT=$(docker port i2p-node1 7656 | head -1 | cut -d: -f2)
+NODE2_PORT=$(docker port i2p-node2 7656 | head -1 | cut -d: -f2)
+
+# Wait for both nodes to be ready, see cmd/i2pready for the exit codes
 echo "=== Checking Node 1 ==="
-bash "$SCRIPT_DIR/check_i2p_ready.sh" i2p-node1 200 3600
+go run ./cmd/i2pready -sam "127.0.0.1:$NODE1_PORT" -timeout 60m
 
 echo ""
 echo "=== Checking Node 2 ==="
-bash "$SCRIPT_DIR/check_i2p_ready.sh" i2p-node2 200 3600
+go run ./cmd/i2pready -sam "127.0.0.1:$NODE2_PORT" -timeout 60m
 
 echo ""
 echo "=== All I2P Instances Ready! ==="
 echo ""
 echo "SAM Addresses:"
-NODE1_PORT=$(docker port i2p-node1 7656 | cut -d: -f2)
-NODE2_PORT=$(docker port i2p-node2 7656 | cut -d: -f2)
 echo "  Node 1: 127.0.0.1:$NODE1_PORT"
 echo "  Node 2: 127.0.0.1:$NODE2_PORT"
 echo ""
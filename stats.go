@@ -0,0 +1,130 @@
+package i2p
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/transport"
+)
+
+// ConnectionStats describes an I2P connection, see Connection.Stats
+type ConnectionStats struct {
+	Direction network.Direction
+
+	// Subsession is the ID of the SAM session the stream was opened on. It
+	// is empty for connections not created by an I2PTransport.
+	Subsession string
+
+	Opened time.Time
+
+	// DialDuration is how long opening the stream took, including retries
+	// and waiting for a concurrent dial to the same destination. It is zero
+	// for inbound connections.
+	DialDuration time.Duration
+
+	// bytes passed through the connection, which includes the overhead of
+	// the security protocol and the muxer above
+	BytesSent     uint64
+	BytesReceived uint64
+
+	// LastActivity is when data was last sent or received, Opened before
+	// that
+	LastActivity time.Time
+}
+
+// Stats returns the connection's statistics so far
+func (c *Connection) Stats() ConnectionStats {
+	return ConnectionStats{
+		Direction:     c.direction,
+		Subsession:    c.subsession,
+		Opened:        c.opened,
+		DialDuration:  c.dialDuration,
+		BytesSent:     c.bytesSent.Load(),
+		BytesReceived: c.bytesReceived.Load(),
+		LastActivity:  time.Unix(0, c.lastActivity.Load()),
+	}
+}
+
+// connectionKey is the key of the Connection in the Extra statistics of the
+// connection upgraded from it
+type connectionKey struct{}
+
+// Stat reports the direction and opening time to the upgrader, which hands
+// them on to the upgraded connection. The Connection itself goes along under
+// connectionKey, which is how upgradedListener finds it again.
+func (c *Connection) Stat() network.ConnStats {
+	return network.ConnStats{Stats: network.Stats{
+		Direction: c.direction,
+		Opened:    c.opened,
+		Extra:     map[any]any{connectionKey{}: c},
+	}}
+}
+
+func (c *Connection) recordSent(n int) {
+	if n > 0 {
+		c.bytesSent.Add(uint64(n))
+		c.lastActivity.Store(time.Now().UnixNano())
+	}
+}
+
+func (c *Connection) recordReceived(n int) {
+	if n > 0 {
+		c.bytesReceived.Add(uint64(n))
+		c.lastActivity.Store(time.Now().UnixNano())
+	}
+}
+
+// StatsOf returns the statistics of the I2P connection under conn, which is
+// a connection upgraded by the transport or a network.Conn of a host using
+// it. ok is false for connections of other transports.
+func StatsOf(conn interface{ As(target any) bool }) (stats ConnectionStats, ok bool) {
+	var c *Connection
+	if !conn.As(&c) {
+		return ConnectionStats{}, false
+	}
+	return c.Stats(), true
+}
+
+// capableConn lets As reach the Connection under an upgraded connection
+type capableConn struct {
+	transport.CapableConn
+	conn *Connection
+}
+
+func (c *capableConn) As(target any) bool {
+	if t, ok := target.(**Connection); ok {
+		*t = c.conn
+		return true
+	}
+	return c.CapableConn.As(target)
+}
+
+// Stat passes on the statistics of the upgraded connection, which the swarm
+// looks for
+func (c *capableConn) Stat() network.ConnStats {
+	if stat, ok := c.CapableConn.(network.ConnStat); ok {
+		return stat.Stat()
+	}
+	return c.conn.Stat()
+}
+
+// upgradedListener lets As reach the Connection under accepted connections
+type upgradedListener struct {
+	transport.Listener
+}
+
+func (l *upgradedListener) Accept() (transport.CapableConn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	stat, ok := conn.(network.ConnStat)
+	if !ok {
+		return conn, nil
+	}
+	if c, ok := stat.Stat().Extra[connectionKey{}].(*Connection); ok {
+		c.endAccept(nil)
+		return &capableConn{CapableConn: conn, conn: c}, nil
+	}
+	return conn, nil
+}
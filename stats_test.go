@@ -0,0 +1,130 @@
+package i2p
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionStats(t *testing.T) {
+	conn, remote := newTestConnection(t)
+	stats := conn.Stats()
+	assert.Zero(t, stats.BytesSent)
+	assert.Zero(t, stats.BytesReceived)
+	assert.True(t, stats.LastActivity.Equal(stats.Opened))
+	assert.Equal(t, network.DirUnknown, stats.Direction)
+
+	go remote.Write([]byte("pong"))
+	_, err := io.ReadFull(conn, make([]byte, 4))
+	require.NoError(t, err)
+	go io.ReadFull(remote, make([]byte, 5))
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	stats = conn.Stats()
+	assert.EqualValues(t, 5, stats.BytesSent)
+	assert.EqualValues(t, 4, stats.BytesReceived)
+	assert.True(t, stats.LastActivity.After(stats.Opened))
+}
+
+func TestTransportConnectionStats(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	server, serverID := newStandInTransport(t, standIn)
+	client, _ := newStandInTransport(t, standIn)
+
+	listener, err := server.Listen(standInListenAddr(t, server))
+	require.NoError(t, err)
+	defer listener.Close()
+	accepted := make(chan network.MuxedConn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn
+		stream, err := conn.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(stream, stream)
+		stream.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, listener.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer conn.Close()
+	stream, err := conn.OpenStream(ctx)
+	require.NoError(t, err)
+	_, err = stream.Write([]byte("Hello!"))
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseWrite())
+	_, err = io.ReadAll(stream)
+	require.NoError(t, err)
+
+	stats, ok := StatsOf(conn)
+	require.True(t, ok)
+	assert.Equal(t, network.DirOutbound, stats.Direction)
+	assert.True(t, strings.HasPrefix(stats.Subsession, "outboundSession-"), stats.Subsession)
+	assert.Positive(t, stats.DialDuration)
+	assert.Greater(t, stats.BytesSent, uint64(len("Hello!")))
+	assert.Greater(t, stats.BytesReceived, uint64(len("Hello!")))
+	assert.False(t, stats.LastActivity.Before(stats.Opened))
+	stat, ok := conn.(network.ConnStat)
+	require.True(t, ok, "the swarm reads the upgraded connection's statistics")
+	assert.Equal(t, network.DirOutbound, stat.Stat().Direction, "the upgrader picks up the direction")
+
+	var inbound network.MuxedConn
+	select {
+	case inbound = <-accepted:
+	case <-ctx.Done():
+		t.Fatal("connection was not accepted")
+	}
+	var raw *Connection
+	require.True(t, inbound.As(&raw))
+	stats = raw.Stats()
+	assert.Equal(t, network.DirInbound, stats.Direction)
+	assert.True(t, strings.HasPrefix(stats.Subsession, "inboundSession-"), stats.Subsession)
+	assert.Zero(t, stats.DialDuration)
+	assert.Positive(t, stats.BytesReceived)
+
+	// connections of other transports have no Connection underneath
+	_, ok = StatsOf(fakeConn{})
+	assert.False(t, ok)
+}
+
+func TestListenerCloseReleasesAccept(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	server, _ := newStandInTransport(t, standIn)
+	listener, err := server.Listen(standInListenAddr(t, server))
+	require.NoError(t, err)
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := listener.Accept()
+		accepted <- err
+	}()
+	require.NoError(t, listener.Close())
+	select {
+	case err := <-accepted:
+		// the upgrader's listener reports closing with an error of its own
+		// when Close stops its accept loop first
+		assert.ErrorContains(t, err, "listener closed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+}
+
+// fakeConn is a connection of some other transport
+type fakeConn struct{}
+
+func (fakeConn) As(any) bool {
+	return false
+}
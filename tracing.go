@@ -0,0 +1,146 @@
+package i2p
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "banyan/transports/i2p"
+
+// errClosedBeforeUpgrade ends the spans of an accepted connection the
+// upgrader gave up on
+var errClosedBeforeUpgrade = errors.New("connection closed before the upgrade completed")
+
+type tracingSetting struct {
+	destinations bool
+}
+
+type TracingOption func(*tracingSetting)
+
+// WithDestinationsInSpans records I2P destinations in span attributes and
+// errors. By default only a hash of a destination is recorded, which tells
+// dials to the same destination apart without revealing it.
+func WithDestinationsInSpans() TracingOption {
+	return func(s *tracingSetting) {
+		s.destinations = true
+	}
+}
+
+// WithTracing records OpenTelemetry spans with provider for the phases of
+// Dial and of accepting connections:
+//
+//   - i2p.dial, with the children i2p.dial.session while a session for a
+//     blinded destination is created, i2p.dial.connect for STREAM CONNECT
+//     and i2p.dial.upgrade for the libp2p upgrade
+//   - i2p.accept, from a peer's stream arriving until the upgraded
+//     connection is handed out, with the child i2p.accept.upgrade
+//
+// The router looks up the LeaseSet and runs the streaming handshake within
+// one STREAM CONNECT, so i2p.dial.connect covers both. Its events record
+// each failed attempt, see WithDialRetry. Prefetch takes the lookup out of
+// the dial.
+func WithTracing(provider trace.TracerProvider, opts ...TracingOption) Option {
+	return func(i2p *I2PTransport) error {
+		if provider == nil {
+			return errors.New("tracing needs a tracer provider")
+		}
+		setting := &tracingSetting{}
+		for _, opt := range opts {
+			opt(setting)
+		}
+		i2p.tracing = &tracing{
+			tracer:       provider.Tracer(tracerName),
+			destinations: setting.destinations,
+		}
+		return nil
+	}
+}
+
+type tracing struct {
+	tracer       trace.Tracer
+	destinations bool
+}
+
+// disabledTracing records nothing, it is used until WithTracing
+var disabledTracing = &tracing{tracer: noop.NewTracerProvider().Tracer(tracerName)}
+
+func (t *tracing) start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name, opts...)
+}
+
+// destination returns the attribute identifying dest, a hash of it unless
+// destinations are recorded
+func (t *tracing) destination(dest string) attribute.KeyValue {
+	if t.destinations {
+		return attribute.String("i2p.destination", dest)
+	}
+	return attribute.String("i2p.destination.hash", hashDestination(dest))
+}
+
+// redact replaces the destinations in message with their hashes unless
+// destinations are recorded. SAM errors and DialError name the destination
+// they are about, which may be a name too short to be recognized as one.
+func (t *tracing) redact(message, dest string) string {
+	if t.destinations {
+		return message
+	}
+	if dest != "" {
+		message = strings.ReplaceAll(message, dest, RedactHash.redactDestination(dest))
+	}
+	return RedactHash.redactText(message)
+}
+
+// end ends span, marking it failed when err is set
+func (t *tracing) end(span trace.Span, err error, dest string) {
+	if err != nil {
+		message := t.redact(err.Error(), dest)
+		span.RecordError(errors.New(message))
+		span.SetStatus(codes.Error, message)
+	}
+	span.End()
+}
+
+// retry records a failed attempt of a dial that is retried on the span in ctx
+func (t *tracing) retry(ctx context.Context, attempt int, err error, dest string) {
+	trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+		attribute.Int("i2p.dial.attempt", attempt),
+		attribute.String("error", t.redact(err.Error(), dest)),
+	))
+}
+
+// acceptSpans are the spans of an accepted connection, ended once it is
+// handed out or closed
+type acceptSpans struct {
+	tracing *tracing
+	dest    string
+	accept  trace.Span
+	upgrade trace.Span
+}
+
+// startAccept starts the spans of an inbound connection, which end with
+// endAccept
+func (t *tracing) startAccept(conn *Connection, dest string) {
+	ctx, accept := t.start(context.Background(), "i2p.accept",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(conn.opened),
+		trace.WithAttributes(t.destination(dest), attribute.String("i2p.subsession", conn.subsession)),
+	)
+	_, upgrade := t.start(ctx, "i2p.accept.upgrade")
+	conn.accepting.Store(&acceptSpans{tracing: t, dest: dest, accept: accept, upgrade: upgrade})
+}
+
+// endAccept ends the spans of an accepted connection, if they are still open
+func (c *Connection) endAccept(err error) {
+	spans := c.accepting.Swap(nil)
+	if spans == nil {
+		return
+	}
+	spans.tracing.end(spans.upgrade, err, spans.dest)
+	spans.tracing.end(spans.accept, err, spans.dest)
+}
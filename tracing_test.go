@@ -0,0 +1,148 @@
+package i2p
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newRecordingProvider(t *testing.T) (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	return provider, recorder
+}
+
+// endedSpans returns the ended spans by name
+func endedSpans(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestDialAndAcceptSpans(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	serverProvider, serverSpans := newRecordingProvider(t)
+	server, serverID := newStandInTransport(t, standIn, WithTracing(serverProvider))
+	clientProvider, clientSpans := newRecordingProvider(t)
+	client, _ := newStandInTransport(t, standIn, WithTracing(clientProvider))
+
+	listener, err := server.Listen(standInListenAddr(t, server))
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveEcho(listener)
+	requireEcho(t, client, listener.Multiaddr(), serverID)
+
+	spans := endedSpans(clientSpans)
+	require.Contains(t, spans, "i2p.dial")
+	dial := spans["i2p.dial"]
+	for _, name := range []string{"i2p.dial.connect", "i2p.dial.upgrade"} {
+		require.Contains(t, spans, name)
+		assert.Equal(t, dial.SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+		assert.Equal(t, codes.Unset, spans[name].Status().Code, name)
+	}
+	assert.NotContains(t, spans, "i2p.dial.session", "only blinded destinations need a session")
+	attempts, ok := spanAttribute(spans["i2p.dial.connect"], "i2p.dial.attempts")
+	require.True(t, ok)
+	assert.EqualValues(t, 1, attempts.AsInt64())
+
+	dest, err := MultiAddrToI2PAddr(listener.Multiaddr())
+	require.NoError(t, err)
+	hash, ok := spanAttribute(dial, "i2p.destination.hash")
+	require.True(t, ok)
+	assert.Equal(t, hashDestination(dest), hash.AsString())
+	_, ok = spanAttribute(dial, "i2p.destination")
+	assert.False(t, ok, "destinations are not recorded by default")
+
+	require.Eventually(t, func() bool {
+		spans := endedSpans(serverSpans)
+		return spans["i2p.accept"] != nil && spans["i2p.accept.upgrade"] != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	spans = endedSpans(serverSpans)
+	accept := spans["i2p.accept"]
+	assert.Equal(t, accept.SpanContext().SpanID(), spans["i2p.accept.upgrade"].Parent().SpanID())
+	assert.Equal(t, codes.Unset, accept.Status().Code)
+	subsession, _ := spanAttribute(accept, "i2p.subsession")
+	assert.True(t, strings.HasPrefix(subsession.AsString(), "inboundSession-"))
+}
+
+func TestDialSpanRedactsDestination(t *testing.T) {
+	standIn := newSAMStandIn(t, withStandInAcceptTimeout(20*time.Millisecond))
+	server, serverID := newStandInTransport(t, standIn)
+	addr := standInListenAddr(t, server)
+	dest, err := MultiAddrToI2PAddr(addr)
+	require.NoError(t, err)
+
+	for _, recordDestinations := range []bool{false, true} {
+		var opts []TracingOption
+		if recordDestinations {
+			opts = append(opts, WithDestinationsInSpans())
+		}
+		provider, recorder := newRecordingProvider(t)
+		client, _ := newStandInTransport(t, standIn,
+			WithTracing(provider, opts...),
+			WithDialRetry(DialRetryPolicy{MaxAttempts: 2, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond, Multiplier: 1}),
+		)
+
+		// nobody listens, so the dial fails naming the destination
+		_, err = client.Dial(context.Background(), addr, serverID)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), dest)
+
+		spans := endedSpans(recorder)
+		connect := spans["i2p.dial.connect"]
+		require.NotNil(t, connect)
+		assert.Equal(t, codes.Error, spans["i2p.dial"].Status().Code)
+		assert.Equal(t, codes.Error, connect.Status().Code)
+		require.Len(t, connect.Events(), 2, "the retry and the error")
+		assert.Equal(t, "retry", connect.Events()[0].Name)
+
+		recorded := connect.Status().Description
+		for _, event := range connect.Events() {
+			for _, kv := range event.Attributes {
+				recorded += " " + kv.Value.Emit()
+			}
+		}
+		if recordDestinations {
+			assert.Contains(t, recorded, dest)
+			value, ok := spanAttribute(spans["i2p.dial"], "i2p.destination")
+			require.True(t, ok)
+			assert.Equal(t, dest, value.AsString())
+		} else {
+			assert.NotContains(t, recorded, dest)
+			assert.Contains(t, recorded, hashDestination(dest))
+		}
+	}
+}
+
+func TestHashDestination(t *testing.T) {
+	keys, err := newStandInKeys()
+	require.NoError(t, err)
+	full := string(keys.Addr())
+	assert.Equal(t, hashDestination(full), hashDestination(keys.Addr().Base32()))
+	assert.Len(t, hashDestination(full), 16)
+	assert.NotEqual(t, hashDestination(full), hashDestination("not a destination"))
+}
+
+func TestWithTracingNeedsProvider(t *testing.T) {
+	assert.Error(t, WithTracing(nil)(&I2PTransport{}))
+}
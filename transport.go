@@ -2,9 +2,12 @@ package i2p
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/eyedeekay/sam3"
 	"github.com/eyedeekay/sam3/i2pkeys"
@@ -14,6 +17,8 @@ import (
 	"github.com/libp2p/go-libp2p/core/transport"
 	ma "github.com/multiformats/go-multiaddr"
 	mafmt "github.com/multiformats/go-multiaddr-fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type I2PTransport struct {
@@ -24,16 +29,85 @@ type I2PTransport struct {
 	// Resource manager for connection scope management
 	ResourceManager network.ResourceManager
 
-	sam             *sam3.SAM
 	i2PKeys         i2pkeys.I2PKeys
-	primarySession  *sam3.PrimarySession
-	outboundSession *sam3.StreamSession
-	inboundSession  *sam3.StreamSession
-	//sync.RWMutex
+	primarySession  *samPrimarySession
+	outboundSession *samStreamSession
+	inboundSession  *samStreamSession
+	listenAddr      ma.Multiaddr
+	keyTypes        KeyTypes
+
+	// encrypted LeaseSet2 publishing, see WithEncryptedLeaseSet
+	encryptedLeaseSet bool
+	leaseSetSecret    string
+
+	// set when i2PKeys carry a transient key signed by an offline key, see
+	// NewOfflineKeys
+	offlineSignature     *OfflineSignature
+	offlineExpiryWarning time.Duration
+	offlineExpiryWarn    func(expires time.Time)
+
+	// the SAM bridges in order of preference and the one in use, see
+	// WithSAMEndpoints
+	samEndpoints      []SAMConfig
+	activeEndpoint    int
+	samHealthInterval time.Duration
+	routerEvents      func(SAMRouterEvent)
+
+	// see WithDialRetry, WithMetricsTracer and WithTracing
+	dialRetry     *DialRetryPolicy
+	metricsTracer MetricsTracer
+	tracing       *tracing
+
+	// see WithLogRedaction
+	logRedaction LogRedaction
+
+	// see Diagnostics
+	dialErrors dialErrorLog
+	resolver   resolverStats
+
+	dials dialGroup
+
+	// see WithPeerstorePrefetch
+	prefetch *prefetchJob
+
+	// see WithKeepalive
+	keepalive *KeepaliveConfig
+
+	// shared by all connections, and the limit each gets on its own, see
+	// WithBandwidthLimit and WithConnectionBandwidthLimit
+	bandwidth     *bandwidthLimiter
+	connBandwidth BandwidthLimit
+
+	// closed by Close to stop background work
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// serializes session rebuilds, see republish and failover
+	republishMu sync.Mutex
+
+	// guards the sessions above and everything below
+	mu        sync.RWMutex
+	listeners map[*TransportListener]struct{}
+	conns     map[*Connection]struct{}
+
+	// see WithEventBus, and when the sessions were lost, zero while they are up
+	events eventEmitters
+	lostAt time.Time
+
+	// per-client authorization of the encrypted LeaseSet, see WithClientAuthorization
+	clientAuthType    ClientAuthType
+	authorizedClients map[string]ClientCredential
+
+	// credentials and the sessions used to dial blinded destinations, keyed
+	// by b33 address
+	blindedCredentials map[string]blindedCredential
+	blindedSessions    map[string]*samStreamSession
 }
 
 var _ transport.Transport = &I2PTransport{}
 
+// Option configures an I2PTransport. Options are applied before any SAM
+// session is created, so they can influence how the destination is published.
 type Option func(*I2PTransport) error
 
 type TransportBuilderFunc = func(transport.Upgrader, network.ResourceManager) (*I2PTransport, error)
@@ -41,50 +115,185 @@ type TransportBuilderFunc = func(transport.Upgrader, network.ResourceManager) (*
 // returns a function that when called by go-libp2p, creates an I2PTransport
 // Initializes SAM sessions/tunnel which can take about 4-25 seconds depending
 // on i2p network conditions
+//
+// The transport opens its own SAM connections to the address sam was created
+// with. sam may be nil when the bridge is configured with WithSAMConfig.
 func I2PTransportBuilder(sam *sam3.SAM,
-	i2pKeys i2pkeys.I2PKeys, outboundPort string, rngSeed int) (TransportBuilderFunc, ma.Multiaddr, error) {
+	i2pKeys i2pkeys.I2PKeys, outboundPort string, rngSeed int, opts ...Option) (TransportBuilderFunc, ma.Multiaddr, error) {
+	return I2PTransportBuilderContext(context.Background(), sam, i2pKeys, outboundPort, rngSeed, opts...)
+}
+
+// I2PTransportBuilderContext is I2PTransportBuilder with ctx bounding the
+// creation of the SAM sessions, e.g. to give up on a router that takes too
+// long to build tunnels. ctx does not affect the transport once built.
+func I2PTransportBuilderContext(ctx context.Context, sam *sam3.SAM,
+	i2pKeys i2pkeys.I2PKeys, outboundPort string, rngSeed int, opts ...Option) (TransportBuilderFunc, ma.Multiaddr, error) {
+	i2p := &I2PTransport{
+		samEndpoints:       []SAMConfig{SAMConfig{}.withDefaults()},
+		samHealthInterval:  defaultSAMHealthInterval,
+		i2PKeys:            i2pKeys,
+		keyTypes:           DefaultKeyTypes,
+		listeners:          make(map[*TransportListener]struct{}),
+		conns:              make(map[*Connection]struct{}),
+		bandwidth:          newBandwidthLimiter(BandwidthLimit{}),
+		tracing:            disabledTracing,
+		authorizedClients:  make(map[string]ClientCredential),
+		blindedCredentials: make(map[string]blindedCredential),
+		blindedSessions:    make(map[string]*samStreamSession),
+
+		offlineExpiryWarning: defaultOfflineExpiryWarning,
+		offlineExpiryWarn:    warnOfflineExpiry,
+		closed:               make(chan struct{}),
+	}
+	if sam != nil {
+		config, err := ParseSAMAddress(sam.Config.I2PConfig.Sam())
+		if err != nil {
+			return nil, nil, err
+		}
+		i2p.samEndpoints = []SAMConfig{config.withDefaults()}
+	}
+	for _, opt := range opts {
+		if err := opt(i2p); err != nil {
+			return nil, nil, errorx.Decorate(err, "Failed to apply I2P transport option")
+		}
+	}
+
+	if err := i2p.keyTypes.checkDestination(i2pKeys.Addr()); err != nil {
+		return nil, nil, errorx.Decorate(err, "I2P destination does not match the configured key types")
+	}
+
+	offline, err := ParseOfflineSignature(i2pKeys)
+	if err != nil {
+		return nil, nil, errorx.Decorate(err, "Failed to read I2P private keys")
+	}
+	if offline != nil && !offline.Expires.After(time.Now()) {
+		return nil, nil, fmt.Errorf("offline signature of the destination expired at %s", offline.Expires.UTC().Format(time.RFC3339))
+	}
+	i2p.offlineSignature = offline
+
 	rand.Seed(int64(rngSeed))
 
+	if err := i2p.connectFirstEndpoint(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	listenAddr, err := i2p.publishedAddr()
+	if err != nil {
+		i2p.primarySession.Close()
+		return nil, nil, err
+	}
+	i2p.listenAddr = listenAddr
+	i2p.emit(EvtI2PTunnelsReady{Endpoint: i2p.ActiveSAMEndpoint(), ListenAddr: listenAddr})
+
+	if offline != nil {
+		go i2p.watchOfflineExpiry(offline.Expires)
+	}
+	if i2p.samHealthInterval > 0 {
+		go i2p.watchSAMRouter(i2p.samHealthInterval)
+	}
+	if i2p.prefetch != nil {
+		go i2p.runPrefetch(i2p.prefetch)
+	}
+
+	return func(upgrader transport.Upgrader, rcmgr network.ResourceManager) (*I2PTransport, error) {
+		i2p.Upgrader = upgrader
+		i2p.ResourceManager = rcmgr
+		return i2p, nil
+
+	}, i2p.listenAddr, nil
+}
+
+// createSessions creates the PRIMARY session for our destination, along with
+// the inbound and outbound stream subsessions
+func (i2p *I2PTransport) createSessions(ctx context.Context) error {
 	randSessionSuffix := strconv.Itoa(rand.Int())
 
-	samPrimarySession, err := sam.NewPrimarySession("primarySession-"+randSessionSuffix, i2pKeys, sam3.Options_Default)
+	samPrimarySession, err := newSAMPrimarySession(ctx, i2p.activeSAMConfig(), "primarySession-"+randSessionSuffix, i2p.i2PKeys, i2p.sessionOptions())
 	if err != nil {
-		return nil, nil, errorx.Decorate(err, "Failed to create Primary session with I2P SAM")
+		// wrapped with %w so callers can match ErrSAMAuthentication and
+		// *SAMError, errorx does not support errors.Is
+		return fmt.Errorf("failed to create Primary session with I2P SAM: %w", err)
 	}
 
 	// Create inbound session listening on port 0 (default/any port)
 	// This will accept incoming connections on the default streaming port
-	inboundSession, err := samPrimarySession.NewStreamSubSession("inboundSession-" + randSessionSuffix)
+	inboundSession, err := samPrimarySession.newStreamSubSession(ctx, "inboundSession-"+randSessionSuffix, "0", "0")
 	if err != nil {
-		return nil, nil, errorx.Decorate(err, "Failed to create inboundSession subsession with I2P SAM")
+		samPrimarySession.Close()
+		return errorx.Decorate(err, "Failed to create inboundSession subsession with I2P SAM")
 	}
 
 	// Create outbound session with FROM_PORT=1 to avoid duplicate protocol/port
 	// Java I2P requires unique protocol+port combinations per primary session
 	// Using port 1 for outbound to differentiate from inbound's port 0
-	outboundSession, err := samPrimarySession.NewStreamSubSessionWithPorts("outboundSession-"+randSessionSuffix, "1", "0")
+	outboundSession, err := samPrimarySession.newStreamSubSession(ctx, "outboundSession-"+randSessionSuffix, "1", "0")
 	if err != nil {
-		return nil, nil, errorx.Decorate(err, "Failed to create outbound subsession with I2P SAM")
+		samPrimarySession.Close()
+		return errorx.Decorate(err, "Failed to create outbound subsession with I2P SAM")
 	}
 
-	i2pDestination, err := I2PAddrToMultiAddr(samPrimarySession.Addr().String())
-	if err != nil {
-		return nil, nil, err
+	i2p.mu.Lock()
+	defer i2p.mu.Unlock()
+	select {
+	case <-i2p.closed:
+		// Close ran while the sessions were being created
+		samPrimarySession.Close()
+		return errTransportClosed
+	default:
 	}
+	i2p.primarySession = samPrimarySession
+	i2p.outboundSession = outboundSession
+	i2p.inboundSession = inboundSession
+	return nil
+}
 
-	return func(upgrader transport.Upgrader, rcmgr network.ResourceManager) (*I2PTransport, error) {
-		i2p := &I2PTransport{
-			Upgrader:        upgrader,
-			ResourceManager: rcmgr,
-			sam:             sam,
-			i2PKeys:         i2pKeys,
-			primarySession:  samPrimarySession,
-			outboundSession: outboundSession,
-			inboundSession:  inboundSession,
+// republish rebuilds the SAM sessions so the router picks up changed LeaseSet
+// options. I2CP only reads them when a session is created, so this drops every
+// open I2P connection. Listeners are moved over to the new inbound session.
+// When the router refuses the new sessions, undo restores the previous
+// options and the sessions are rebuilt with them, so listeners keep running
+// with the old LeaseSet.
+func (i2p *I2PTransport) republish(undo func()) error {
+	i2p.republishMu.Lock()
+	defer i2p.republishMu.Unlock()
+
+	i2p.mu.RLock()
+	endpoint := i2p.activeEndpoint
+	i2p.mu.RUnlock()
+
+	listeners := i2p.suspendListeners()
+	err := i2p.resumeListeners(listeners, endpoint)
+	if err == nil {
+		log.Info("republished the LeaseSet")
+		return nil
+	}
+	log.Error("republishing the LeaseSet failed", i2p.logErr(err))
+
+	undo()
+	if restoreErr := i2p.resumeListeners(listeners, endpoint); restoreErr != nil {
+		// the router is likely gone, the health check fails over and
+		// resumes the listeners. Without it nothing would.
+		log.Error("restoring the previous LeaseSet failed", i2p.logErr(restoreErr))
+		if i2p.samHealthInterval == 0 {
+			releaseListeners(listeners)
 		}
-		return i2p, nil
+	}
+	return err
+}
 
-	}, i2pDestination, nil
+// publishedAddr returns the address peers should dial us on. With an
+// encrypted LeaseSet only the blinded b33 address can be looked up. Must be
+// called with i2p.mu held or before the transport is shared.
+func (i2p *I2PTransport) publishedAddr() (ma.Multiaddr, error) {
+	if !i2p.encryptedLeaseSet {
+		return I2PAddrToMultiAddr(i2p.primarySession.Addr().String())
+	}
+
+	blinded, err := NewBlindedAddress(i2p.i2PKeys.Addr(), i2p.leaseSetSecret != "", i2p.clientAuthType != ClientAuthNone)
+	if err != nil {
+		return nil, errorx.Decorate(err, "Failed to derive blinded address for encrypted LeaseSet")
+	}
+	return I2PAddrToMultiAddr(blinded.String())
 }
 
 var dialMatcher = mafmt.Or(
@@ -98,6 +307,24 @@ func (i2p *I2PTransport) CanDial(addr ma.Multiaddr) bool {
 }
 
 func (i2p *I2PTransport) Dial(ctx context.Context, remoteAddress ma.Multiaddr, peerID peer.ID) (transport.CapableConn, error) {
+	dest, _ := MultiAddrToI2PAddr(remoteAddress)
+	ctx, span := i2p.tracing.start(ctx, "i2p.dial",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(i2p.tracing.destination(dest), attribute.String("libp2p.peer_id", peerID.String())),
+	)
+	start := time.Now()
+	conn, err := i2p.dial(ctx, remoteAddress, peerID)
+	i2p.tracing.end(span, err, dest)
+	if err != nil {
+		i2p.recordDialError(dest, err)
+		log.Debug("dial failed", i2p.logDest(dest), i2p.logPeer(peerID), i2p.logErr(err))
+	} else {
+		log.Debug("dialed", i2p.logDest(dest), i2p.logPeer(peerID), "duration", time.Since(start))
+	}
+	return conn, err
+}
+
+func (i2p *I2PTransport) dial(ctx context.Context, remoteAddress ma.Multiaddr, peerID peer.ID) (transport.CapableConn, error) {
 	//In case libp2p tries to dial a non-garlic address, we should error early
 	if !i2p.CanDial(remoteAddress) {
 		return nil, fmt.Errorf("can't dial %q: not a valid I2P address", remoteAddress)
@@ -105,7 +332,8 @@ func (i2p *I2PTransport) Dial(ctx context.Context, remoteAddress ma.Multiaddr, p
 
 	remoteNetAddr, err := MultiAddrToI2PAddr(remoteAddress)
 	if err != nil {
-		return nil, errorx.Decorate(err, "failed to convert multiaddr to I2P address")
+		// wrapped with %w so callers can match the validation errors
+		return nil, fmt.Errorf("can't dial %q: %w", remoteAddress, err)
 	}
 
 	// Check if context is already cancelled before dialing
@@ -113,14 +341,42 @@ func (i2p *I2PTransport) Dial(ctx context.Context, remoteAddress ma.Multiaddr, p
 		return nil, errorx.Decorate(ctx.Err(), "context cancelled before dial attempt")
 	}
 
-	// Dial with context monitoring
-	conn, err := i2p.outboundSession.DialI2P(i2pkeys.I2PAddr(remoteNetAddr))
+	// Blinded destinations that need credentials are dialed from their own session
+	i2p.mu.RLock()
+	dialSession := i2p.outboundSession
+	i2p.mu.RUnlock()
+	if credential, ok := i2p.blindedCredential(remoteNetAddr); ok {
+		sessionCtx, span := i2p.tracing.start(ctx, "i2p.dial.session")
+		dialSession, err = i2p.blindedDialSession(sessionCtx, remoteNetAddr, credential)
+		i2p.tracing.end(span, err, remoteNetAddr)
+		if err != nil {
+			return nil, errorx.Decorate(err, "failed to create session for blinded destination %s", remoteNetAddr)
+		}
+	}
+
+	// Dial with context monitoring, retrying as the retry policy allows.
+	// Concurrent dials to the same destination wait for the first one.
+	dialStart := time.Now()
+	connectCtx, span := i2p.tracing.start(ctx, "i2p.dial.connect")
+	conn, coalesced, err := i2p.dials.do(connectCtx, remoteNetAddr, func() (*samStream, error) {
+		return i2p.dialWithRetry(connectCtx, dialSession, remoteNetAddr)
+	})
+	span.SetAttributes(attribute.Bool("i2p.dial.coalesced", coalesced))
+	i2p.tracing.end(span, err, remoteNetAddr)
+	if coalesced && i2p.metricsTracer != nil {
+		i2p.metricsTracer.DialCoalesced()
+	}
 	if err != nil {
 		// Check if context was cancelled
-		if ctx.Err() != nil {
-			return nil, errorx.Decorate(ctx.Err(), "dial cancelled or timed out")
+		if ctxErr := ctxErr(ctx); ctxErr != nil {
+			var dialErr *DialError
+			if errors.As(err, &dialErr) {
+				return nil, errorx.Decorate(ctxErr, "dial cancelled or timed out after %s", attemptsLabel(dialErr.Attempts))
+			}
+			return nil, errorx.Decorate(ctxErr, "dial cancelled or timed out")
 		}
-		return nil, errorx.Decorate(err, "failed to dial I2P address %s (this may indicate I2P tunnels are not established)", remoteNetAddr)
+		// a *DialError, returned as is so callers can inspect it
+		return nil, err
 	}
 
 	// Check context again after dial
@@ -136,7 +392,7 @@ func (i2p *I2PTransport) Dial(ctx context.Context, remoteAddress ma.Multiaddr, p
 		return nil, fmt.Errorf("DialI2P returned nil connection without error")
 	}
 
-	localAddress, err := I2PAddrToMultiAddr(i2p.outboundSession.LocalAddr().String())
+	localAddress, err := I2PAddrToMultiAddr(dialSession.LocalAddr().String())
 	if err != nil {
 		conn.Close() // Clean up the connection
 		return nil, errorx.Decorate(err, "unable to construct multi-addr from local address")
@@ -147,6 +403,7 @@ func (i2p *I2PTransport) Dial(ctx context.Context, remoteAddress ma.Multiaddr, p
 		conn.Close() // Clean up the connection
 		return nil, errorx.Decorate(err, "failed to construct Connection wrapper")
 	}
+	i2p.setupConnection(outboundConnection, network.DirOutbound, time.Since(dialStart))
 
 	// Verify upgrader is not nil
 	if i2p.Upgrader == nil {
@@ -176,7 +433,9 @@ func (i2p *I2PTransport) Dial(ctx context.Context, remoteAddress ma.Multiaddr, p
 	}
 
 	// Upgrade the connection with proper connection scope
-	upgradedConn, err := i2p.Upgrader.Upgrade(ctx, i2p, outboundConnection, network.DirOutbound, peerID, connScope)
+	upgradeCtx, span := i2p.tracing.start(ctx, "i2p.dial.upgrade")
+	upgradedConn, err := i2p.Upgrader.Upgrade(upgradeCtx, i2p, outboundConnection, network.DirOutbound, peerID, connScope)
+	i2p.tracing.end(span, err, remoteNetAddr)
 	if err != nil {
 		outboundConnection.Close()
 		// Check if context was cancelled during upgrade
@@ -194,28 +453,82 @@ func (i2p *I2PTransport) Dial(ctx context.Context, remoteAddress ma.Multiaddr, p
 		return nil, fmt.Errorf("upgrader returned nil connection without error")
 	}
 
-	return upgradedConn, nil
+	// lets As reach the Connection, see StatsOf
+	return &capableConn{CapableConn: upgradedConn, conn: outboundConnection}, nil
 }
 
 // input argument isn't used because we'll be listening on whichever destination is provided
 // by i2p
 func (i2p *I2PTransport) Listen(_ ma.Multiaddr) (transport.Listener, error) {
-	streamListener, err := i2p.inboundSession.Listen()
-	if err != nil {
-		return nil, errorx.Decorate(err, "Unable to call listen on SAM session")
-	}
+	// held throughout so a republish cannot swap the session under us
+	i2p.mu.Lock()
+	defer i2p.mu.Unlock()
 
-	listener, err := NewTransportListener(streamListener)
+	listener, err := newTransportListener(i2p.inboundSession.listen(), i2p.listenAddr)
 	if err != nil {
 		return nil, errorx.Decorate(err, "Failed to initialize transport listener")
 	}
 
-	return i2p.Upgrader.UpgradeListener(i2p, listener), nil
+	// keep track of the listener so republish and failover can move it to
+	// the new session
+	i2p.listeners[listener] = struct{}{}
+	listener.onClose = func() {
+		i2p.mu.Lock()
+		defer i2p.mu.Unlock()
+		delete(i2p.listeners, listener)
+	}
+	listener.onAcceptError = i2p.checkSAMRouter
+	listener.onConnection = func(conn *Connection) {
+		i2p.setupConnection(conn, network.DirInbound, 0)
+		dest, _ := MultiAddrToI2PAddr(conn.remoteAddr)
+		i2p.tracing.startAccept(conn, dest)
+		log.Debug("accepted stream", i2p.logDest(dest), "subsession", conn.subsession)
+	}
+
+	// lets As reach the Connection under accepted connections, see StatsOf
+	return &upgradedListener{Listener: i2p.Upgrader.UpgradeListener(i2p, listener)}, nil
 }
 
-// Closes all SAM sessions by closing the PRIMARY session
+// setupConnection applies the keepalive and bandwidth limits to a dialed or
+// accepted connection, records where it came from, and tracks it while it is
+// open
+func (i2p *I2PTransport) setupConnection(conn *Connection, dir network.Direction, dialDuration time.Duration) {
+	conn.direction = dir
+	conn.dialDuration = dialDuration
+	if stream, ok := conn.ConnWithoutAddr.(*samStream); ok {
+		conn.subsession = stream.session
+	}
+	if i2p.keepalive != nil {
+		conn.startKeepalive(*i2p.keepalive)
+	}
+
+	i2p.mu.Lock()
+	defer i2p.mu.Unlock()
+	conn.shaper = newShaper(i2p.connBandwidth, i2p.bandwidth)
+	i2p.conns[conn] = struct{}{}
+	conn.onClose = func() {
+		i2p.mu.Lock()
+		defer i2p.mu.Unlock()
+		delete(i2p.conns, conn)
+	}
+}
+
+// Closes all SAM sessions by closing the PRIMARY session, along with any
+// sessions opened to dial blinded destinations
 func (i2p *I2PTransport) Close() {
+	i2p.closeOnce.Do(func() { close(i2p.closed) })
+	i2p.closeBlindedSessions()
+
+	i2p.mu.RLock()
+	defer i2p.mu.RUnlock()
 	i2p.primarySession.Close()
+	// listeners may be suspended waiting for a router to come back
+	for listener := range i2p.listeners {
+		listener.rebind(nil, nil)
+	}
+	if i2p.events != nil {
+		i2p.events.Close()
+	}
 }
 
 // Protocols returns the list of protocols this transport can dial.
@@ -2,7 +2,7 @@ package i2p
 
 import (
 	"context"
-	"log"
+	stdlog "log"
 	"testing"
 	"time"
 
@@ -22,7 +22,7 @@ import (
 
 const SAMHost = "127.0.0.1:7656"
 
-func makeInsecureMuxer(t *testing.T) (peer.ID, sec.SecureTransport) {
+func makeInsecureMuxer(t testing.TB) (peer.ID, sec.SecureTransport) {
 	t.Helper()
 	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
 	require.NoError(t, err)
@@ -48,13 +48,13 @@ func TestBuildI2PTransport(t *testing.T) {
 }
 
 func setupClient(t *testing.T, serverAddr i2pkeys.I2PAddr, serverPeerID peer.ID, randNum int) {
-	log.Println("Starting client setup")
+	stdlog.Println("Starting client setup")
 	sam, err := sam3.NewSAM(SAMHost)
 	if err != nil {
 		assert.Fail(t, "Failed to connect to SAM")
 		return
 	}
-	keys, err := sam.NewKeys()
+	keys, err := GenerateKeys(sam, DefaultKeyTypes)
 	if err != nil {
 		assert.Fail(t, "Failed to generate keys")
 		return
@@ -64,7 +64,7 @@ func setupClient(t *testing.T, serverAddr i2pkeys.I2PAddr, serverPeerID peer.ID,
 	assert.NoError(t, err)
 
 	peerID, sm := makeInsecureMuxer(t)
-	log.Println("Client Peer ID is: " + peerID.String())
+	stdlog.Println("Client Peer ID is: " + peerID.String())
 
 	// Create resource manager with infinite limits for testing
 	rcmgr, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(rcmgr.InfiniteLimits))
@@ -87,16 +87,16 @@ func setupClient(t *testing.T, serverAddr i2pkeys.I2PAddr, serverPeerID peer.ID,
 	require.NoError(t, err)
 
 	serverMultiAddr, err := I2PAddrToMultiAddr(string(serverAddr))
-	log.Println("Dialing host on this destination: " + serverMultiAddr.String())
+	stdlog.Println("Dialing host on this destination: " + serverMultiAddr.String())
 
 	for i := 0; i < 5; i++ {
-		log.Println("Starting dial")
+		stdlog.Println("Starting dial")
 		conn, err := secureTransport.Dial(context.TODO(), serverMultiAddr, serverPeerID)
 		if err != nil {
 			assert.Fail(t, "Failed to dial", err)
 			return
 		}
-		log.Println("Opening Stream")
+		stdlog.Println("Opening Stream")
 		stream, err := conn.OpenStream(context.TODO())
 		if err != nil {
 			assert.Fail(t, "Failed to open outbound stream", err)
@@ -115,7 +115,7 @@ func setupServer(t *testing.T, addrChan chan *ServerInfo) {
 		addrChan <- nil
 		return
 	}
-	keys, err := sam.NewKeys()
+	keys, err := GenerateKeys(sam, DefaultKeyTypes)
 	if err != nil {
 		assert.Fail(t, "Failed to generate keys", err)
 		addrChan <- nil
@@ -127,7 +127,7 @@ func setupServer(t *testing.T, addrChan chan *ServerInfo) {
 	assert.NoError(t, err)
 
 	peerID, sm := makeInsecureMuxer(t)
-	log.Println("Server Peer ID is: " + peerID.String())
+	stdlog.Println("Server Peer ID is: " + peerID.String())
 
 	// Create resource manager with infinite limits for testing
 	rcmgr, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(rcmgr.InfiniteLimits))
@@ -161,7 +161,7 @@ func setupServer(t *testing.T, addrChan chan *ServerInfo) {
 		peerID,
 	}
 	addrChan <- serverInfo
-	log.Println("Listener Addr: " + listener.Addr().String())
+	stdlog.Println("Listener Addr: " + listener.Addr().String())
 
 	for i := 0; i < 5; i++ {
 		capableConnection, err := listener.Accept()
@@ -174,7 +174,7 @@ func setupServer(t *testing.T, addrChan chan *ServerInfo) {
 		buf := make([]byte, 1024)
 		_, err = stream.Read(buf)
 		stream.Write([]byte(capableConnection.LocalMultiaddr().String()))
-		log.Println(capableConnection.RemoteMultiaddr())
+		stdlog.Println(capableConnection.RemoteMultiaddr())
 
 		stream.Close()
 	}
@@ -3,47 +3,187 @@ package i2p
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	ma "github.com/multiformats/go-multiaddr"
 )
 
+// a base64 destination is at least 387 bytes, anything shorter is a b32 or b33 name
+const minBase64DestinationLength = 516
+
+const (
+	// a b32 name encodes the 32 byte SHA-256 of a destination
+	b32NameLength = 52
+	// a b33 name encodes at least b33MinDecodedLength bytes
+	b33MinNameLength = 56
+)
+
+// Errors returned by the address validation, wrapped with the details of what
+// is wrong. Match them with errors.Is.
+var (
+	// ErrInvalidBase64 is returned for destinations that do not decode as
+	// I2P base64
+	ErrInvalidBase64 = errors.New("destination is not valid I2P base64")
+	// ErrStandardBase64 is returned for destinations written in the standard
+	// base64 alphabet, I2P uses - and ~ in place of + and /
+	ErrStandardBase64 = errors.New("destination uses standard base64")
+	// ErrDestinationLength is returned for destinations too short to hold the
+	// keys and certificate header, or longer than their certificate says
+	ErrDestinationLength = errors.New("destination has the wrong length")
+	// ErrDestinationCertificate is returned for destinations whose
+	// certificate is malformed or of an unsupported type
+	ErrDestinationCertificate = errors.New("invalid destination certificate")
+	// ErrInvalidB32Name is returned for .b32.i2p names, b32 or blinded b33,
+	// that do not decode
+	ErrInvalidB32Name = errors.New("invalid b32 name")
+	// ErrB32Suffix is returned for names with a suffix other than .b32.i2p
+	ErrB32Suffix = errors.New("invalid b32 name suffix")
+)
+
+// ValidateI2PAddr checks an address in either form I2PAddrToMultiAddr
+// accepts: a .b32.i2p name, b32 or b33, with or without the suffix, or a
+// base64 destination.
+func ValidateI2PAddr(addr string) error {
+	if isB32Name(addr) {
+		return ValidateB32Name(addr)
+	}
+	return ValidateDestination(addr)
+}
+
+// isB32Name tells names from destinations: names have a suffix or are
+// shorter than any destination and use only the base32 alphabet, in either
+// case so that upper case names are reported as such
+func isB32Name(addr string) bool {
+	if strings.Contains(addr, ".") {
+		return true
+	}
+	return len(addr) < minBase64DestinationLength && strings.Trim(strings.ToLower(addr), i2pB32Alphabet) == ""
+}
+
+// ValidateB32Name checks a .b32.i2p name, with or without the suffix. A
+// name of 52 characters is the hash of a destination, a longer one is a
+// blinded b33 address and must decode as one, see ParseBlindedAddress.
+func ValidateB32Name(name string) error {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		if suffix := name[i:]; suffix != b32Suffix {
+			return fmt.Errorf("%w: %q, expected %s", ErrB32Suffix, suffix, b32Suffix)
+		}
+		name = name[:i]
+	}
+	if i := strings.IndexFunc(name, func(r rune) bool { return !strings.ContainsRune(i2pB32Alphabet, r) }); i >= 0 {
+		return fmt.Errorf("%w: invalid character %q at offset %d, the alphabet is a-z and 2-7", ErrInvalidB32Name, name[i], i)
+	}
+
+	switch {
+	case len(name) == b32NameLength:
+		raw, err := i2pB32Encoding.DecodeString(name)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidB32Name, err)
+		}
+		// the last character carries 4 bits of padding, which must be zero
+		if i2pB32Encoding.EncodeToString(raw) != name {
+			return fmt.Errorf("%w: the padding bits of the last character are set", ErrInvalidB32Name)
+		}
+	case len(name) >= b33MinNameLength:
+		blinded, err := ParseBlindedAddress(name)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidB32Name, err)
+		}
+		if blinded.String() != name+b32Suffix {
+			return fmt.Errorf("%w: blinded address is not in canonical form", ErrInvalidB32Name)
+		}
+	default:
+		return fmt.Errorf("%w: %d characters, expected %d, or at least %d for a blinded b33 name", ErrInvalidB32Name, len(name), b32NameLength, b33MinNameLength)
+	}
+	return nil
+}
+
+// ValidateDestination checks a base64 destination: the I2P alphabet, a
+// length covering the keys and the certificate exactly, and a null
+// certificate or a key certificate of a supported signature type.
+func ValidateDestination(dest string) error {
+	if i := strings.IndexAny(dest, "+/"); i >= 0 {
+		return fmt.Errorf("%w: %q at offset %d, I2P uses - and ~ in place of + and /", ErrStandardBase64, dest[i], i)
+	}
+	if i := strings.IndexFunc(strings.TrimRight(dest, "="), func(r rune) bool { return !strings.ContainsRune(i2pB64Alphabet, r) }); i >= 0 {
+		return fmt.Errorf("%w: invalid character %q at offset %d", ErrInvalidBase64, dest[i], i)
+	}
+	raw, err := i2pB64Encoding.Strict().DecodeString(dest)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBase64, err)
+	}
+	if len(raw) < destMinLength {
+		return fmt.Errorf("%w: %d bytes, expected at least %d", ErrDestinationLength, len(raw), destMinLength)
+	}
+
+	parsed, err := parseDestinationBytes(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDestinationCertificate, err)
+	}
+	if extra := len(raw) - len(parsed.raw); extra > 0 {
+		return fmt.Errorf("%w: %d bytes after the certificate", ErrDestinationLength, extra)
+	}
+	certLength := len(raw) - destMinLength
+	switch raw[destKeysLength] {
+	case certTypeNull:
+		if certLength != 0 {
+			return fmt.Errorf("%w: null certificate with a %d byte payload", ErrDestinationCertificate, certLength)
+		}
+	case certTypeKey:
+		if _, ok := encTypePrivateKeyLength[parsed.encType]; !ok {
+			return fmt.Errorf("%w: unsupported encryption type %d", ErrDestinationCertificate, parsed.encType)
+		}
+		// the payload holds the types and the part of the signing key that
+		// does not fit its field
+		expected := keyCertPayloadMinSize + max(0, sigTypePublicKeyLength[parsed.sigType]-destSigningKeyLength)
+		if certLength != expected {
+			return fmt.Errorf("%w: key certificate payload is %d bytes, signature type %d needs %d", ErrDestinationCertificate, certLength, parsed.sigType, expected)
+		}
+	}
+	return nil
+}
+
+// MultiAddrToI2PAddr returns the I2P address of a /garlic64 or /garlic32
+// multiaddr, the destination or the .b32.i2p name. The address is validated,
+// see ValidateDestination and ValidateB32Name.
 func MultiAddrToI2PAddr(addr ma.Multiaddr) (string, error) {
 	numProtocols := len(addr.Protocols())
 	if numProtocols != 1 {
 		return "", errors.New(fmt.Sprintf("Expected 1 protocols in multiaddr but found %d", numProtocols))
 	}
 
-	destination, err := addr.ValueForProtocol(addr.Protocols()[0].Code)
+	protocol := addr.Protocols()[0]
+	destination, err := addr.ValueForProtocol(protocol.Code)
 	if err != nil {
 		return "", err
 	}
 
-	if len(destination) <= 55 {
-		destination += ".b32.i2p"
+	switch protocol.Code {
+	case ma.P_GARLIC64:
+		err = ValidateDestination(destination)
+	case ma.P_GARLIC32:
+		//garlic32 carries both regular b32 and blinded b33 names, SAM wants the suffix on both
+		destination += b32Suffix
+		err = ValidateB32Name(destination)
+	default:
+		return "", fmt.Errorf("expected a /garlic64 or /garlic32 multiaddr, not /%s", protocol.Name)
+	}
+	if err != nil {
+		return "", err
 	}
-
 	return destination, nil
 }
 
-//expects either a base32 or base64 i2p destination
-//expects there to be no :port suffix to the address
+// expects either a base32 (b32 or blinded b33) or base64 i2p destination
+// expects there to be no :port suffix to the address
 func I2PAddrToMultiAddr(addr string) (ma.Multiaddr, error) {
-	if len(addr) < 52 {
-		return nil, errors.New("Address too short for a i2p")
+	if err := ValidateI2PAddr(addr); err != nil {
+		return nil, err
 	}
 
-	garlicBase := "/garlic64/"
-
-	//handle base32 destinations
-	//55 for max address and 8 extra for .b32.i2p suffix
-	if len(addr) <= 63 {
-		//check to see if the address has a .b32.i2p suffix
-		//if exists, remove
-		if addr[len(addr)-8:] == ".b32.i2p" {
-			addr = addr[:len(addr)-8]
-		}
-		garlicBase = "/garlic32/"
+	//names go in /garlic32 without the .b32.i2p suffix, destinations in /garlic64
+	if isB32Name(addr) {
+		return ma.NewMultiaddr("/garlic32/" + strings.TrimSuffix(addr, b32Suffix))
 	}
-
-	return ma.NewMultiaddr(garlicBase + addr)
+	return ma.NewMultiaddr("/garlic64/" + addr)
 }
@@ -1,9 +1,16 @@
 package i2p
 
 import (
+	"context"
+	"encoding/binary"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/eyedeekay/sam3/i2pkeys"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const base64Addr = "jT~IyXaoauTni6N4517EG8mrFUKpy0IlgZh-EY9csMAk82Odatmzr~YTZy8Hv7u~wvkg75EFNOyqb~nAPg-khyp2TS~ObUz8WlqYAM2VlEzJ7wJB91P-cUlKF18zSzVoJFmsrcQHZCirSbWoOknS6iNmsGRh5KVZsBEfp1Dg3gwTipTRIx7Vl5Vy~1OSKQVjYiGZS9q8RL0MF~7xFiKxZDLbPxk0AK9TzGGqm~wMTI2HS0Gm4Ycy8LYPVmLvGonIBYndg2bJC7WLuF6tVjVquiokSVDKFwq70BCUU5AU-EvdOD5KEOAM7mPfw-gJUG4tm1TtvcobrObqoRnmhXPTBTN5H7qDD12AvlwFGnfAlBXjuP4xOUAISL5SRLiulrsMSiT4GcugSI80mF6sdB0zWRgL1yyvoVWeTBn1TqjO27alr95DGTluuSqrNAxgpQzCKEWAyzrQkBfo2avGAmmz2NaHaAvYbOg0QSJz1PLjv2jdPW~ofiQmrGWM1cd~1cCqAAAA"
@@ -41,3 +48,172 @@ func TestMultiAddrToI2PAddr(t *testing.T) {
 	assert.Equal(t, base64Addr, addr2)
 
 }
+
+func TestBlindedAddrMultiAddrRoundTrip(t *testing.T) {
+	dest, _ := testEd25519Destination(t)
+	blinded, err := NewBlindedAddress(dest, false, false)
+	assert.NoError(t, err)
+	b33Suffix := blinded.String()
+	b33 := strings.TrimSuffix(b33Suffix, ".b32.i2p")
+
+	multiAddr, err := I2PAddrToMultiAddr(b33Suffix)
+	assert.NoError(t, err)
+	assert.Equal(t, "/garlic32/"+b33, multiAddr.String())
+
+	multiAddr2, err := I2PAddrToMultiAddr(b33)
+	assert.NoError(t, err)
+	assert.Equal(t, "/garlic32/"+b33, multiAddr2.String())
+
+	addr, err := MultiAddrToI2PAddr(multiAddr)
+	assert.NoError(t, err)
+	assert.Equal(t, b33Suffix, addr)
+}
+
+// testDestinationBytes returns the raw form of a destination, modified by edit
+func testDestinationBytes(t *testing.T, dest i2pkeys.I2PAddr, edit func([]byte) []byte) string {
+	t.Helper()
+	raw, err := i2pB64Encoding.DecodeString(string(dest))
+	require.NoError(t, err)
+	return i2pB64Encoding.EncodeToString(edit(raw))
+}
+
+func TestValidateI2PAddr(t *testing.T) {
+	ed25519Dest, _ := testEd25519Destination(t)
+	blinded, err := NewBlindedAddress(ed25519Dest, false, false)
+	require.NoError(t, err)
+	b33 := blinded.String()
+
+	for _, addr := range []string{base64Addr, string(ed25519Dest), base32Addr, base32AddrSuffix, b33, strings.TrimSuffix(b33, b32Suffix)} {
+		assert.NoError(t, ValidateI2PAddr(addr), addr)
+	}
+
+	setKeyCert := func(sigType, encType uint16, extra int) func([]byte) []byte {
+		return func(raw []byte) []byte {
+			raw = append(raw[:destKeysLength], certTypeKey)
+			raw = binary.BigEndian.AppendUint16(raw, uint16(keyCertPayloadMinSize+extra))
+			raw = binary.BigEndian.AppendUint16(raw, sigType)
+			raw = binary.BigEndian.AppendUint16(raw, encType)
+			return append(raw, make([]byte, extra)...)
+		}
+	}
+	tests := []struct {
+		name string
+		addr string
+		err  error
+	}{
+		{"standard base64", strings.Replace(base64Addr, "~", "/", 1), ErrStandardBase64},
+		{"invalid character", base64Addr[:10] + "!" + base64Addr[11:], ErrInvalidBase64},
+		{"extra padding", base64Addr + "==", ErrInvalidBase64},
+		{"truncated", base64Addr[:400], ErrDestinationLength},
+		{"trailing bytes", testDestinationBytes(t, base64Addr, func(raw []byte) []byte { return append(raw, 0, 0, 0) }), ErrDestinationLength},
+		{"null certificate with payload", testDestinationBytes(t, base64Addr, func(raw []byte) []byte {
+			raw[destKeysLength+2] = 3
+			return append(raw, 0, 0, 0)
+		}), ErrDestinationCertificate},
+		{"unknown certificate type", testDestinationBytes(t, base64Addr, func(raw []byte) []byte {
+			raw[destKeysLength] = 1
+			return raw
+		}), ErrDestinationCertificate},
+		{"truncated certificate", testDestinationBytes(t, ed25519Dest, func(raw []byte) []byte { return raw[:len(raw)-1] }), ErrDestinationCertificate},
+		{"unknown signature type", testDestinationBytes(t, ed25519Dest, setKeyCert(99, encTypeElGamal, 0)), ErrDestinationCertificate},
+		{"unknown encryption type", testDestinationBytes(t, ed25519Dest, setKeyCert(sigTypeEd25519, 9, 0)), ErrDestinationCertificate},
+		{"oversized key certificate", testDestinationBytes(t, ed25519Dest, setKeyCert(sigTypeEd25519, encTypeECIESX25519, 2)), ErrDestinationCertificate},
+		{"missing signing key bytes", testDestinationBytes(t, ed25519Dest, setKeyCert(sigTypeECDSASHA512, encTypeElGamal, 0)), ErrDestinationCertificate},
+		{"b32 wrong length", base32Addr + "a", ErrInvalidB32Name},
+		{"b32 upper case", strings.ToUpper(base32Addr[:1]) + base32Addr[1:], ErrInvalidB32Name},
+		{"b32 padding bits", base32Addr[:51] + "b", ErrInvalidB32Name},
+		{"b33 corrupted", b33[:10] + string(b33[10]^1) + b33[11:], ErrInvalidB32Name},
+		{"address book name", "example.i2p", ErrB32Suffix},
+		{"b32 misspelled suffix", base32Addr + ".b32.ip2", ErrB32Suffix},
+		{"b32 trailing dot", base32AddrSuffix + ".", ErrB32Suffix},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateI2PAddr(test.addr)
+			require.ErrorIs(t, err, test.err)
+
+			_, err = I2PAddrToMultiAddr(test.addr)
+			require.ErrorIs(t, err, test.err)
+		})
+	}
+}
+
+func TestMultiAddrToI2PAddrValidates(t *testing.T) {
+	// long enough for a b33 name, but not one
+	_, err := MultiAddrToI2PAddr(ma.StringCast("/garlic32/" + strings.Repeat("a", b33MinNameLength)))
+	assert.ErrorIs(t, err, ErrInvalidB32Name)
+
+	// the multiaddr encoding only checks the destination's minimum length
+	trailing := testDestinationBytes(t, base64Addr, func(raw []byte) []byte { return append(raw, 0, 0, 0) })
+	_, err = MultiAddrToI2PAddr(ma.StringCast("/garlic64/" + trailing))
+	assert.ErrorIs(t, err, ErrDestinationLength)
+
+	_, err = MultiAddrToI2PAddr(ma.StringCast("/ip4/127.0.0.1"))
+	assert.ErrorContains(t, err, "expected a /garlic64 or /garlic32 multiaddr")
+}
+
+func TestDialRejectsInvalidDestination(t *testing.T) {
+	standIn := newSAMStandIn(t)
+	client, _ := newStandInTransport(t, standIn)
+	_, serverID := newStandInTransport(t, standIn)
+
+	trailing := testDestinationBytes(t, base64Addr, func(raw []byte) []byte { return append(raw, 0, 0, 0) })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := client.Dial(ctx, ma.StringCast("/garlic64/"+trailing), serverID)
+	require.ErrorIs(t, err, ErrDestinationLength)
+}
+
+// FuzzI2PAddrToMultiAddr checks that converting never panics and that an
+// accepted address survives the multiaddr encodings and the way back
+func FuzzI2PAddrToMultiAddr(f *testing.F) {
+	f.Add(base64Addr)
+	f.Add(base32Addr)
+	f.Add(base32AddrSuffix)
+	f.Fuzz(func(t *testing.T, addr string) {
+		multiAddr, err := I2PAddrToMultiAddr(addr)
+		if err != nil {
+			return
+		}
+		requireStableMultiaddr(t, multiAddr)
+
+		name, err := MultiAddrToI2PAddr(multiAddr)
+		require.NoError(t, err, "%q converted to %s", addr, multiAddr)
+		again, err := I2PAddrToMultiAddr(name)
+		require.NoError(t, err, name)
+		require.Equal(t, multiAddr.String(), again.String())
+	})
+}
+
+// FuzzMultiAddrToI2PAddr checks that converting never panics and that a
+// garlic multiaddr comes back unchanged from the name it converts to
+func FuzzMultiAddrToI2PAddr(f *testing.F) {
+	f.Add("/garlic64/" + base64Addr)
+	f.Add("/garlic32/" + base32Addr)
+	f.Fuzz(func(t *testing.T, s string) {
+		multiAddr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return
+		}
+		name, err := MultiAddrToI2PAddr(multiAddr)
+		if err != nil {
+			return
+		}
+		if code := multiAddr.Protocols()[0].Code; code != ma.P_GARLIC64 && code != ma.P_GARLIC32 {
+			return
+		}
+		again, err := I2PAddrToMultiAddr(name)
+		require.NoError(t, err, "%s converted to %q", multiAddr, name)
+		require.Equal(t, multiAddr.String(), again.String())
+	})
+}
+
+func requireStableMultiaddr(t *testing.T, multiAddr ma.Multiaddr) {
+	t.Helper()
+	decoded, err := ma.NewMultiaddrBytes(multiAddr.Bytes())
+	require.NoError(t, err, multiAddr.String())
+	require.True(t, multiAddr.Equal(decoded), "%s decoded to %s", multiAddr, decoded)
+	parsed, err := ma.NewMultiaddr(multiAddr.String())
+	require.NoError(t, err, multiAddr.String())
+	require.True(t, multiAddr.Equal(parsed), "%s parsed to %s", multiAddr, parsed)
+}